@@ -0,0 +1,193 @@
+package backfill
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/strava"
+)
+
+// DefaultRequestsPerActivity is the Strava rate budget reserved for each
+// upload: the upload itself, a few status polls and the metadata update.
+const DefaultRequestsPerActivity = 6
+
+// Queue is the persistent work list of a backfill: every Garmin Connect
+// activity since a date, oldest first, and a cursor marking how far the
+// backfill has got.
+type Queue struct {
+	Since      time.Time     `json:"since"`
+	Created    time.Time     `json:"created"`
+	Activities []gc.Activity `json:"activities"`
+	Cursor     int           `json:"cursor"`
+	// Failed lists the IDs of activities that could not be uploaded.
+	Failed []int64 `json:"failed,omitempty"`
+	// RateLimit is the Strava usage last seen, so that a resumed backfill
+	// knows whether the budget has reset before making any requests.
+	RateLimit strava.RateLimit `json:"rateLimit"`
+	path      string
+}
+
+// NewQueue creates a queue of activities to be saved at path.
+func NewQueue(path string, since time.Time, activities []gc.Activity) *Queue {
+	sorted := append([]gc.Activity(nil), activities...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].StartTime.Before(sorted[j].StartTime) })
+	return &Queue{Since: since, Created: time.Now(), Activities: sorted, path: path}
+}
+
+// Load reads the queue saved at path, returning nil if there is none.
+func Load(path string) (*Queue, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var q Queue
+	if err := json.Unmarshal(data, &q); err != nil {
+		return nil, fmt.Errorf("Backfill queue %s: %v", path, err)
+	}
+	if q.Cursor < 0 || q.Cursor > len(q.Activities) {
+		return nil, fmt.Errorf("Backfill queue %s: cursor %d out of range", path, q.Cursor)
+	}
+	q.path = path
+	return &q, nil
+}
+
+// Save writes the queue, replacing the previous copy atomically.
+func (q *Queue) Save() error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// Remove deletes the saved queue.
+func (q *Queue) Remove() error {
+	if err := os.Remove(q.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Remaining returns the number of activities not yet processed.
+func (q *Queue) Remaining() int {
+	return len(q.Activities) - q.Cursor
+}
+
+// Options configures Run.
+type Options struct {
+	// RateLimit returns Strava's current usage, normally the client's
+	// RateLimit method.
+	RateLimit func() strava.RateLimit
+	// RequestsPerActivity is reserved from the rate budget before each
+	// upload, DefaultRequestsPerActivity if zero.
+	RequestsPerActivity int
+	// Wait sleeps until the rate limit resets instead of stopping.
+	Wait bool
+	// Waiting is called before sleeping, with the time the budget resets.
+	Waiting func(until time.Time)
+	// Now and Sleep default to time.Now and time.Sleep.
+	Now   func() time.Time
+	Sleep func(time.Duration)
+}
+
+// Result summarises one invocation of Run.
+type Result struct {
+	Processed int
+	Failed    int
+	// ResumeAt is when the rate budget resets, if Run stopped because it
+	// was spent; zero if the queue was finished.
+	ResumeAt time.Time
+}
+
+// Run works through the queue from its cursor, saving it after every
+// activity. Activities already in the ledger or skipped by a rule cost no
+// Strava requests and are passed over. Before each upload Run checks the
+// rate budget; once it is spent Run saves the queue and returns, or with
+// Wait sleeps until the limit window resets. Activities that fail to upload
+// are noted in Failed and the backfill moves on.
+func Run(q *Queue, syncer *pipeline.Syncer, options Options) (Result, error) {
+	if options.RequestsPerActivity <= 0 {
+		options.RequestsPerActivity = DefaultRequestsPerActivity
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	if options.Sleep == nil {
+		options.Sleep = time.Sleep
+	}
+	var result Result
+	for q.Remaining() > 0 {
+		activity := q.Activities[q.Cursor]
+		if !syncer.Skip(&activity) {
+			resumeAt, err := q.awaitBudget(options)
+			if err != nil || !resumeAt.IsZero() {
+				result.ResumeAt = resumeAt
+				return result, err
+			}
+			if err := syncer.Upload(&activity); err != nil {
+				q.Failed = append(q.Failed, activity.ID)
+				result.Failed++
+			}
+			if options.RateLimit != nil {
+				q.RateLimit = options.RateLimit()
+			}
+		}
+		q.Cursor++
+		result.Processed++
+		if err := q.Save(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// awaitBudget returns once the rate budget allows another upload. If it is
+// spent and Wait is not set it saves the queue and returns when the budget
+// resets instead.
+func (q *Queue) awaitBudget(options Options) (time.Time, error) {
+	for {
+		now := options.Now()
+		wait := q.rateLimit(options.RateLimit).Wait(now, options.RequestsPerActivity)
+		if wait <= 0 {
+			return time.Time{}, nil
+		}
+		if err := q.Save(); err != nil {
+			return time.Time{}, err
+		}
+		if !options.Wait {
+			return now.Add(wait), nil
+		}
+		if options.Waiting != nil {
+			options.Waiting(now.Add(wait))
+		}
+		options.Sleep(wait)
+	}
+}
+
+// rateLimit returns the live usage, or the usage saved with the queue until
+// the client has seen a response.
+func (q *Queue) rateLimit(live func() strava.RateLimit) strava.RateLimit {
+	if live != nil {
+		if limit := live(); !limit.UpdatedAt.IsZero() {
+			return limit
+		}
+	}
+	return q.RateLimit
+}
@@ -0,0 +1,118 @@
+package backfill
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/strava"
+	"github.com/icalder/gravasync/tcx"
+)
+
+type fakeGarmin struct {
+	gc.GarminConnect
+}
+
+func (f *fakeGarmin) Export(activityID int64, format string) (io.ReadCloser, error) {
+	start := time.Date(2015, 1, 1, 9, 0, 0, 0, time.UTC).Add(time.Duration(activityID) * time.Hour)
+	lap := tcx.Lap{StartTime: start, TotalTimeSeconds: 60, DistanceMeters: 200, Intensity: "Active", TriggerMethod: "Manual"}
+	for i := 0; i < 2; i++ {
+		lap.Track = append(lap.Track, tcx.Trackpoint{Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+	db := tcx.Database{Activities: []tcx.Activity{{Sport: "Running", ID: start.Format(time.RFC3339), Laps: []tcx.Lap{lap}}}}
+	data, err := db.Bytes()
+	return ioutil.NopCloser(bytes.NewReader(data)), err
+}
+
+// fakeStrava spends DefaultRequestsPerActivity of a daily limit on each
+// upload.
+type fakeStrava struct {
+	strava.Strava
+	now     *time.Time
+	limit   strava.RateLimit
+	uploads []string
+}
+
+func (f *fakeStrava) Import(name string, private bool, dataType string, data io.Reader) (*strava.Upload, error) {
+	f.uploads = append(f.uploads, name)
+	f.limit = f.RateLimit()
+	f.limit.LongUsage += DefaultRequestsPerActivity
+	return &strava.Upload{ID: int64(len(f.uploads))}, nil
+}
+
+func (f *fakeStrava) WaitForUpload(upload *strava.Upload, timeout time.Duration) (*strava.Upload, error) {
+	return &strava.Upload{ID: upload.ID, ActivityID: 1000 + upload.ID}, nil
+}
+
+func (f *fakeStrava) RateLimit() strava.RateLimit {
+	if !f.limit.UpdatedAt.IsZero() && f.now.Day() != f.limit.UpdatedAt.Day() {
+		f.limit.LongUsage = 0
+	}
+	f.limit.UpdatedAt = *f.now
+	return f.limit
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(ledger.Entry{GarminID: 2, StravaActivityID: 99}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2018, 1, 2, 20, 0, 0, 0, time.UTC)
+	destination := &fakeStrava{now: &now, limit: strava.RateLimit{LongLimit: 2 * DefaultRequestsPerActivity}}
+	syncer, err := pipeline.New(&fakeGarmin{}, destination, pipeline.Options{Ledger: l, SkipValidation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var activities []gc.Activity
+	for i := 4; i > 0; i-- {
+		activities = append(activities, gc.Activity{ID: int64(i), Name: "Run", StartTime: now.AddDate(-3, 0, i)})
+	}
+	path := filepath.Join(dir, "backfill.json")
+	q := NewQueue(path, now.AddDate(-4, 0, 0), activities)
+	options := Options{RateLimit: destination.RateLimit, Now: func() time.Time { return now }}
+
+	result, err := Run(q, syncer, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Processed != 3 || len(destination.uploads) != 2 || !result.ResumeAt.Equal(time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("result = %+v, uploads = %v", result, destination.uploads)
+	}
+
+	q, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Cursor != 3 || q.Activities[q.Cursor].ID != 4 || q.RateLimit.LongUsage != 2*DefaultRequestsPerActivity {
+		t.Fatalf("saved queue = %+v", q)
+	}
+
+	var waited []time.Time
+	options.Wait = true
+	options.Waiting = func(until time.Time) { waited = append(waited, until) }
+	options.Sleep = func(d time.Duration) { now = now.Add(d) }
+	if result, err = Run(q, syncer, options); err != nil {
+		t.Fatal(err)
+	}
+	if len(waited) != 1 || result.Processed != 1 || !result.ResumeAt.IsZero() || q.Remaining() != 0 {
+		t.Fatalf("result = %+v, waited = %v", result, waited)
+	}
+	if entry, ok := l.Get(4); !ok || entry.StravaActivityID != 1003 {
+		t.Fatalf("ledger entry = %+v", entry)
+	}
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/icalder/gravasync/credentials"
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/strava"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Log in to, check and log out of Strava and Garmin Connect",
+}
+
+var authStravaCmd = &cobra.Command{
+	Use:   "strava",
+	Short: "Manage the Strava access token",
+}
+
+var authGarminCmd = &cobra.Command{
+	Use:   "garmin",
+	Short: "Manage the Garmin Connect credentials",
+}
+
+var stravaTokenKeys = []string{"strava.accessToken", "strava.refreshToken", "strava.scopes", "strava.tokenExpiresAt"}
+var garminCredentialKeys = []string{"garmin.username", "garmin.password"}
+
+var authStravaLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Authorise gravasync with Strava and save the access token",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		stravaClient := strava.NewStrava()
+		if err := authoriseStrava(stravaClient, p, secrets); err != nil {
+			return err
+		}
+		athlete, err := stravaClient.Athlete()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Logged in to Strava as", athlete)
+		return nil
+	},
+}
+
+var authStravaStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the Strava athlete, scopes and token expiry",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		accessToken, err := resolveSecret(p, "strava.accessToken", secrets)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Profile:", p.name)
+		if accessToken == "" {
+			fmt.Println("Not logged in to Strava")
+			return nil
+		}
+		stravaClient, err := newStravaClient(p, secrets, false)
+		if err != nil {
+			return err
+		}
+		athlete, err := stravaClient.Athlete()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Athlete:", athlete)
+		if scopes, _ := resolveSecret(p, "strava.scopes", secrets); scopes != "" {
+			fmt.Println("Scopes:", scopes)
+		}
+		if expiresAt, _ := resolveSecret(p, "strava.tokenExpiresAt", secrets); expiresAt != "" {
+			fmt.Println("Token expires:", expiresAt)
+		}
+		return nil
+	},
+}
+
+var authStravaLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke the Strava access token and delete it",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		accessToken, err := resolveSecret(p, "strava.accessToken", secrets)
+		if err != nil {
+			return err
+		}
+		if accessToken != "" {
+			// The stored token is deleted even if it cannot be revoked, e.g.
+			// because access was already revoked in Strava's settings.
+			if err := revokeStrava(p, secrets); err != nil {
+				slog.Warn("Could not revoke Strava access", "err", err)
+			} else {
+				slog.Info("Revoked Strava access")
+			}
+		}
+		if err := deleteSecrets(secrets, secretKeys(p, stravaTokenKeys)...); err != nil {
+			return err
+		}
+		warnPlaintext(p, "strava.accessToken")
+		return nil
+	},
+}
+
+// revokeStrava deauthorises the profile's Strava token with Strava.
+func revokeStrava(p profile, secrets *credentials.SecretsFile) error {
+	stravaClient, err := newStravaClient(p, secrets, false)
+	if err != nil {
+		return err
+	}
+	return stravaClient.Deauthorize()
+}
+
+var authGarminLoginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Check Garmin Connect credentials and save them in the secrets file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		if secrets == nil {
+			return errors.New("Garmin credentials can only be saved to an encrypted secrets file, set secrets.file in the config")
+		}
+		garminUsername := username
+		if garminUsername == "" {
+			if garminUsername, err = promptLine("Garmin username: "); err != nil {
+				return err
+			}
+		}
+		garminPassword := password
+		if garminPassword == "" {
+			if garminPassword, err = promptSecret("Garmin password: "); err != nil {
+				return err
+			}
+		}
+		garminClient := gc.NewGarminConnect(garminUsername, garminPassword)
+		if err := garminClient.Login(); err != nil {
+			return err
+		}
+		values := map[string]string{
+			p.secretKey("garmin.username"): garminUsername,
+			p.secretKey("garmin.password"): garminPassword,
+		}
+		if _, err := storeSecrets(secrets, values); err != nil {
+			return err
+		}
+		slog.Info("Saved Garmin credentials", "path", secrets.Path())
+		return nil
+	},
+}
+
+var authGarminStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Log in to Garmin Connect and show the account profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Profile:", p.name)
+		garminUsername, garminPassword, err := resolveGarminCredentials(p, secrets)
+		if err != nil {
+			return err
+		}
+		garminClient := gc.NewGarminConnect(garminUsername, garminPassword)
+		if err := garminClient.Login(); err != nil {
+			return err
+		}
+		garminProfile, err := garminClient.Profile()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Garmin Connect user:", garminProfile)
+		return nil
+	},
+}
+
+var authGarminLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Delete the stored Garmin Connect credentials",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, secrets, err := loadAuthProfile()
+		if err != nil {
+			return err
+		}
+		if err := deleteSecrets(secrets, secretKeys(p, garminCredentialKeys)...); err != nil {
+			return err
+		}
+		if secrets != nil {
+			slog.Info("Deleted Garmin credentials", "path", secrets.Path())
+		}
+		warnPlaintext(p, "garmin.password")
+		return nil
+	},
+}
+
+func loadAuthProfile() (profile, *credentials.SecretsFile, error) {
+	p, err := loadProfile(profileName)
+	if err != nil {
+		return profile{}, nil, err
+	}
+	secrets, err := openSecrets(viper.GetViper())
+	if err != nil {
+		return profile{}, nil, err
+	}
+	return p, secrets, nil
+}
+
+func secretKeys(p profile, keys []string) []string {
+	result := make([]string, len(keys))
+	for i, key := range keys {
+		result[i] = p.secretKey(key)
+	}
+	return result
+}
+
+// warnPlaintext points out a secret that is still in the config file, which
+// gravasync does not rewrite.
+func warnPlaintext(p profile, key string) {
+	if p.config.GetString(key) != "" {
+		slog.Warn(key+" is still set in the config file, remove it by hand", "path", viper.ConfigFileUsed())
+	}
+}
+
+func promptLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func init() {
+	authStravaCmd.AddCommand(authStravaLoginCmd, authStravaStatusCmd, authStravaLogoutCmd)
+	authGarminCmd.AddCommand(authGarminLoginCmd, authGarminStatusCmd, authGarminLogoutCmd)
+	authCmd.AddCommand(authStravaCmd, authGarminCmd)
+	rootCmd.AddCommand(authCmd)
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/icalder/gravasync/backfill"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var backfillSince string
+var backfillWait bool
+var backfillRestart bool
+
+var backfillCmd = &cobra.Command{
+	Use:   "backfill --since <yyyy-mm-dd>",
+	Short: "Upload Garmin Connect history to Strava across several days of rate limits",
+	Long: `Upload every Garmin Connect activity since a date, oldest first. The list
+of activities is saved with the profile's state and worked through until
+Strava's daily request limit is spent. Running backfill again resumes where
+it stopped; with --wait it sleeps until the limit resets instead of exiting.
+Activities already in the ledger or skipped by a rule are passed over.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var since time.Time
+		if backfillSince != "" {
+			var err error
+			if since, err = time.ParseInLocation("2006-01-02", backfillSince, time.Local); err != nil {
+				return fmt.Errorf("Bad --since date %q, expected yyyy-mm-dd", backfillSince)
+			}
+		}
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		dir, err := p.stateDir()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, "backfill.json")
+		q, err := backfill.Load(path)
+		if err != nil {
+			return err
+		}
+		if q != nil && backfillRestart {
+			if err := q.Remove(); err != nil {
+				return err
+			}
+			q = nil
+		}
+		if q != nil && !since.IsZero() && !since.Equal(q.Since) {
+			return fmt.Errorf("A backfill since %s is in progress, use --restart to start again", q.Since.Format("2006-01-02"))
+		}
+		if q == nil && since.IsZero() {
+			return errors.New("--since is needed to start a backfill")
+		}
+
+		secrets, err := openSecrets(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		return withSession(p, secrets, true, func(s *session) error {
+			if q == nil {
+				activities, err := s.garminClient.Activities(since)
+				if err != nil {
+					return err
+				}
+				q = backfill.NewQueue(path, since, activities)
+				if err := q.Save(); err != nil {
+					return err
+				}
+				slog.Info("Queued backfill", "activities", len(q.Activities), "since", since.Format("2006-01-02"))
+			} else {
+				slog.Info("Resuming backfill", "since", q.Since.Format("2006-01-02"), "remaining", q.Remaining(), "activities", len(q.Activities))
+			}
+
+			result, err := backfill.Run(q, s.syncer, backfill.Options{
+				RateLimit: s.stravaClient.RateLimit,
+				Wait:      backfillWait,
+				Waiting: func(until time.Time) {
+					slog.Info("Strava rate limit reached, waiting", "until", until)
+				},
+			})
+			if err != nil {
+				return err
+			}
+			if !result.ResumeAt.IsZero() {
+				slog.Info("Strava rate limit reached, run backfill again later or use --wait", "remaining", q.Remaining(), "resumeAfter", result.ResumeAt)
+				return nil
+			}
+			slog.Info("Backfill complete", "activities", len(q.Activities))
+			if len(q.Failed) > 0 {
+				slog.Warn("Some activities failed to upload, see gravasync queue ls", "failed", q.Failed)
+			}
+			return q.Remove()
+		})
+	},
+}
+
+func init() {
+	backfillCmd.Flags().StringVar(&backfillSince, "since", "", "upload activities started on or after this date (yyyy-mm-dd)")
+	backfillCmd.Flags().BoolVar(&backfillWait, "wait", false, "sleep until Strava's rate limit resets instead of stopping")
+	backfillCmd.Flags().BoolVar(&backfillRestart, "restart", false, "discard a backfill in progress and list activities again")
+	rootCmd.AddCommand(backfillCmd)
+}
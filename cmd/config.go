@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/icalder/gravasync/config"
+	"github.com/icalder/gravasync/credentials"
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/notify"
+	"github.com/icalder/gravasync/strava"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var configForce bool
+var configNoAuth bool
+var configOffline bool
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Create, check and edit the config file",
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Write a config file by answering a few questions, then log in to Strava",
+	Long: `Ask for the Garmin Connect username and where its password should be kept,
+and for the Strava API client ID and secret from
+https://www.strava.com/settings/api. The answers are written to the config
+file, or under profiles.<name> with --profile, with 0600 permissions. Strava
+authorisation is then run and the token saved alongside them.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err == nil && !configForce {
+			return fmt.Errorf("%s already exists, use --force to update it", path)
+		}
+		f, err := config.LoadFile(path)
+		if err != nil {
+			return err
+		}
+
+		garminUsername := username
+		if garminUsername == "" {
+			if garminUsername, err = promptLine("Garmin Connect username: "); err != nil {
+				return err
+			}
+		}
+		if err := f.Set(profileKey("garmin.username"), garminUsername); err != nil {
+			return err
+		}
+		var secrets *credentials.SecretsFile
+		secretValues := map[string]string{}
+		// store keeps a secret in the secrets file if one is being used.
+		store := func(key, value string) error {
+			if secrets != nil {
+				secretValues[profileKey(key)] = value
+				return nil
+			}
+			return f.Set(profileKey(key), value)
+		}
+		choice, err := promptChoice("Where should the Garmin password be kept?", []string{
+			"in an encrypted secrets file",
+			"in the output of a command, e.g. pass show garmin",
+			"in an environment variable",
+			"in the config file, in plain text",
+		})
+		if err != nil {
+			return err
+		}
+		switch choice {
+		case 0:
+			secretsPath := viper.GetString("secrets.file")
+			if secretsPath == "" {
+				secretsPath = "~/.gravasync.secrets"
+			}
+			if secretsPath, err = promptDefault("Secrets file", secretsPath); err != nil {
+				return err
+			}
+			if err := f.Set("secrets.file", secretsPath); err != nil {
+				return err
+			}
+			if secrets, err = openNewSecrets(secretsPath); err != nil {
+				return err
+			}
+			fallthrough
+		case 3:
+			garminPassword := password
+			if garminPassword == "" {
+				if garminPassword, err = promptSecret("Garmin Connect password: "); err != nil {
+					return err
+				}
+			}
+			err = store("garmin.password", garminPassword)
+		case 1:
+			var command string
+			if command, err = promptLine("Command printing the password: "); err == nil {
+				err = f.Set(profileKey("garmin.passwordCommand"), command)
+			}
+		case 2:
+			var name string
+			if name, err = promptDefault("Environment variable", "GARMIN_PASSWORD"); err == nil {
+				err = f.Set(profileKey("garmin.passwordEnv"), name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		clientID, err := promptLine("Strava API client ID (see https://www.strava.com/settings/api): ")
+		if err != nil {
+			return err
+		}
+		if err := f.Set(profileKey("strava.clientID"), clientID); err != nil {
+			return err
+		}
+		clientSecret, err := promptSecret("Strava API client secret: ")
+		if err != nil {
+			return err
+		}
+		if err := store("strava.clientSecret", clientSecret); err != nil {
+			return err
+		}
+		if err := f.Save(); err != nil {
+			return err
+		}
+		if _, err := storeSecrets(secrets, secretValues); err != nil {
+			return err
+		}
+		slog.Info("Wrote config", "path", path)
+		if configNoAuth {
+			return nil
+		}
+
+		viper.SetConfigFile(path)
+		if err := viper.ReadInConfig(); err != nil {
+			return err
+		}
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		stravaClient := strava.NewStrava()
+		if err := runStravaOAuth(stravaClient, p, secrets); err != nil {
+			return err
+		}
+		values := stravaTokenSecrets(p, stravaClient.Token())
+		if secrets != nil {
+			if _, err := storeSecrets(secrets, values); err != nil {
+				return err
+			}
+		} else {
+			for key, value := range values {
+				if err := f.Set(key, value); err != nil {
+					return err
+				}
+			}
+			if err := f.Save(); err != nil {
+				return err
+			}
+		}
+		athlete, err := stravaClient.Athlete()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Logged in to Strava as", athlete)
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for mistakes and test logging in to each profile",
+	Long: `Check the config file for unknown keys, values of the wrong type and
+permissions letting other users read it. Each profile's rules, templates,
+privacy zones and notifiers are compiled, and unless --offline is given,
+Garmin Connect and Strava are logged in to.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := viper.ConfigFileUsed()
+		if path == "" {
+			return errors.New("No config file found, run gravasync config init")
+		}
+		v := viper.New()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return err
+		}
+		problems := config.Validate(v.AllSettings())
+		if err := credentials.CheckPermissions(path); err != nil {
+			problems = append(problems, config.Problem{Message: err.Error()})
+		}
+		if secretsPath, err := homedir.Expand(v.GetString("secrets.file")); err == nil && secretsPath != "" {
+			if _, err := os.Stat(secretsPath); err == nil {
+				if err := credentials.CheckPermissions(secretsPath); err != nil {
+					problems = append(problems, config.Problem{Key: "secrets.file", Message: err.Error()})
+				}
+			}
+		}
+		var profiles []profile
+		for _, name := range configuredProfiles() {
+			p, err := loadProfile(name)
+			if err != nil {
+				problems = append(problems, config.Problem{Key: "profiles." + name, Message: err.Error()})
+				continue
+			}
+			problems = append(problems, checkProfile(p)...)
+			profiles = append(profiles, p)
+		}
+		if !configOffline && len(profiles) > 0 {
+			secrets, err := openSecrets(viper.GetViper())
+			if err != nil {
+				problems = append(problems, config.Problem{Key: "secrets.file", Message: err.Error()})
+			}
+			for _, p := range profiles {
+				problems = append(problems, checkLogins(p, secrets)...)
+			}
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("Found %d problems in %s", len(problems), path)
+		}
+		fmt.Println(path, "is valid")
+		return nil
+	},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a config value, e.g. gravasync config get trim.window",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := profileKey(args[0])
+		value := viper.Get(key)
+		if value == nil {
+			return fmt.Errorf("%s is not set", key)
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := yaml.Marshal(value)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(data))
+		default:
+			fmt.Println(value)
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a config value in the config file, e.g. gravasync config set workers.upload 2",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := profileKey(args[0])
+		key, ok := config.Lookup(name)
+		if !ok {
+			if suggestion := config.Suggest(name); suggestion != "" {
+				return fmt.Errorf("Unknown config key %s, did you mean %s?", name, suggestion)
+			}
+			return fmt.Errorf("Unknown config key %s", name)
+		}
+		value, err := config.Parse(key, args[1])
+		if err != nil {
+			return err
+		}
+		path, err := configPath()
+		if err != nil {
+			return err
+		}
+		f, err := config.LoadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := f.Set(name, value); err != nil {
+			return err
+		}
+		if err := f.Save(); err != nil {
+			return err
+		}
+		if key.Kind == config.Secret {
+			slog.Warn(name+" is stored in plain text, consider gravasync auth or a secrets file", "path", path)
+		}
+		return nil
+	},
+}
+
+// configPath returns the config file in use, or where config init should
+// create one.
+func configPath() (string, error) {
+	if cfgFile != "" {
+		return cfgFile, nil
+	}
+	if path := viper.ConfigFileUsed(); path != "" {
+		return path, nil
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".gravasync.yaml"), nil
+}
+
+// profileKey qualifies key with the --profile, if given.
+func profileKey(key string) string {
+	if profileName == "" {
+		return key
+	}
+	return "profiles." + profileName + "." + key
+}
+
+// configuredProfiles returns the profiles to validate: the named ones, and
+// the default profile ("") unless it is unused.
+func configuredProfiles() []string {
+	names := profileNames()
+	if len(names) == 0 || viper.IsSet("garmin") || viper.IsSet("strava") {
+		names = append([]string{""}, names...)
+	}
+	return names
+}
+
+// checkProfile compiles the parts of a profile that are only parsed when a
+// sync starts.
+func checkProfile(p profile) []config.Problem {
+	var problems []config.Problem
+	check := func(part string, err error) {
+		if err != nil {
+			problems = append(problems, config.Problem{Key: p.secretKey(part), Message: err.Error()})
+		}
+	}
+	_, err := p.rulesEngine()
+	check("rules", err)
+	_, err = p.renderer()
+	check("templates", err)
+	_, err = p.privacyFilter()
+	check("privacyZones", err)
+	_, err = p.trimmer()
+	check("trim", err)
+	configs, err := p.notifyConfigs()
+	if err == nil {
+		_, err = notify.New(configs)
+	}
+	check("notify", err)
+	return problems
+}
+
+// checkLogins logs in to Garmin Connect and Strava with a profile's
+// credentials.
+func checkLogins(p profile, secrets *credentials.SecretsFile) []config.Problem {
+	var problems []config.Problem
+	garminUsername, garminPassword, err := resolveGarminCredentials(p, secrets)
+	if err == nil {
+		garminClient := gc.NewGarminConnect(garminUsername, garminPassword)
+		if err = garminClient.Login(); err == nil {
+			fmt.Printf("Profile %s: logged in to Garmin Connect as %s\n", p.name, garminUsername)
+		}
+	}
+	if err != nil {
+		problems = append(problems, config.Problem{Key: p.secretKey("garmin"), Message: err.Error()})
+	}
+	accessToken, err := resolveSecret(p, "strava.accessToken", secrets)
+	if err == nil && accessToken == "" {
+		err = fmt.Errorf("not logged in, run gravasync auth strava login --profile %s", p.name)
+	}
+	if err == nil {
+		stravaClient := strava.NewStrava()
+		stravaClient.SetAccessToken(accessToken)
+		var athlete *strava.Athlete
+		if athlete, err = stravaClient.Athlete(); err == nil {
+			fmt.Printf("Profile %s: logged in to Strava as %v\n", p.name, athlete)
+		}
+	}
+	if err != nil {
+		problems = append(problems, config.Problem{Key: p.secretKey("strava"), Message: err.Error()})
+	}
+	return problems
+}
+
+// openNewSecrets opens the secrets file at path, asking twice for the
+// passphrase of a new one.
+func openNewSecrets(path string) (*credentials.SecretsFile, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		passphrase, err := secretsPassphrase(viper.GetViper(), path)
+		if err != nil {
+			return nil, err
+		}
+		return credentials.OpenSecretsFile(path, passphrase)
+	}
+	passphrase := os.Getenv(secretsPassphraseEnv)
+	if passphrase == "" {
+		if passphrase, err = promptSecret(fmt.Sprintf("New passphrase for %s: ", path)); err != nil {
+			return nil, err
+		}
+		repeated, err := promptSecret("Repeat the passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if repeated != passphrase {
+			return nil, errors.New("Passphrases do not match")
+		}
+	}
+	return credentials.OpenSecretsFile(path, passphrase)
+}
+
+// promptChoice asks a numbered question, returning the index of the answer.
+// An empty answer picks the first choice.
+func promptChoice(question string, choices []string) (int, error) {
+	fmt.Fprintln(os.Stderr, question)
+	for i, choice := range choices {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, choice)
+	}
+	for {
+		answer, err := promptLine("Choice [1]: ")
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" {
+			return 0, nil
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(choices) {
+			return n - 1, nil
+		}
+	}
+}
+
+// promptDefault asks for a value, returning defaultValue for an empty answer.
+func promptDefault(prompt, defaultValue string) (string, error) {
+	answer, err := promptLine(fmt.Sprintf("%s [%s]: ", prompt, defaultValue))
+	if answer == "" {
+		answer = defaultValue
+	}
+	return answer, err
+}
+
+func init() {
+	configInitCmd.Flags().BoolVar(&configForce, "force", false, "update an existing config file")
+	configInitCmd.Flags().BoolVar(&configNoAuth, "no-auth", false, "write the config without authorising Strava")
+	configValidateCmd.Flags().BoolVar(&configOffline, "offline", false, "do not log in to Garmin Connect and Strava")
+	configCmd.AddCommand(configInitCmd, configValidateCmd, configGetCmd, configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/icalder/gravasync/convert"
+
+	"github.com/spf13/cobra"
+)
+
+var convertFrom, convertTo string
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <in> <out>",
+	Short: "Convert an activity file between FIT, TCX and GPX",
+	Long: `Convert an activity file between FIT, TCX and GPX. Formats are taken
+from the file extensions unless --from or --to are given.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		in, out := args[0], args[1]
+		from, to := convertFrom, convertTo
+		var err error
+		if from == "" {
+			if from, err = convert.FormatOf(in); err != nil {
+				return err
+			}
+		}
+		if to == "" {
+			if to, err = convert.FormatOf(out); err != nil {
+				return err
+			}
+		}
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		converted, err := convert.Convert(data, from, to)
+		if err != nil {
+			return fmt.Errorf("Convert %s: %v", in, err)
+		}
+		if err := ioutil.WriteFile(out, converted, 0644); err != nil {
+			return err
+		}
+		slog.Info("Wrote converted activity", "path", out)
+		return nil
+	},
+}
+
+func init() {
+	convertCmd.Flags().StringVar(&convertFrom, "from", "", "input format: fit, tcx or gpx")
+	convertCmd.Flags().StringVar(&convertTo, "to", "", "output format: fit, tcx or gpx")
+	rootCmd.AddCommand(convertCmd)
+}
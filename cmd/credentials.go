@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/icalder/gravasync/credentials"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+const secretsPassphraseEnv = "GRAVASYNC_SECRETS_PASSPHRASE"
+
+// secretSource builds the lookup chain for a profile's secret config key such
+// as "garmin.password". In order it tries <key>Command, <key>File, <key>Env,
+// the encrypted secrets file and finally the plaintext <key> itself.
+func secretSource(p profile, key string, secrets *credentials.SecretsFile) credentials.Source {
+	return secretChain(p.config, key, p.secretKey(key), secrets)
+}
+
+// secretChain is secretSource for key in v, held under secretsKey in the
+// secrets file.
+func secretChain(v *viper.Viper, key, secretsKey string, secrets *credentials.SecretsFile) credentials.Source {
+	var sources []credentials.Source
+	if command := v.GetString(key + "Command"); command != "" {
+		sources = append(sources, credentials.Command(command))
+	}
+	if path := v.GetString(key + "File"); path != "" {
+		if expanded, err := homedir.Expand(path); err == nil {
+			path = expanded
+		}
+		sources = append(sources, credentials.File(path))
+	}
+	if name := v.GetString(key + "Env"); name != "" {
+		sources = append(sources, credentials.Env(name))
+	}
+	if secrets != nil {
+		sources = append(sources, secrets.Source(secretsKey))
+	}
+	sources = append(sources, credentials.Static(v.GetString(key)))
+	return credentials.Chain(sources...)
+}
+
+// resolveSecret looks up key, treating a missing value as an empty string.
+func resolveSecret(p profile, key string, secrets *credentials.SecretsFile) (string, error) {
+	return resolve(secretSource(p, key, secrets))
+}
+
+func resolve(source credentials.Source) (string, error) {
+	value, err := source.Secret()
+	if err == credentials.ErrNotFound {
+		return "", nil
+	}
+	return value, err
+}
+
+// openSecrets unlocks the secrets file named by secrets.file, or returns nil
+// when none is configured.
+func openSecrets(v *viper.Viper) (*credentials.SecretsFile, error) {
+	path := v.GetString("secrets.file")
+	if path == "" {
+		return nil, nil
+	}
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := secretsPassphrase(v, path)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.OpenSecretsFile(path, passphrase)
+}
+
+func secretsPassphrase(v *viper.Viper, path string) (string, error) {
+	source := credentials.Env(secretsPassphraseEnv)
+	if command := v.GetString("secrets.passphraseCommand"); command != "" {
+		source = credentials.Chain(credentials.Command(command), source)
+	}
+	passphrase, err := source.Secret()
+	if err != credentials.ErrNotFound {
+		return passphrase, err
+	}
+	return promptSecret(fmt.Sprintf("Passphrase for %s: ", path))
+}
+
+// stdin is shared by the prompts so that piped answers are not lost to
+// buffering between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptSecret reads a line from the terminal without echoing it, falling back
+// to a plain read when stdin is not a terminal.
+func promptSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// storeSecrets saves values in the secrets file if one is configured,
+// reporting whether they were stored. Empty values are removed.
+func storeSecrets(secrets *credentials.SecretsFile, values map[string]string) (bool, error) {
+	if secrets == nil {
+		return false, nil
+	}
+	for key, value := range values {
+		if value == "" {
+			secrets.Delete(key)
+		} else {
+			secrets.Set(key, value)
+		}
+	}
+	if err := secrets.Save(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// deleteSecrets removes keys from the secrets file, if one is configured.
+func deleteSecrets(secrets *credentials.SecretsFile, keys ...string) error {
+	if secrets == nil {
+		return nil
+	}
+	for _, key := range keys {
+		secrets.Delete(key)
+	}
+	return secrets.Save()
+}
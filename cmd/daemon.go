@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/icalder/gravasync/credentials"
+	"github.com/icalder/gravasync/metrics"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var daemonInterval time.Duration
+var daemonMetricsListen string
+var daemonAllProfiles bool
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Sync without prompting at a regular interval",
+	Long: `Sync without prompting at a regular interval until interrupted, as batch
+sync with --all-profiles does once. With --metrics-listen, Prometheus
+metrics are served on /metrics and the time of each profile's last
+successful sync on /healthz, which returns 503 while a profile's latest
+sync has failed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		interval := daemonInterval
+		if !cmd.Flags().Changed("interval") && viper.IsSet("daemon.interval") {
+			interval = viper.GetDuration("daemon.interval")
+		}
+		if interval <= 0 {
+			return errors.New("The daemon interval must be positive")
+		}
+		listen := daemonMetricsListen
+		if !cmd.Flags().Changed("metrics-listen") {
+			listen = viper.GetString("daemon.metricsListen")
+		}
+		names := []string{profileName}
+		if daemonAllProfiles {
+			if names = profileNames(); len(names) == 0 {
+				return errors.New("No profiles configured")
+			}
+		}
+		secrets, err := openSecrets(viper.GetViper())
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		d := metrics.NewDaemon()
+		if listen != "" {
+			server := &http.Server{Addr: listen, Handler: d.Handler()}
+			go func() {
+				if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					slog.Error("Metrics listener failed", "addr", listen, "err", err)
+				}
+			}()
+			defer server.Close()
+			slog.Info("Serving metrics", "addr", listen)
+		}
+
+		for {
+			for _, name := range names {
+				daemonSync(name, secrets, d)
+			}
+			select {
+			case <-ctx.Done():
+				slog.Info("Stopping")
+				return nil
+			case <-time.After(interval):
+			}
+		}
+	},
+}
+
+// daemonSync runs one batch sync of a profile, recording its metrics.
+func daemonSync(name string, secrets *credentials.SecretsFile, d *metrics.Daemon) {
+	label := name
+	if label == "" {
+		label = defaultProfileName
+	}
+	p, err := loadProfile(name)
+	if err == nil {
+		err = withSession(p, secrets, false, func(s *session) error {
+			err := batchSync(s)
+			d.RateLimit(label, s.stravaClient.RateLimit())
+			return err
+		}, d.Events(label))
+	}
+	d.SyncFinished(label, err)
+	if err != nil {
+		slog.Error("Sync failed", "profile", label, "err", err)
+	}
+}
+
+func init() {
+	daemonCmd.Flags().DurationVar(&daemonInterval, "interval", time.Hour, "time between syncs (daemon.interval)")
+	daemonCmd.Flags().StringVar(&daemonMetricsListen, "metrics-listen", "", "address to serve /metrics and /healthz on, e.g. :9090 (daemon.metricsListen)")
+	daemonCmd.Flags().BoolVar(&daemonAllProfiles, "all-profiles", false, "sync every configured profile")
+	rootCmd.AddCommand(daemonCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/icalder/gravasync/config"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// bindEnv lets every key of v be set by an environment variable named with
+// prefix and the key, upper cased with dots as underscores. Environment
+// variables override the config file.
+func bindEnv(v *viper.Viper, prefix string, keySets ...[]config.Key) {
+	v.SetEnvPrefix(prefix)
+	v.SetEnvKeyReplacer(config.EnvKeyReplacer)
+	v.AutomaticEnv()
+	// Explicit bindings also make the keys visible to Unmarshal and
+	// AllSettings.
+	for _, keys := range keySets {
+		for _, key := range keys {
+			v.BindEnv(key.Name)
+			for _, name := range config.SecretVariants(key) {
+				v.BindEnv(name)
+			}
+		}
+	}
+}
+
+// profileEnvPrefix returns the environment variable prefix for a named
+// profile's keys, e.g. GRAVASYNC_PROFILES_WORK.
+func profileEnvPrefix(name string) string {
+	return config.EnvName("profiles." + name)
+}
+
+// hasEnvPrefix reports whether any environment variable starts with prefix_.
+func hasEnvPrefix(prefix string) bool {
+	prefix += "_"
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// usernameFlag and passwordFlag are looked up when the root command's flags
+// are defined.
+var usernameFlag, passwordFlag *pflag.Flag
+
+// bindCredentialFlags makes --username and --password the garmin.username
+// and garmin.password of v, above the environment and config file.
+func bindCredentialFlags(v *viper.Viper) {
+	v.BindPFlag("garmin.username", usernameFlag)
+	v.BindPFlag("garmin.password", passwordFlag)
+}
+
+// flagsFromEnv sets each setting flag not given on the command line from its
+// GRAVASYNC_ environment variable, e.g. GRAVASYNC_LOG_FORMAT for --log-format.
+// Only the root command's persistent flags are settings: command flags such
+// as --restart are actions, and --username and --password are read from the
+// environment as garmin.username and garmin.password.
+func flagsFromEnv(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Changed || err != nil || flag == usernameFlag || flag == passwordFlag {
+			return
+		}
+		if value, ok := os.LookupEnv(config.EnvName(flag.Name)); ok {
+			err = flags.Set(flag.Name, value)
+		}
+	})
+	return err
+}
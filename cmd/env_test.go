@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// resetConfig clears the global config and flags a test changes.
+func resetConfig(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		cfgFile = ""
+		usernameFlag.Value.Set("")
+		usernameFlag.Changed = false
+	})
+}
+
+func TestLoadProfileFromEnv(t *testing.T) {
+	resetConfig(t)
+	t.Setenv("GRAVASYNC_PROFILES_WORK_STATEDIR", "/tmp/work")
+	t.Setenv("GRAVASYNC_PROFILES_WORK_GARMIN_USERNAME", "alice")
+	p, err := loadProfile("work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir := p.config.GetString("stateDir"); dir != "/tmp/work" {
+		t.Fatalf("stateDir = %q", dir)
+	}
+	if user := p.config.GetString("garmin.username"); user != "alice" {
+		t.Fatalf("garmin.username = %q", user)
+	}
+	if _, err := loadProfile("home"); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	resetConfig(t)
+	cfgFile = filepath.Join(t.TempDir(), "gravasync.yaml")
+	data := "garmin:\n  username: file-user\ntrim:\n  window: 1m\nworkers:\n  export: 3\n"
+	if err := os.WriteFile(cfgFile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GRAVASYNC_GARMIN_USERNAME", "env-user")
+	t.Setenv("GRAVASYNC_TRIM_WINDOW", "2m")
+	initConfig()
+	if got := viper.GetInt("workers.export"); got != 3 {
+		t.Fatalf("workers.export = %d", got)
+	}
+	if got := viper.GetDuration("trim.window"); got != 2*time.Minute {
+		t.Fatalf("trim.window = %v", got)
+	}
+	if got := viper.GetString("garmin.username"); got != "env-user" {
+		t.Fatalf("garmin.username = %q", got)
+	}
+	if err := rootCmd.PersistentFlags().Set("username", "flag-user"); err != nil {
+		t.Fatal(err)
+	}
+	if got := viper.GetString("garmin.username"); got != "flag-user" {
+		t.Fatalf("garmin.username = %q", got)
+	}
+}
+
+func TestFlagsFromEnv(t *testing.T) {
+	t.Setenv("GRAVASYNC_RESTART", "true")
+	t.Setenv("GRAVASYNC_USERNAME", "env-user")
+	t.Setenv("GRAVASYNC_LOG_FORMAT", "json")
+	defer func() {
+		logFormat = "text"
+		rootCmd.PersistentFlags().Lookup("log-format").Changed = false
+	}()
+	if err := flagsFromEnv(rootCmd.PersistentFlags()); err != nil {
+		t.Fatal(err)
+	}
+	if logFormat != "json" {
+		t.Fatalf("log format = %q", logFormat)
+	}
+	if backfillRestart || usernameFlag.Changed {
+		t.Fatal("action and credential flags should not be read from the environment")
+	}
+}
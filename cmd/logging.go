@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/icalder/gravasync/httplog"
+)
+
+var verbose bool
+var logFormat string
+var traceHTTP bool
+var traceBodies string
+var recordHTTP string
+
+// setupLogging installs the default slog logger, writing to stderr, and the
+// HTTP transports for --record-http and for --verbose or --trace-http, which
+// log each request and response. Both clients use
+// http.DefaultTransport, so wrapping it covers Garmin and Strava alike.
+func setupLogging() error {
+	level := slog.LevelInfo
+	if verbose || traceHTTP || traceBodies != "" {
+		level = slog.LevelDebug
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("Unknown log format %q, expected text or json", logFormat)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	if recordHTTP != "" {
+		path := filepath.Join(recordHTTP, time.Now().Format("20060102-150405")+".json")
+		recorder, err := httplog.NewRecorder(http.DefaultTransport, path)
+		if err != nil {
+			return err
+		}
+		http.DefaultTransport = recorder
+		slog.Info("Recording HTTP traffic", "path", path)
+	}
+	if verbose || traceHTTP || traceBodies != "" {
+		if traceBodies != "" {
+			if err := os.MkdirAll(traceBodies, 0700); err != nil {
+				return err
+			}
+		}
+		http.DefaultTransport = httplog.New(http.DefaultTransport, logger, traceBodies)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/icalder/gravasync/convert"
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/merge"
+	"github.com/icalder/gravasync/tcx"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var mergeSensors bool
+var mergeOverwrite bool
+var mergeOutput string
+var mergeForce bool
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <activity-id> <activity-id>...",
+	Short: "Upload several Garmin Connect activities as a single Strava activity",
+	Long: `Upload several Garmin Connect activities as a single Strava activity, for
+recordings split by a watch crash or an accidental stop. The activities are
+joined in time order and uploaded with the rules and metadata of the first.
+
+With --sensors, merge <primary> <secondary> instead copies heart rate,
+cadence and power from the secondary recording into the primary's
+trackpoints, for example from a watch into a bike computer's GPS track.
+Either may be a Garmin Connect activity ID or a local FIT, TCX or GPX file.
+The result is uploaded in place of the primary, or written to --output.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if mergeSensors {
+			if len(args) != 2 {
+				return errors.New("--sensors takes a primary and a secondary activity")
+			}
+			return mergeSensorData(args[0], args[1])
+		}
+		var ids []int64
+		for _, arg := range args {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Bad activity ID %q", arg)
+			}
+			ids = append(ids, id)
+		}
+		s, err := mergeSession()
+		if err != nil {
+			return err
+		}
+		activities, err := findActivities(s.garminClient, ids)
+		if err != nil {
+			return err
+		}
+		for _, activity := range activities {
+			fmt.Println(activity)
+		}
+		if err := checkNotUploaded(s, activities); err != nil {
+			return err
+		}
+		return s.syncer.UploadMerged(activities)
+	},
+}
+
+// checkNotUploaded refuses to merge activities already in the ledger, which
+// would duplicate them on Strava, unless --force is given.
+func checkNotUploaded(s *session, activities []*gc.Activity) error {
+	if mergeForce {
+		return nil
+	}
+	for _, activity := range activities {
+		if s.ledger.Contains(activity.ID) {
+			return fmt.Errorf("Activity %d is already uploaded, use --force to upload it again", activity.ID)
+		}
+	}
+	return nil
+}
+
+func mergeSession() (*session, error) {
+	p, err := loadProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := openSecrets(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+	return newSession(p, secrets, true)
+}
+
+// mergeSensorData implements merge --sensors. Arguments naming an existing
+// file are read locally; anything else must be a Garmin Connect activity ID.
+func mergeSensorData(primaryArg, secondaryArg string) error {
+	args := []string{primaryArg, secondaryArg}
+	dbs := make([]*tcx.Database, 2)
+	garminIDs := make([]int64, 2)
+	var ids []int64
+	for i, arg := range args {
+		if _, err := os.Stat(arg); err == nil {
+			format, err := convert.FormatOf(arg)
+			if err != nil {
+				return err
+			}
+			data, err := ioutil.ReadFile(arg)
+			if err != nil {
+				return err
+			}
+			if dbs[i], err = convert.Read(data, format); err != nil {
+				return fmt.Errorf("Read %s: %v", arg, err)
+			}
+			continue
+		}
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s is neither a file nor an activity ID", arg)
+		}
+		garminIDs[i] = id
+		ids = append(ids, id)
+	}
+	if mergeOutput == "" && garminIDs[0] == 0 {
+		return errors.New("--output is needed when the primary activity is a local file")
+	}
+
+	var s *session
+	var activities []*gc.Activity
+	if len(ids) > 0 {
+		var err error
+		if s, err = mergeSession(); err != nil {
+			return err
+		}
+		if activities, err = findActivities(s.garminClient, ids); err != nil {
+			return err
+		}
+		for i, id := range garminIDs {
+			if id == 0 {
+				continue
+			}
+			export, err := s.garminClient.ExportTCX(id)
+			if err != nil {
+				return err
+			}
+			dbs[i], err = tcx.Parse(export)
+			export.Close()
+			if err != nil {
+				return fmt.Errorf("Export TCX %d: %v", id, err)
+			}
+		}
+	}
+
+	combined, changed, err := merge.Sensors(dbs[0], dbs[1], merge.SensorOptions{Overwrite: mergeOverwrite})
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return errors.New("The secondary activity has no sensor data overlapping the primary")
+	}
+	slog.Info("Added sensor data", "trackpoints", changed)
+
+	if mergeOutput != "" {
+		format, err := convert.FormatOf(mergeOutput)
+		if err != nil {
+			return err
+		}
+		data, err := convert.Write(combined, format)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(mergeOutput, data, 0644); err != nil {
+			return err
+		}
+		slog.Info("Wrote merged activity", "path", mergeOutput)
+		return nil
+	}
+
+	if err := checkNotUploaded(s, activities); err != nil {
+		return err
+	}
+	primary := activities[0]
+	actions := s.syncer.Actions(primary)
+	format := actions.Format
+	if format == "" {
+		format = "tcx"
+	}
+	data, err := convert.Write(combined, format)
+	if err != nil {
+		return err
+	}
+	var merged []int64
+	if garminIDs[1] != 0 {
+		merged = append(merged, garminIDs[1])
+	}
+	return s.syncer.Send(primary, actions, format, bytes.NewReader(data), merged...)
+}
+
+func init() {
+	mergeCmd.Flags().BoolVar(&mergeSensors, "sensors", false, "merge sensor data from a secondary recording into a primary one")
+	mergeCmd.Flags().BoolVar(&mergeOverwrite, "overwrite", false, "with --sensors, replace sensor values the primary already has")
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "with --sensors, write the combined activity to a file instead of uploading it")
+	mergeCmd.Flags().BoolVar(&mergeForce, "force", false, "upload even if some of the activities are already uploaded")
+	rootCmd.AddCommand(mergeCmd)
+}
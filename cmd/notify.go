@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/icalder/gravasync/credentials"
+	"github.com/icalder/gravasync/notify"
+	"github.com/icalder/gravasync/pipeline"
+
+	"github.com/spf13/viper"
+)
+
+// notifyConfigs reads the profile's notify list, without resolving its
+// secrets.
+func (p profile) notifyConfigs() ([]notify.Config, error) {
+	var configs []notify.Config
+	if err := p.config.UnmarshalKey("notify", &configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// notifiers sets up the profile's notify list, returning nil if it has none.
+func (p profile) notifiers(secrets *credentials.SecretsFile) (*notify.Notifiers, error) {
+	configs, err := p.notifyConfigs()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.resolveNotifySecrets(configs, secrets); err != nil {
+		return nil, err
+	}
+	return notify.New(configs)
+}
+
+// resolveNotifySecrets fills in email passwords and webhook header values.
+// Like garmin.password they may be given by passwordCommand, passwordFile or
+// passwordEnv, e.g. AuthorizationCommand for a header, or kept in the
+// secrets file under notify.<index>.password or
+// notify.<index>.headers.<name>.
+func (p profile) resolveNotifySecrets(configs []notify.Config, secrets *credentials.SecretsFile) error {
+	entries, _ := p.config.Get("notify").([]interface{})
+	for i := range configs {
+		if i >= len(entries) {
+			break
+		}
+		v := viper.New()
+		if settings, ok := entries[i].(map[string]interface{}); ok {
+			if err := v.MergeConfigMap(settings); err != nil {
+				return err
+			}
+		}
+		prefix := p.secretKey(fmt.Sprintf("notify.%d.", i))
+		var err error
+		if configs[i].Password, err = resolve(secretChain(v, "password", prefix+"password", secrets)); err != nil {
+			return fmt.Errorf("Notifier notify[%d]: password: %v", i, err)
+		}
+		headers := map[string]string{}
+		for _, name := range headerNames(v.GetStringMap("headers")) {
+			key := "headers." + name
+			value, err := resolve(secretChain(v, key, prefix+key, secrets))
+			if err != nil {
+				return fmt.Errorf("Notifier notify[%d]: header %s: %v", i, name, err)
+			}
+			if value != "" {
+				headers[name] = value
+			}
+		}
+		configs[i].Headers = headers
+	}
+	return nil
+}
+
+// headerNames returns the webhook headers named in a headers map, including
+// those only given by <name>Command, <name>File or <name>Env.
+func headerNames(headers map[string]interface{}) []string {
+	seen := map[string]bool{}
+	var names []string
+	for key := range headers {
+		name := strings.ToLower(key)
+		for _, suffix := range []string{"command", "file", "env"} {
+			if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+				name = strings.TrimSuffix(name, suffix)
+				break
+			}
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withSession opens a session for p, runs fn with it and then sends the
+// profile's notifications about the run. Failing to log in is reported too.
+// Notification failures are logged rather than returned.
+func withSession(p profile, secrets *credentials.SecretsFile, interactive bool, fn func(*session) error, listeners ...func(pipeline.Event)) error {
+	notifiers, err := p.notifiers(secrets)
+	if err != nil {
+		return err
+	}
+	report := notify.NewReport(p.name)
+	s, err := newSession(p, secrets, interactive, append(listeners, report.Event)...)
+	if err == nil {
+		err = fn(s)
+	}
+	report.Finish(err)
+	if notifyErr := notifiers.Notify(report); notifyErr != nil {
+		slog.Warn("Notification failed", "profile", p.name, "err", notifyErr)
+	}
+	return err
+}
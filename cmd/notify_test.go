@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestNotifySecrets(t *testing.T) {
+	v := viper.New()
+	v.Set("notify", []interface{}{
+		map[string]interface{}{"type": "email", "host": "smtp.example.com", "from": "a@example.com", "to": []interface{}{"b@example.com"}, "passwordEnv": "SMTP_PASSWORD"},
+		map[string]interface{}{"type": "webhook", "url": "https://example.com/hook", "headers": map[string]interface{}{"AuthorizationEnv": "HOOK_TOKEN", "X-Source": "gravasync"}},
+	})
+	t.Setenv("SMTP_PASSWORD", "hunter2")
+	t.Setenv("HOOK_TOKEN", "Bearer abc")
+	p := profile{name: defaultProfileName, config: v}
+	configs, err := p.notifyConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configs[0].Password != "" {
+		t.Fatalf("unresolved password = %q", configs[0].Password)
+	}
+	if err := p.resolveNotifySecrets(configs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if configs[0].Password != "hunter2" || configs[1].Headers["authorization"] != "Bearer abc" || configs[1].Headers["x-source"] != "gravasync" {
+		t.Fatalf("configs = %+v", configs)
+	}
+}
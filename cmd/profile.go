@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/icalder/gravasync/config"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/metadata"
+	"github.com/icalder/gravasync/naming"
+	"github.com/icalder/gravasync/privacy"
+	"github.com/icalder/gravasync/retry"
+	"github.com/icalder/gravasync/rules"
+	"github.com/icalder/gravasync/trim"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+const defaultProfileName = "default"
+
+// profile is the configuration for one Garmin account and its paired Strava
+// account. The default profile reads the top-level garmin.* and strava.*
+// keys; named profiles read profiles.<name>.garmin.* and so on.
+type profile struct {
+	name   string
+	config *viper.Viper
+}
+
+// loadProfile returns the named profile, or the top-level configuration when
+// name is empty.
+func loadProfile(name string) (profile, error) {
+	if name == "" {
+		return profile{name: defaultProfileName, config: viper.GetViper()}, nil
+	}
+	v := viper.Sub("profiles." + name)
+	if v == nil {
+		// The profile may be given entirely by environment variables.
+		if !hasEnvPrefix(profileEnvPrefix(name)) {
+			return profile{}, fmt.Errorf("Profile %q not found in config", name)
+		}
+		v = viper.New()
+	}
+	bindEnv(v, profileEnvPrefix(name), config.ProfileKeys)
+	bindCredentialFlags(v)
+	return profile{name: name, config: v}, nil
+}
+
+// profileNames returns the names of all profiles in the config, sorted.
+func profileNames() []string {
+	var names []string
+	for name := range viper.GetStringMap("profiles") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p profile) isDefault() bool {
+	return p.name == defaultProfileName
+}
+
+// secretKey returns the name under which a profile's secret is held in the
+// shared secrets file.
+func (p profile) secretKey(key string) string {
+	if p.isDefault() {
+		return key
+	}
+	return "profiles." + p.name + "." + key
+}
+
+// stateDir returns the directory holding the profile's ledger and other
+// state, ~/.gravasync.d/<profile> unless overridden by stateDir.
+func (p profile) stateDir() (string, error) {
+	if dir := p.config.GetString("stateDir"); dir != "" {
+		return homedir.Expand(dir)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".gravasync.d", p.name), nil
+}
+
+func (p profile) openLedger() (ledger.Ledger, error) {
+	dir, err := p.stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+}
+
+// openRetryQueue opens the profile's queue of failed uploads. Uploads are
+// parked after retry.maxAttempts failures.
+func (p profile) openRetryQueue() (retry.Queue, error) {
+	dir, err := p.stateDir()
+	if err != nil {
+		return nil, err
+	}
+	return retry.Open(filepath.Join(dir, "retry.json"), p.config.GetInt("retry.maxAttempts"))
+}
+
+// metadataMapper returns the profile's Garmin to Strava metadata mapping, or
+// nil if metadata sync is turned off with metadata.enabled: false.
+func (p profile) metadataMapper() *metadata.Mapper {
+	if p.config.IsSet("metadata.enabled") && !p.config.GetBool("metadata.enabled") {
+		return nil
+	}
+	return &metadata.Mapper{
+		SportTypes:   p.config.GetStringMapString("metadata.sportTypes"),
+		Gear:         p.config.GetStringMapString("metadata.gear"),
+		Private:      p.config.GetBool("metadata.private"),
+		HideFromHome: p.config.GetBool("metadata.hideFromHome"),
+	}
+}
+
+// renderer parses the profile's upload name and description templates.
+func (p profile) renderer() (*naming.Renderer, error) {
+	config := naming.Config{
+		Name:        p.config.GetString("templates.name"),
+		Description: p.config.GetString("templates.description"),
+	}
+	if err := p.config.UnmarshalKey("templates.types", &config.Types); err != nil {
+		return nil, err
+	}
+	return naming.New(config)
+}
+
+// privacyFilter reads the profile's privacyZones list, returning nil if there
+// are none.
+func (p profile) privacyFilter() (*privacy.Filter, error) {
+	var zones []privacy.Zone
+	if err := p.config.UnmarshalKey("privacyZones", &zones); err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, nil
+	}
+	return privacy.New(zones)
+}
+
+// trimmer returns the profile's idle time trimmer, or nil if trim.enabled is
+// not set.
+func (p profile) trimmer() (*trim.Trimmer, error) {
+	if !p.config.GetBool("trim.enabled") {
+		return nil, nil
+	}
+	return trim.New(trim.Options{
+		MinSpeed: p.config.GetFloat64("trim.minSpeed"),
+		MaxSpeed: p.config.GetFloat64("trim.maxSpeed"),
+		Window:   p.config.GetDuration("trim.window"),
+		MinIdle:  p.config.GetDuration("trim.minIdle"),
+	})
+}
+
+// rulesEngine compiles the profile's rules section.
+func (p profile) rulesEngine() (*rules.Engine, error) {
+	var ruleList []rules.Rule
+	if err := p.config.UnmarshalKey("rules", &ruleList); err != nil {
+		return nil, err
+	}
+	return rules.New(ruleList)
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var retryParked bool
+var dropParked bool
+var dropAll bool
+
+var retryCmd = &cobra.Command{
+	Use:   "retry",
+	Short: "Try the uploads in the retry queue again",
+	Long: `Try the uploads in the retry queue again. Uploads that fail are kept in
+the queue with the error and a count of attempts. Those Strava rejected, such
+as malformed files or duplicates, and those that have failed too often are
+parked and only retried with --parked.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		secrets, err := openSecrets(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		return withSession(p, secrets, true, func(s *session) error {
+			uploaded, err := s.syncer.Retry(retryParked)
+			if err != nil {
+				return err
+			}
+			slog.Info("Retried failed uploads", "uploaded", uploaded, "left", len(s.retry.Items()))
+			return nil
+		})
+	},
+}
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Manage the queue of failed uploads",
+}
+
+var queueLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List failed uploads waiting to be retried",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		queue, err := p.openRetryQueue()
+		if err != nil {
+			return err
+		}
+		items := queue.Items()
+		if len(items) == 0 {
+			fmt.Println("The retry queue is empty")
+			return nil
+		}
+		for _, item := range items {
+			state := "queued"
+			if item.Parked {
+				state = "parked"
+			}
+			fmt.Printf("%v [%s, %d attempts, last %s]\n", item.Activity, state, item.Attempts, item.LastFailedAt.Format("2006-01-02 15:04"))
+			for _, merged := range item.Merged {
+				fmt.Printf("  merged with %v\n", merged)
+			}
+			fmt.Printf("  %s\n", item.Error)
+		}
+		return nil
+	},
+}
+
+var queueDropCmd = &cobra.Command{
+	Use:   "drop [<activity-id>...]",
+	Short: "Remove failed uploads from the retry queue",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !dropParked && !dropAll {
+			return errors.New("Give activity IDs, --parked or --all")
+		}
+		var ids []int64
+		for _, arg := range args {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Bad activity ID %q", arg)
+			}
+			ids = append(ids, id)
+		}
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		queue, err := p.openRetryQueue()
+		if err != nil {
+			return err
+		}
+		for _, item := range queue.Items() {
+			if dropAll || (dropParked && item.Parked) {
+				ids = append(ids, item.Activity.ID)
+			}
+		}
+		for _, id := range ids {
+			removed, err := queue.Remove(id)
+			if err != nil {
+				return err
+			}
+			if removed {
+				fmt.Printf("Dropped %d\n", id)
+			} else {
+				fmt.Printf("%d is not in the retry queue\n", id)
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	retryCmd.Flags().BoolVar(&retryParked, "parked", false, "retry parked uploads too")
+	queueDropCmd.Flags().BoolVar(&dropParked, "parked", false, "drop every parked upload")
+	queueDropCmd.Flags().BoolVar(&dropAll, "all", false, "empty the retry queue")
+	queueCmd.AddCommand(queueLsCmd)
+	queueCmd.AddCommand(queueDropCmd)
+	rootCmd.AddCommand(retryCmd)
+	rootCmd.AddCommand(queueCmd)
+}
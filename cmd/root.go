@@ -16,13 +16,16 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 
+	"github.com/icalder/gravasync/config"
 	"github.com/icalder/gravasync/gc"
-	"github.com/icalder/gravasync/strava"
+	"github.com/icalder/gravasync/trim"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -32,76 +35,153 @@ import (
 var cfgFile string
 var username string
 var password string
+var profileName string
+var allProfiles bool
+var headless bool
+var noBrowser bool
+var noPrivacyZones bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "gravasync <username> <password>",
 	Short: "Syncs activities from Garmin Connect to Strava, one at a time with prompts",
-	Args: func(cmd *cobra.Command, args []string) error {
-		if viper.GetString("garmin.username") == "" || viper.GetString("garmin.password") == "" {
-			if username == "" || password == "" {
-				if len(args) < 2 {
-					return errors.New("GC username and password are required when not set in config")
-				}
+	Long: `Syncs activities from Garmin Connect to Strava, one at a time with prompts.
+
+Every setting can also be given by an environment variable: GRAVASYNC_
+followed by the key upper cased, with dots as underscores. For example
+GRAVASYNC_GARMIN_PASSWORD sets garmin.password and GRAVASYNC_TRIM_MINIDLE
+sets trim.minIdle. Keys of a named profile go under GRAVASYNC_PROFILES_<NAME>_,
+such as GRAVASYNC_PROFILES_WORK_GARMIN_PASSWORD. The global setting flags
+work the same way, with dashes as underscores: GRAVASYNC_LOG_FORMAT for
+--log-format. Command flags, such as backfill --restart, are not read from
+the environment.
+
+A flag on the command line wins over its environment variable, which wins
+over the config file.`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if allProfiles {
+			if profileName != "" || username != "" || password != "" || len(args) > 0 {
+				return errors.New("--all-profiles cannot be combined with --profile or credentials")
 			}
+			return syncAllProfiles()
 		}
-		if username == "" {
-			username = viper.GetString("garmin.username")
-		}
-		if password == "" {
-			password = viper.GetString("garmin.password")
-		}
-		return nil
-	},
-	Run: func(cmd *cobra.Command, args []string) {
-		stravaClient := strava.NewStrava()
-		// Do we have a Strava API access token?
-		if viper.GetString("strava.accessToken") == "" {
-			if err := stravaClient.Authorise(viper.GetString("strava.clientID"), viper.GetString("strava.clientSecret")); err != nil {
-				panic(err)
+		if len(args) == 2 {
+			if username == "" {
+				username = args[0]
+			}
+			if password == "" {
+				password = args[1]
 			}
-		} else {
-			stravaClient.SetAccessToken(viper.GetString("strava.accessToken"))
 		}
-
-		garminClient := gc.NewGarminConnect(username, password)
-		if err := garminClient.Login(); err != nil {
-			panic(err)
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
 		}
-		if err := activityLoop(stravaClient, garminClient); err != nil {
-			panic(err)
+		secrets, err := openSecrets(viper.GetViper())
+		if err != nil {
+			return err
 		}
+		return withSession(p, secrets, true, activityLoop)
 	},
 }
 
-func activityLoop(stravaClient strava.Strava, garminClient gc.GarminConnect) error {
+func activityLoop(s *session) error {
 	for {
-		activity := garminClient.NextActivity()
+		activity := s.garminClient.NextActivity()
+		if activity == nil {
+			return nil
+		}
 		fmt.Println(activity)
-		switch choose() {
-		case "y":
-			tcxBytes, err := garminClient.ExportTCX(activity.ID)
-			if err != nil {
+		if s.ledger.Contains(activity.ID) {
+			fmt.Println("Already uploaded")
+		}
+		choices := []string{"y", "m", "n", "x"}
+		defaultChoice := "y"
+		actions := s.syncer.Actions(activity)
+		if rule, ok := s.syncer.Match(activity); ok {
+			fmt.Printf("Matched rule %s\n", rule.Name)
+			if rule.Then.Skip {
+				defaultChoice = "n"
+			}
+		}
+		var next *gc.Activity
+		var format string
+		var data []byte
+		var suggestion trim.Suggestion
+		var err error
+		if s.trimmer != nil {
+			if format, data, err = s.exportBytes(activity, actions); err != nil {
 				return err
 			}
-			if err := stravaClient.ImportTCX(activity.Name, false, tcxBytes); err != nil {
+			if suggestion, err = s.trimmer.SuggestFile(data, format); err != nil {
 				return err
 			}
-		case "x":
+			if !suggestion.IsEmpty() {
+				fmt.Printf("Idle time: %v\n", suggestion)
+				choices = []string{"y", "t", "m", "n", "x"}
+				if defaultChoice == "y" {
+					defaultChoice = "t"
+				}
+			}
+		}
+		switch choice := choose(choices, defaultChoice); {
+		case choice == "x":
 			return nil
+		case choice == "n":
+			continue
+		case choice == "m":
+			if next = s.garminClient.NextActivity(); next == nil {
+				fmt.Println("No activity to merge with")
+				continue
+			}
+			fmt.Printf("Merging with %v\n", next)
+			err = s.syncer.UploadMerged([]*gc.Activity{activity, next})
+		case data == nil:
+			err = s.syncer.Upload(activity)
+		case choice == "t":
+			if data, err = suggestion.ApplyFile(data, format); err == nil {
+				err = s.syncer.Send(activity, actions, format, bytes.NewReader(data))
+			}
+		default:
+			err = s.syncer.Send(activity, actions, format, bytes.NewReader(data))
+		}
+		// Failed uploads are kept in the retry queue, so carry on.
+		if err != nil && !s.queued(activity, next) {
+			return err
 		}
 	}
 }
 
-func choose() string {
+var choiceLabels = map[string]string{
+	"y": "Upload (y)",
+	"t": "Upload trimmed (t)",
+	"m": "Merge with the next activity (m)",
+	"n": "Skip (n)",
+	"x": "Exit (x)",
+}
+
+// choose prompts for what to do with an activity; an empty answer picks
+// defaultChoice.
+func choose(choices []string, defaultChoice string) string {
+	labels := make([]string, len(choices))
+	for i, choice := range choices {
+		labels[i] = choiceLabels[choice]
+	}
+	prompt := fmt.Sprintf("%s or %s? [%s]", strings.Join(labels[:len(labels)-1], ", "), labels[len(labels)-1], defaultChoice)
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("Upload (y), Skip (n) or Exit (x)?")
+	fmt.Println(prompt)
 	for scanner.Scan() {
-		input := strings.ToLower(scanner.Text())
-		if input == "y" || input == "n" || input == "x" {
-			return input
+		input := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if input == "" {
+			return defaultChoice
 		}
-		fmt.Println("Upload (y), Skip (n) or Exit (x)?")
+		for _, choice := range choices {
+			if input == choice {
+				return input
+			}
+		}
+		fmt.Println(prompt)
 	}
 	return "x"
 }
@@ -110,13 +190,21 @@ func choose() string {
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		slog.Error(err.Error())
 		os.Exit(1)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	// Flags are filled in from the environment before the config is read, so
+	// that GRAVASYNC_CONFIG and the logging flags take effect.
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := flagsFromEnv(rootCmd.PersistentFlags()); err != nil {
+			return err
+		}
+		initConfig()
+		return nil
+	}
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -124,10 +212,26 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gravasync.yaml)")
 	rootCmd.PersistentFlags().StringVar(&username, "username", "", "username")
 	rootCmd.PersistentFlags().StringVar(&password, "password", "", "password")
+	usernameFlag = rootCmd.PersistentFlags().Lookup("username")
+	passwordFlag = rootCmd.PersistentFlags().Lookup("password")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "config profile to use (profiles.<name> in the config file)")
+	rootCmd.PersistentFlags().BoolVar(&headless, "headless", false, "authorise Strava by pasting the redirect URL instead of running a local callback server (default when over SSH)")
+	rootCmd.PersistentFlags().BoolVar(&noBrowser, "no-browser", false, "do not open the Strava authorisation page automatically")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "log debug messages, including each HTTP request and response")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format, text or json")
+	rootCmd.PersistentFlags().BoolVar(&traceHTTP, "trace-http", false, "log every HTTP request and response, with credentials redacted (implied by --verbose)")
+	rootCmd.PersistentFlags().StringVar(&traceBodies, "trace-bodies", "", "with --trace-http, also save HTTP bodies to this directory")
+	rootCmd.PersistentFlags().StringVar(&recordHTTP, "record-http", "", "save HTTP traffic, with credentials redacted, as a replayable cassette in this directory")
+	rootCmd.PersistentFlags().BoolVar(&noPrivacyZones, "no-privacy-zones", false, "upload GPS points inside configured privacy zones")
+	rootCmd.Flags().BoolVar(&allProfiles, "all-profiles", false, "sync every configured profile without prompting")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	if err := setupLogging(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
@@ -135,7 +239,7 @@ func initConfig() {
 		// Find home directory.
 		home, err := homedir.Dir()
 		if err != nil {
-			fmt.Println(err)
+			slog.Error(err.Error())
 			os.Exit(1)
 		}
 
@@ -144,10 +248,12 @@ func initConfig() {
 		viper.SetConfigName(".gravasync")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// Read in GRAVASYNC_ environment variables for every key.
+	bindEnv(viper.GetViper(), config.EnvPrefix, config.GlobalKeys, config.ProfileKeys)
+	bindCredentialFlags(viper.GetViper())
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		slog.Debug("Using config file", "path", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Inspect the per-activity sync rules",
+}
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test <activity-id>",
+	Short: "Explain which rule matches a Garmin Connect activity",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		activityID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Bad activity ID %q", args[0])
+		}
+		p, err := loadProfile(profileName)
+		if err != nil {
+			return err
+		}
+		engine, err := p.rulesEngine()
+		if err != nil {
+			return err
+		}
+		secrets, err := openSecrets(viper.GetViper())
+		if err != nil {
+			return err
+		}
+		garminUsername, garminPassword, err := resolveGarminCredentials(p, secrets)
+		if err != nil {
+			return err
+		}
+		garminClient := gc.NewGarminConnect(garminUsername, garminPassword)
+		if err := garminClient.Login(); err != nil {
+			return err
+		}
+		activity, err := findActivity(garminClient, activityID)
+		if err != nil {
+			return err
+		}
+		fmt.Println(activity)
+		evaluations := engine.Explain(*activity)
+		for _, evaluation := range evaluations {
+			if evaluation.Matched {
+				fmt.Printf("  %s: matched, actions %+v\n", evaluation.Rule.Name, evaluation.Rule.Then)
+				return nil
+			}
+			fmt.Printf("  %s: %s\n", evaluation.Rule.Name, evaluation.Reason)
+		}
+		fmt.Println("No rule matched")
+		return nil
+	},
+}
+
+// findActivity looks for an activity among those listed by Garmin Connect.
+func findActivity(garminClient gc.GarminConnect, activityID int64) (*gc.Activity, error) {
+	activities, err := findActivities(garminClient, []int64{activityID})
+	if err != nil {
+		return nil, err
+	}
+	return activities[0], nil
+}
+
+// findActivities looks for several activities among those listed by Garmin
+// Connect, returning them in the order of activityIDs. The recent activities
+// fetched at login are searched first, then every page of the history.
+func findActivities(garminClient gc.GarminConnect, activityIDs []int64) ([]*gc.Activity, error) {
+	found := map[int64]*gc.Activity{}
+	wanted := map[int64]bool{}
+	for _, id := range activityIDs {
+		wanted[id] = true
+	}
+	for activity := garminClient.NextActivity(); activity != nil && len(found) < len(wanted); activity = garminClient.NextActivity() {
+		if wanted[activity.ID] {
+			found[activity.ID] = activity
+		}
+	}
+	if len(found) < len(wanted) {
+		history, err := garminClient.Activities(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range history {
+			if wanted[history[i].ID] {
+				found[history[i].ID] = &history[i]
+			}
+		}
+	}
+	activities := make([]*gc.Activity, len(activityIDs))
+	for i, id := range activityIDs {
+		if activities[i] = found[id]; activities[i] == nil {
+			return nil, fmt.Errorf("Activity %d not found in Garmin Connect", id)
+		}
+	}
+	return activities, nil
+}
+
+func init() {
+	rulesCmd.AddCommand(rulesTestCmd)
+	rootCmd.AddCommand(rulesCmd)
+}
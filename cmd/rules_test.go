@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+type pagedGarmin struct {
+	gc.GarminConnect
+	recent  []gc.Activity
+	history []gc.Activity
+	next    int
+}
+
+func (g *pagedGarmin) NextActivity() *gc.Activity {
+	if g.next == len(g.recent) {
+		return nil
+	}
+	g.next++
+	return &g.recent[g.next-1]
+}
+
+func (g *pagedGarmin) Activities(since time.Time) ([]gc.Activity, error) {
+	return g.history, nil
+}
+
+func TestFindActivities(t *testing.T) {
+	garmin := &pagedGarmin{
+		recent:  []gc.Activity{{ID: 3}, {ID: 2}},
+		history: []gc.Activity{{ID: 3}, {ID: 2}, {ID: 1, Name: "Old Run"}},
+	}
+	activities, err := findActivities(garmin, []int64{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activities[0].Name != "Old Run" || activities[1].ID != 3 {
+		t.Fatalf("activities = %+v", activities)
+	}
+	garmin.next = 0
+	if _, err := findActivities(garmin, []int64{4}); err == nil {
+		t.Fatal("found missing activity")
+	}
+}
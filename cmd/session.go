@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/icalder/gravasync/credentials"
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/privacy"
+	"github.com/icalder/gravasync/retry"
+	"github.com/icalder/gravasync/rules"
+	"github.com/icalder/gravasync/strava"
+	"github.com/icalder/gravasync/trim"
+
+	"github.com/spf13/viper"
+)
+
+// session holds the logged in clients, the ledger and the syncer for one
+// profile.
+type session struct {
+	profile      profile
+	stravaClient strava.Strava
+	garminClient gc.GarminConnect
+	ledger       ledger.Ledger
+	retry        retry.Queue
+	syncer       *pipeline.Syncer
+	// trimmer is nil unless trim.enabled is set.
+	trimmer *trim.Trimmer
+}
+
+// newSession resolves a profile's credentials and logs in to both services.
+// Strava authorisation is only attempted when interactive is set. Sync
+// events are logged and also passed to any listeners.
+func newSession(p profile, secrets *credentials.SecretsFile, interactive bool, listeners ...func(pipeline.Event)) (*session, error) {
+	garminUsername, garminPassword, err := resolveGarminCredentials(p, secrets)
+	if err != nil {
+		return nil, err
+	}
+	stravaClient, err := newStravaClient(p, secrets, interactive)
+	if err != nil {
+		return nil, err
+	}
+	l, err := p.openLedger()
+	if err != nil {
+		return nil, err
+	}
+	queue, err := p.openRetryQueue()
+	if err != nil {
+		return nil, err
+	}
+	names, err := p.renderer()
+	if err != nil {
+		return nil, err
+	}
+	engine, err := p.rulesEngine()
+	if err != nil {
+		return nil, err
+	}
+	var zones *privacy.Filter
+	if !noPrivacyZones {
+		if zones, err = p.privacyFilter(); err != nil {
+			return nil, err
+		}
+	}
+	trimmer, err := p.trimmer()
+	if err != nil {
+		return nil, err
+	}
+	garminClient := gc.NewGarminConnect(garminUsername, garminPassword)
+	if err := garminClient.Login(); err != nil {
+		return nil, err
+	}
+	syncer, err := pipeline.New(garminClient, stravaClient, pipeline.Options{
+		Ledger:   l,
+		Rules:    engine,
+		Mapper:   p.metadataMapper(),
+		Names:    names,
+		Privacy:  zones,
+		Trimmer:  trimmer,
+		AutoTrim: p.config.GetBool("trim.auto"),
+		// Validation is on unless explicitly turned off.
+		SkipValidation: p.config.IsSet("validate") && !p.config.GetBool("validate"),
+		Compress:       p.config.GetBool("upload.compress"),
+		ExportWorkers:  p.config.GetInt("workers.export"),
+		UploadWorkers:  p.config.GetInt("workers.upload"),
+		Retry:          queue,
+		Events: func(event pipeline.Event) {
+			logEvent(event)
+			for _, listener := range listeners {
+				listener(event)
+			}
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session{profile: p, stravaClient: stravaClient, garminClient: garminClient, ledger: l, retry: queue, syncer: syncer, trimmer: trimmer}, nil
+}
+
+// resolveGarminCredentials returns the Garmin username and password, with the
+// --username and --password flags taking priority over the configured
+// credential sources.
+func resolveGarminCredentials(p profile, secrets *credentials.SecretsFile) (string, string, error) {
+	var err error
+	garminUsername, garminPassword := username, password
+	if garminUsername == "" {
+		if garminUsername, err = resolveSecret(p, "garmin.username", secrets); err != nil {
+			return "", "", err
+		}
+	}
+	if garminPassword == "" {
+		if garminPassword, err = resolveSecret(p, "garmin.password", secrets); err != nil {
+			return "", "", err
+		}
+	}
+	if garminUsername == "" || garminPassword == "" {
+		return "", "", fmt.Errorf("Profile %s: GC username and password are required when not set in config", p.name)
+	}
+	return garminUsername, garminPassword, nil
+}
+
+func newStravaClient(p profile, secrets *credentials.SecretsFile, interactive bool) (strava.Strava, error) {
+	stravaClient := strava.NewStrava()
+	token, err := stravaToken(p, secrets)
+	if err != nil {
+		return nil, err
+	}
+	// Do we have a Strava API access token?
+	if token.AccessToken != "" {
+		stravaClient.SetToken(token)
+	} else if !interactive {
+		return nil, fmt.Errorf("Profile %s: no Strava access token, run gravasync auth strava login --profile %s", p.name, p.name)
+	} else if err := authoriseStrava(stravaClient, p, secrets); err != nil {
+		return nil, err
+	}
+	if err := setStravaRefresh(stravaClient, p, secrets); err != nil {
+		return nil, err
+	}
+	return stravaClient, nil
+}
+
+// stravaToken reads a profile's Strava token from its secrets.
+func stravaToken(p profile, secrets *credentials.SecretsFile) (strava.Token, error) {
+	var token strava.Token
+	var err error
+	if token.AccessToken, err = resolveSecret(p, "strava.accessToken", secrets); err != nil {
+		return token, err
+	}
+	if token.RefreshToken, err = resolveSecret(p, "strava.refreshToken", secrets); err != nil {
+		return token, err
+	}
+	scopes, err := resolveSecret(p, "strava.scopes", secrets)
+	if err != nil {
+		return token, err
+	}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	expiresAt, err := resolveSecret(p, "strava.tokenExpiresAt", secrets)
+	if err != nil || expiresAt == "" {
+		return token, err
+	}
+	if token.ExpiresAt, err = time.Parse(time.RFC3339, expiresAt); err != nil {
+		return token, fmt.Errorf("Profile %s: strava.tokenExpiresAt: %v", p.name, err)
+	}
+	return token, nil
+}
+
+// setStravaRefresh lets the client refresh its token when it is about to
+// expire, saving each new token in the secrets file.
+func setStravaRefresh(stravaClient strava.Strava, p profile, secrets *credentials.SecretsFile) error {
+	clientSecret, err := resolveSecret(p, "strava.clientSecret", secrets)
+	if err != nil {
+		return err
+	}
+	stravaClient.SetRefresh(strava.RefreshOptions{
+		ClientID:     p.config.GetString("strava.clientID"),
+		ClientSecret: clientSecret,
+		Refreshed: func(token strava.Token) error {
+			stored, err := storeSecrets(secrets, stravaTokenSecrets(p, token))
+			if err == nil && !stored {
+				slog.Warn("Refreshed Strava token not saved, as there is no secrets file", "profile", p.name)
+			}
+			return err
+		},
+	})
+	return nil
+}
+
+// authoriseStrava runs the OAuth flow for a profile and saves the resulting
+// tokens in the secrets file, which is required so that the token is never
+// printed and can be refreshed later.
+func authoriseStrava(stravaClient strava.Strava, p profile, secrets *credentials.SecretsFile) error {
+	if secrets == nil {
+		return fmt.Errorf("Profile %s: set secrets.file so that the Strava token can be saved, then run gravasync auth strava login", p.name)
+	}
+	if err := runStravaOAuth(stravaClient, p, secrets); err != nil {
+		return err
+	}
+	if _, err := storeSecrets(secrets, stravaTokenSecrets(p, stravaClient.Token())); err != nil {
+		return err
+	}
+	slog.Info("Saved Strava token", "path", secrets.Path())
+	return nil
+}
+
+func runStravaOAuth(stravaClient strava.Strava, p profile, secrets *credentials.SecretsFile) error {
+	clientSecret, err := resolveSecret(p, "strava.clientSecret", secrets)
+	if err != nil {
+		return err
+	}
+	return stravaClient.Authorise(strava.AuthOptions{
+		ClientID:     p.config.GetString("strava.clientID"),
+		ClientSecret: clientSecret,
+		Port:         p.config.GetInt("strava.oauthPort"),
+		RedirectURL:  p.config.GetString("strava.redirectURL"),
+		Timeout:      p.config.GetDuration("strava.oauthTimeout"),
+		Headless:     headless || p.config.GetBool("strava.headless") || isRemoteSession(),
+		NoBrowser:    noBrowser,
+		In:           stdin,
+		Out:          os.Stdout,
+	})
+}
+
+// stravaTokenSecrets returns the profile's secret keys and values for a
+// Strava token.
+func stravaTokenSecrets(p profile, token strava.Token) map[string]string {
+	values := map[string]string{
+		p.secretKey("strava.accessToken"):  token.AccessToken,
+		p.secretKey("strava.refreshToken"): token.RefreshToken,
+		p.secretKey("strava.scopes"):       strings.Join(token.Scopes, ","),
+	}
+	if !token.ExpiresAt.IsZero() {
+		values[p.secretKey("strava.tokenExpiresAt")] = token.ExpiresAt.Format(time.RFC3339)
+	}
+	return values
+}
+
+// isRemoteSession reports whether we are running over SSH without a display,
+// where a browser on this machine cannot be used.
+func isRemoteSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" && os.Getenv("DISPLAY") == ""
+}
+
+// exportBytes downloads a whole activity, for inspecting it before upload.
+func (s *session) exportBytes(activity *gc.Activity, actions rules.Actions) (string, []byte, error) {
+	format, export, err := s.syncer.Export(activity, actions)
+	if err != nil {
+		return "", nil, err
+	}
+	defer export.Close()
+	data, err := ioutil.ReadAll(export)
+	return format, data, err
+}
+
+// logEvent reports sync progress.
+func logEvent(event pipeline.Event) {
+	var attrs []any
+	if event.Activity != nil {
+		attrs = append(attrs, "activity", event.Activity.ID, "name", event.Activity.Name)
+	}
+	if len(event.Notes) > 0 {
+		attrs = append(attrs, "notes", event.Notes)
+	}
+	switch event.Type {
+	case pipeline.Listed:
+		slog.Debug("Listed", attrs...)
+	case pipeline.Skipped:
+		if event.Rule == "" {
+			slog.Debug("Skipped", append(attrs, "reason", event.Message)...)
+		} else {
+			slog.Info("Skipped", append(attrs, "rule", event.Rule)...)
+		}
+	case pipeline.Exporting:
+		slog.Debug("Exporting", append(attrs, "format", event.Message)...)
+	case pipeline.Uploading:
+		slog.Info("Uploading", append(attrs, "as", event.Message)...)
+	case pipeline.Done:
+		slog.Info("Uploaded", append(attrs, "stravaActivity", event.Entry.StravaActivityID)...)
+	case pipeline.Failed:
+		slog.Error("Upload failed", append(attrs, "err", event.Err)...)
+	case pipeline.Queued:
+		slog.Warn("Upload "+event.Message, attrs...)
+	}
+}
+
+// queued reports whether any of activities is in the retry queue. Nil
+// activities are ignored.
+func (s *session) queued(activities ...*gc.Activity) bool {
+	for _, activity := range activities {
+		if activity == nil {
+			continue
+		}
+		if _, ok := s.retry.Get(activity.ID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// batchSync uploads every listed activity not already in the ledger.
+func batchSync(s *session) error {
+	return s.syncer.Run()
+}
+
+// syncAllProfiles runs batchSync for each configured profile, carrying on past
+// failures and reporting them together at the end.
+func syncAllProfiles() error {
+	names := profileNames()
+	if len(names) == 0 {
+		return errors.New("No profiles configured")
+	}
+	secrets, err := openSecrets(viper.GetViper())
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for _, name := range names {
+		slog.Info("Syncing profile", "profile", name)
+		p, err := loadProfile(name)
+		if err == nil {
+			err = withSession(p, secrets, false, batchSync)
+		}
+		if err != nil {
+			slog.Error("Profile sync failed", "profile", name, "err", err)
+			failures = append(failures, name)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("Sync failed for profiles: %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
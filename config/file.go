@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is a YAML config file being edited. Comments and the order of keys
+// are kept.
+type File struct {
+	path string
+	doc  *yaml.Node
+}
+
+// LoadFile reads the YAML config file at path. A missing file yields an empty
+// one which is created by Save.
+func LoadFile(path string) (*File, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".yaml" && ext != ".yml" && ext != "" {
+		return nil, fmt.Errorf("Config file %s: only YAML config files can be edited", path)
+	}
+	f := &File{path: path, doc: &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("Config file %s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return f, nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("Config file %s: expected a map of settings", path)
+	}
+	f.doc = &doc
+	return f, nil
+}
+
+// Path returns the location of the file.
+func (f *File) Path() string {
+	return f.path
+}
+
+// Set sets a dotted key such as "trim.window", adding any missing sections.
+// Existing keys are matched without regard to case.
+func (f *File) Set(key string, value interface{}) error {
+	node := f.doc.Content[0]
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		child := find(node, part)
+		if i == len(parts)-1 {
+			var encoded yaml.Node
+			if err := encoded.Encode(value); err != nil {
+				return err
+			}
+			if child == nil {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, &encoded)
+			} else {
+				encoded.HeadComment, encoded.LineComment = child.HeadComment, child.LineComment
+				*child = encoded
+			}
+			return nil
+		}
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, child)
+		} else if child.Kind != yaml.MappingNode {
+			return fmt.Errorf("Config file %s: %s is not a section", f.path, strings.Join(parts[:i+1], "."))
+		}
+		node = child
+	}
+	return errors.New("Empty config key")
+}
+
+// find returns the value of key in a mapping node, or nil.
+func find(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Save writes the file with mode 0600, as it may hold credentials. It is
+// written to a temporary file and renamed into place.
+func (f *File) Save() error {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(f.doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
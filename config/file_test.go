@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".gravasync.yaml")
+	original := "# Garmin account\ngarmin:\n  username: me@example.com # login\nTrim:\n  enabled: false\n"
+	if err := ioutil.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, setting := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"garmin.username", "you@example.com"},
+		{"trim.enabled", true},
+		{"profiles.work.stateDir", "~/work"},
+		{"profiles.work.retry.maxAttempts", 3},
+	} {
+		if err := f.Set(setting.key, setting.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Set("garmin.username.first", "x"); err == nil {
+		t.Fatal("expected an error setting a key inside a value")
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `# Garmin account
+garmin:
+  username: you@example.com # login
+Trim:
+  enabled: true
+profiles:
+  work:
+    stateDir: ~/work
+    retry:
+      maxAttempts: 3
+`
+	if string(data) != expected {
+		t.Fatalf("config =\n%s", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("mode = %v, %v", info.Mode(), err)
+	}
+
+	if _, err := LoadFile(filepath.Join(dir, "config.json")); err == nil {
+		t.Fatal("expected JSON files to be refused")
+	}
+}
@@ -0,0 +1,227 @@
+// Package config describes the keys gravasync reads from its config file, and
+// checks and edits that file.
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the type of value a key holds.
+type Kind int
+
+const (
+	String Kind = iota
+	Bool
+	Int
+	Float
+	Duration
+	// Map and List keys hold structured values, such as rules, which are
+	// checked by the code that reads them.
+	Map
+	List
+	// Secret keys are strings which may instead be given by <key>Command,
+	// <key>File or <key>Env, or kept in the secrets file.
+	Secret
+)
+
+var kindNames = []string{"string", "bool", "int", "float", "duration", "map", "list", "secret"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "unknown"
+}
+
+// Key is a known config key.
+type Key struct {
+	Name string
+	Kind Kind
+}
+
+// ProfileKeys may be set at the top level for the default profile or under
+// profiles.<name> for a named one.
+var ProfileKeys = []Key{
+	{"garmin.username", Secret},
+	{"garmin.password", Secret},
+	{"strava.clientID", String},
+	{"strava.clientSecret", Secret},
+	{"strava.accessToken", Secret},
+	{"strava.refreshToken", Secret},
+	{"strava.scopes", Secret},
+	{"strava.tokenExpiresAt", Secret},
+	{"strava.redirectURL", String},
+	{"strava.oauthPort", Int},
+	{"strava.oauthTimeout", Duration},
+	{"strava.headless", Bool},
+	{"stateDir", String},
+	{"metadata.enabled", Bool},
+	{"metadata.sportTypes", Map},
+	{"metadata.gear", Map},
+	{"metadata.private", Bool},
+	{"metadata.hideFromHome", Bool},
+	{"templates.name", String},
+	{"templates.description", String},
+	{"templates.types", Map},
+	{"privacyZones", List},
+	{"rules", List},
+	{"notify", List},
+	{"trim.enabled", Bool},
+	{"trim.auto", Bool},
+	{"trim.minSpeed", Float},
+	{"trim.maxSpeed", Float},
+	{"trim.window", Duration},
+	{"trim.minIdle", Duration},
+	{"validate", Bool},
+	{"upload.compress", Bool},
+	{"workers.export", Int},
+	{"workers.upload", Int},
+	{"retry.maxAttempts", Int},
+}
+
+// GlobalKeys are only read from the top level.
+var GlobalKeys = []Key{
+	{"secrets.file", String},
+	{"secrets.passphraseCommand", String},
+	{"daemon.interval", Duration},
+	{"daemon.metricsListen", String},
+}
+
+var secretSuffixes = []string{"command", "file", "env"}
+
+// Lookup finds the key for a dotted name such as "trim.window" or
+// "profiles.work.garmin.passwordCommand". Names are not case sensitive.
+func Lookup(name string) (Key, bool) {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "profiles.") {
+		parts := strings.SplitN(name, ".", 3)
+		if len(parts) < 3 || parts[1] == "" {
+			return Key{}, false
+		}
+		return lookup(parts[2], ProfileKeys)
+	}
+	if key, ok := lookup(name, GlobalKeys); ok {
+		return key, true
+	}
+	return lookup(name, ProfileKeys)
+}
+
+func lookup(name string, keys []Key) (Key, bool) {
+	lower := strings.ToLower(name)
+	for _, key := range keys {
+		if strings.ToLower(key.Name) == lower {
+			return key, true
+		}
+	}
+	for _, key := range keys {
+		if key.Kind != Secret {
+			continue
+		}
+		for _, suffix := range secretSuffixes {
+			if strings.ToLower(key.Name)+suffix == lower {
+				return Key{Name: name, Kind: String}, true
+			}
+		}
+	}
+	return Key{}, false
+}
+
+// Parse converts a command line value for key to the type stored in the
+// config file. Durations are checked but kept as strings, e.g. "90s".
+func Parse(key Key, value string) (interface{}, error) {
+	switch key.Kind {
+	case Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s: %q is not true or false", key.Name, value)
+		}
+		return b, nil
+	case Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s: %q is not a whole number", key.Name, value)
+		}
+		return i, nil
+	case Float:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Key %s: %q is not a number", key.Name, value)
+		}
+		return f, nil
+	case Duration:
+		if _, err := time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("Key %s: %q is not a duration such as 90s or 1h", key.Name, value)
+		}
+		return value, nil
+	case Map, List:
+		return nil, fmt.Errorf("Key %s holds a %s, edit the config file to change it", key.Name, key.Kind)
+	}
+	return value, nil
+}
+
+// Suggest returns the known key closest to an unknown name, or "" if none is
+// close.
+func Suggest(name string) string {
+	prefix := ""
+	lower := strings.ToLower(name)
+	keys := append(append([]Key{}, GlobalKeys...), ProfileKeys...)
+	if strings.HasPrefix(lower, "profiles.") {
+		if parts := strings.SplitN(name, ".", 3); len(parts) == 3 {
+			prefix, lower, keys = parts[0]+"."+parts[1]+".", strings.ToLower(parts[2]), ProfileKeys
+		}
+	}
+	best, bestDistance := "", 3
+	for _, key := range keys {
+		if d := distance(lower, strings.ToLower(key.Name)); d < bestDistance {
+			best, bestDistance = prefix+key.Name, d
+		}
+	}
+	return best
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// EnvPrefix starts the environment variable for every key and flag.
+const EnvPrefix = "GRAVASYNC"
+
+// EnvKeyReplacer turns a key or flag name into the rest of its environment
+// variable, once upper cased.
+var EnvKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// EnvName returns the environment variable for a key or flag, e.g.
+// GRAVASYNC_GARMIN_PASSWORD for garmin.password or GRAVASYNC_LOG_FORMAT for
+// --log-format.
+func EnvName(name string) string {
+	return EnvPrefix + "_" + strings.ToUpper(EnvKeyReplacer.Replace(name))
+}
+
+// SecretVariants returns the names of the keys which may supply a secret key
+// instead of it, e.g. garmin.passwordCommand.
+func SecretVariants(key Key) []string {
+	if key.Kind != Secret {
+		return nil
+	}
+	return []string{key.Name + "Command", key.Name + "File", key.Name + "Env"}
+}
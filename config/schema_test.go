@@ -0,0 +1,67 @@
+package config
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	for name, expected := range map[string]Kind{
+		"trim.window":                          Duration,
+		"TRIM.WINDOW":                          Duration,
+		"garmin.password":                      Secret,
+		"garmin.passwordCommand":               String,
+		"profiles.work.strava.clientSecretEnv": String,
+		"profiles.work.workers.upload":         Int,
+		"daemon.interval":                      Duration,
+	} {
+		if key, ok := Lookup(name); !ok || key.Kind != expected {
+			t.Fatalf("Lookup(%s) = %+v, %v", name, key, ok)
+		}
+	}
+	for _, name := range []string{"trim", "garmin.passwordFoo", "profiles.work", "profiles.work.daemon.interval", "strava.clientIDCommand"} {
+		if key, ok := Lookup(name); ok {
+			t.Fatalf("Lookup(%s) = %+v", name, key)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	key, _ := Lookup("workers.upload")
+	if value, err := Parse(key, "4"); err != nil || value != 4 {
+		t.Fatalf("Parse = %v, %v", value, err)
+	}
+	if _, err := Parse(key, "four"); err == nil {
+		t.Fatal("expected an error")
+	}
+	key, _ = Lookup("trim.window")
+	if value, err := Parse(key, "90s"); err != nil || value != "90s" {
+		t.Fatalf("Parse = %v, %v", value, err)
+	}
+	key, _ = Lookup("rules")
+	if _, err := Parse(key, "[]"); err == nil {
+		t.Fatal("expected lists to be refused")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	if s := Suggest("strava.clientId"); s != "strava.clientID" {
+		t.Fatalf("Suggest = %q", s)
+	}
+	if s := Suggest("profiles.work.trim.minidel"); s != "profiles.work.trim.minIdle" {
+		t.Fatalf("Suggest = %q", s)
+	}
+	if s := Suggest("colour"); s != "" {
+		t.Fatalf("Suggest = %q", s)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"garmin.password":              "GRAVASYNC_GARMIN_PASSWORD",
+		"strava.clientID":              "GRAVASYNC_STRAVA_CLIENTID",
+		"profiles.work.workers.upload": "GRAVASYNC_PROFILES_WORK_WORKERS_UPLOAD",
+		"log-format":                   "GRAVASYNC_LOG_FORMAT",
+	} {
+		if env := EnvName(name); env != expected {
+			t.Fatalf("EnvName(%s) = %s", name, env)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is something wrong with a config file. Key is empty for problems
+// with the file as a whole.
+type Problem struct {
+	Key     string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return p.Message
+	}
+	return p.Key + ": " + p.Message
+}
+
+// Validate checks settings, as read from a config file, for unknown keys and
+// values of the wrong type.
+func Validate(settings map[string]interface{}) []Problem {
+	var problems []Problem
+	for _, name := range sortedKeys(settings) {
+		value := settings[name]
+		if strings.ToLower(name) != "profiles" {
+			problems = append(problems, check(name, value, GlobalKeys, ProfileKeys)...)
+			continue
+		}
+		profiles, ok := value.(map[string]interface{})
+		if !ok {
+			problems = append(problems, Problem{name, "expected a map of profile names to settings"})
+			continue
+		}
+		for _, profile := range sortedKeys(profiles) {
+			prefix := name + "." + profile
+			settings, ok := profiles[profile].(map[string]interface{})
+			if !ok {
+				problems = append(problems, Problem{prefix, "expected a map of settings"})
+				continue
+			}
+			for _, key := range sortedKeys(settings) {
+				problems = append(problems, checkIn(prefix, key, settings[key], ProfileKeys)...)
+			}
+		}
+	}
+	return problems
+}
+
+func check(name string, value interface{}, keySets ...[]Key) []Problem {
+	for _, keys := range keySets {
+		if problems, ok := checkKnown("", name, value, keys); ok {
+			return problems
+		}
+	}
+	return unknown(name)
+}
+
+func checkIn(prefix, name string, value interface{}, keys []Key) []Problem {
+	if problems, ok := checkKnown(prefix, name, value, keys); ok {
+		return problems
+	}
+	return unknown(prefix + "." + name)
+}
+
+// checkKnown checks name against keys, descending into sections such as
+// trim.*, and reports false if it is not a known key or section.
+func checkKnown(prefix, name string, value interface{}, keys []Key) ([]Problem, bool) {
+	full := name
+	if prefix != "" {
+		full = prefix + "." + name
+	}
+	if key, ok := lookup(name, keys); ok {
+		if message := checkValue(key.Kind, value); message != "" {
+			return []Problem{{full, message}}, true
+		}
+		return nil, true
+	}
+	section, ok := value.(map[string]interface{})
+	if !ok || !isSection(name, keys) {
+		return nil, false
+	}
+	var problems []Problem
+	for _, child := range sortedKeys(section) {
+		if childProblems, ok := checkKnown(prefix, name+"."+child, section[child], keys); ok {
+			problems = append(problems, childProblems...)
+		} else {
+			problems = append(problems, unknown(full+"."+child)...)
+		}
+	}
+	return problems, true
+}
+
+func isSection(name string, keys []Key) bool {
+	lower := strings.ToLower(name) + "."
+	for _, key := range keys {
+		if strings.HasPrefix(strings.ToLower(key.Name), lower) {
+			return true
+		}
+	}
+	return false
+}
+
+func unknown(name string) []Problem {
+	message := "unknown key"
+	if suggestion := Suggest(name); suggestion != "" {
+		message += fmt.Sprintf(", did you mean %s?", suggestion)
+	}
+	return []Problem{{name, message}}
+}
+
+func checkValue(kind Kind, value interface{}) string {
+	switch kind {
+	case String, Secret:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return "expected a " + kind.String()
+		}
+	case Bool:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return fmt.Sprintf("%q is not true or false", v)
+			}
+		default:
+			return "expected true or false"
+		}
+	case Int, Float:
+		switch v := value.(type) {
+		case int, int64, uint64:
+		case float64:
+			if kind == Int && v != float64(int64(v)) {
+				return fmt.Sprintf("%v is not a whole number", v)
+			}
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Sprintf("%q is not a number", v)
+			}
+		default:
+			return "expected a number"
+		}
+	case Duration:
+		switch v := value.(type) {
+		case int, int64:
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				return fmt.Sprintf("%q is not a duration such as 90s or 1h", v)
+			}
+		default:
+			return "expected a duration such as 90s or 1h"
+		}
+	case Map:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return "expected a map"
+		}
+	case List:
+		if _, ok := value.([]interface{}); !ok {
+			return "expected a list"
+		}
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
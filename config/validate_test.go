@@ -0,0 +1,56 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+garmin:
+  username: me@example.com
+  passwordCommand: pass garmin
+strava:
+  clientid: 12345
+  oauthTimeout: soon
+trim:
+  enabled: true
+  minIdel: 1m
+workers:
+  upload: 2.5
+rules:
+  - name: no-bikes
+colour: blue
+secrets:
+  file: ~/.gravasync.secrets
+profiles:
+  work:
+    validate: maybe
+    daemon:
+      interval: 1h
+  broken: 3
+`
+
+func TestValidate(t *testing.T) {
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal([]byte(testConfig), &settings); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, problem := range Validate(settings) {
+		got = append(got, problem.String())
+	}
+	expected := []string{
+		"colour: unknown key",
+		"profiles.broken: expected a map of settings",
+		"profiles.work.daemon: unknown key",
+		"profiles.work.validate: \"maybe\" is not true or false",
+		"strava.oauthTimeout: \"soon\" is not a duration such as 90s or 1h",
+		"trim.minIdel: unknown key, did you mean trim.minIdle?",
+		"workers.upload: 2.5 is not a whole number",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("problems =\n%q", got)
+	}
+}
@@ -0,0 +1,80 @@
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/icalder/gravasync/fit"
+	"github.com/icalder/gravasync/gpx"
+	"github.com/icalder/gravasync/tcx"
+)
+
+// Supported activity file formats.
+const (
+	TCX = "tcx"
+	GPX = "gpx"
+	FIT = "fit"
+)
+
+// FormatOf returns the format of a file from its extension.
+func FormatOf(path string) (string, error) {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	switch format {
+	case TCX, GPX, FIT:
+		return format, nil
+	}
+	return "", fmt.Errorf("Unknown activity file format %q", filepath.Ext(path))
+}
+
+// Read decodes an activity file into a TCX database, which serves as the
+// common model for conversions.
+func Read(data []byte, format string) (*tcx.Database, error) {
+	switch format {
+	case TCX:
+		return tcx.ParseBytes(data)
+	case GPX:
+		g, err := gpx.ParseBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return FromGPX(g), nil
+	case FIT:
+		f, err := fit.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return FromFIT(f), nil
+	}
+	return nil, fmt.Errorf("Unknown activity file format %q", format)
+}
+
+// Write encodes a TCX database in the given format.
+func Write(db *tcx.Database, format string) ([]byte, error) {
+	switch format {
+	case TCX:
+		return db.Bytes()
+	case GPX:
+		return ToGPX(db).Bytes()
+	case FIT:
+		var b bytes.Buffer
+		if err := fit.Encode(&b, ToFIT(db)); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("Unknown activity file format %q", format)
+}
+
+// Convert re-encodes an activity file from one format to another.
+func Convert(data []byte, from, to string) ([]byte, error) {
+	if from == to {
+		return data, nil
+	}
+	db, err := Read(data, from)
+	if err != nil {
+		return nil, err
+	}
+	return Write(db, to)
+}
@@ -0,0 +1,100 @@
+package convert
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"testing"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+// sample reads the shared run, whose last trackpoint has no position.
+func sample(t *testing.T) *tcx.Database {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "run.tcx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := tcx.ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFITRoundTrip(t *testing.T) {
+	data, err := Write(sample(t), FIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Read(data, FIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := db.Activities[0]
+	if activity.Sport != "Running" || activity.ID != "2017-12-30T09:15:00.000Z" {
+		t.Fatalf("sport %q, ID %q", activity.Sport, activity.ID)
+	}
+	if activity.Creator == nil || activity.Creator.UnitID != 3912345678 || activity.Creator.ProductID != 2431 {
+		t.Fatalf("creator = %+v", activity.Creator)
+	}
+	if len(activity.Laps) != 2 || activity.Laps[0].AverageHeartRateBpm.Value != 114 || activity.Laps[0].DistanceMeters != 1800 {
+		t.Fatalf("%d laps, first %v over %vm", len(activity.Laps), activity.Laps[0].AverageHeartRateBpm, activity.Laps[0].DistanceMeters)
+	}
+	points := activity.Trackpoints()
+	if len(points) != 71 || points[70].Position != nil {
+		t.Fatalf("%d trackpoints, last %+v", len(points), points[len(points)-1])
+	}
+	tp := points[11]
+	if math.Abs(tp.Position.LatitudeDegrees-53.801619) > 1e-6 || tp.HeartRateBpm.Value != 111 {
+		t.Fatalf("trackpoint = %+v", tp)
+	}
+	if watts, _ := tp.Power(); watts != 250 || *tp.Extensions.TPX.RunCadence != 84 {
+		t.Fatalf("extensions = %+v", tp.Extensions.TPX)
+	}
+	if problems := tcx.Errors(db.Validate()); len(problems) > 0 {
+		t.Fatalf("converted TCX is invalid: %v", problems)
+	}
+}
+
+func TestGPXRoundTrip(t *testing.T) {
+	data, err := Convert(mustBytes(t, sample(t)), TCX, GPX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Read(data, GPX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := db.Activities[0]
+	if activity.Sport != "Running" || len(activity.Laps) != 2 {
+		t.Fatalf("sport %q, %d laps", activity.Sport, len(activity.Laps))
+	}
+	points := activity.Trackpoints()
+	if len(points) != 70 {
+		t.Fatalf("expected the positionless trackpoint to be dropped, got %d", len(points))
+	}
+	if points[11].HeartRateBpm.Value != 111 || *points[11].Extensions.TPX.RunCadence != 84 {
+		t.Fatalf("trackpoint = %+v", points[11])
+	}
+	if d := activity.Laps[0].DistanceMeters; math.Abs(d-1800) > 1 {
+		t.Fatalf("lap distance = %v", d)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	if format, err := FormatOf("/tmp/Run.FIT"); err != nil || format != FIT {
+		t.Fatalf("FormatOf = %q, %v", format, err)
+	}
+	if _, err := FormatOf("run.csv"); err == nil {
+		t.Fatal("expected an error for an unknown extension")
+	}
+}
+
+func mustBytes(t *testing.T, db *tcx.Database) []byte {
+	data, err := db.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
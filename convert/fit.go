@@ -0,0 +1,275 @@
+package convert
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/icalder/gravasync/fit"
+	"github.com/icalder/gravasync/tcx"
+)
+
+const (
+	fileTypeActivity        = 4
+	manufacturerGarmin      = 1
+	manufacturerDevelopment = 255
+)
+
+// FromFIT converts a decoded FIT file to a single activity TCX database.
+// Records are assigned to the lap whose start time most recently precedes
+// them; files without laps get one lap spanning every record.
+func FromFIT(f *fit.File) *tcx.Database {
+	sport := uint8(fit.SportGeneric)
+	var start time.Time
+	if len(f.Sessions) > 0 {
+		sport = f.Sessions[0].Sport
+		start = f.Sessions[0].StartTime
+	}
+	if start.IsZero() && len(f.Records) > 0 {
+		start = f.Records[0].Timestamp
+	}
+	if start.IsZero() {
+		start = f.FileID.TimeCreated
+	}
+	activity := tcx.Activity{
+		Sport:   tcxSport(sport),
+		ID:      idFromTime(start),
+		Creator: creatorFromFIT(f),
+	}
+
+	laps := f.Laps
+	if len(laps) == 0 {
+		laps = []fit.Lap{lapFromRecords(f)}
+	}
+	for _, l := range laps {
+		activity.Laps = append(activity.Laps, lapFromFIT(l))
+	}
+	for _, r := range f.Records {
+		idx := sort.Search(len(laps), func(i int) bool { return laps[i].StartTime.After(r.Timestamp) }) - 1
+		if idx < 0 {
+			idx = 0
+		}
+		activity.Laps[idx].Track = append(activity.Laps[idx].Track, trackpointFromFIT(r, activity.Sport))
+	}
+	return &tcx.Database{Activities: []tcx.Activity{activity}}
+}
+
+// ToFIT converts the first activity of a TCX database to a FIT activity
+// file with one session.
+func ToFIT(db *tcx.Database) *fit.File {
+	f := &fit.File{FileID: fit.FileID{Type: fileTypeActivity, Manufacturer: manufacturerDevelopment}}
+	if len(db.Activities) == 0 {
+		return f
+	}
+	activity := db.Activities[0]
+	sport := fitSport(activity.Sport)
+	if c := activity.Creator; c != nil {
+		f.FileID.Manufacturer = manufacturerGarmin
+		f.FileID.Product = c.ProductID
+		f.FileID.SerialNumber = c.UnitID
+	}
+
+	session := fit.Session{Sport: sport, NumLaps: len(activity.Laps)}
+	for _, l := range activity.Laps {
+		lap := fit.Lap{
+			StartTime:      l.StartTime,
+			Timestamp:      l.StartTime.Add(seconds(l.TotalTimeSeconds)),
+			TotalTimerTime: l.TotalTimeSeconds,
+			TotalDistance:  l.DistanceMeters,
+			TotalCalories:  l.Calories,
+			MaxSpeed:       l.MaximumSpeed,
+			AvgCadence:     l.Cadence,
+			Sport:          sport,
+		}
+		if len(l.Track) > 0 {
+			if last := l.Track[len(l.Track)-1].Time; last.After(lap.Timestamp) {
+				lap.Timestamp = last
+			}
+		}
+		lap.TotalElapsedTime = lap.Timestamp.Sub(lap.StartTime).Seconds()
+		if l.AverageHeartRateBpm != nil {
+			lap.AvgHeartRate = &l.AverageHeartRateBpm.Value
+		}
+		if l.MaximumHeartRateBpm != nil {
+			lap.MaxHeartRate = &l.MaximumHeartRateBpm.Value
+		}
+		if l.Extensions != nil && l.Extensions.LX != nil {
+			lx := l.Extensions.LX
+			lap.AvgSpeed = lx.AvgSpeed
+			lap.AvgPower = lx.AvgWatts
+			lap.MaxPower = lx.MaxWatts
+			if lx.AvgRunCadence != nil {
+				lap.AvgCadence = lx.AvgRunCadence
+			}
+			if lx.MaxRunCadence != nil {
+				lap.MaxCadence = lx.MaxRunCadence
+			}
+		}
+		f.Laps = append(f.Laps, lap)
+		for _, tp := range l.Track {
+			f.Records = append(f.Records, recordFromTCX(tp))
+		}
+
+		if session.StartTime.IsZero() {
+			session.StartTime = lap.StartTime
+		}
+		session.Timestamp = lap.Timestamp
+		session.TotalTimerTime += lap.TotalTimerTime
+		session.TotalDistance += lap.TotalDistance
+		session.TotalCalories += lap.TotalCalories
+	}
+	session.TotalElapsedTime = session.Timestamp.Sub(session.StartTime).Seconds()
+	f.Sessions = []fit.Session{session}
+	f.FileID.TimeCreated = session.StartTime
+	return f
+}
+
+func lapFromFIT(l fit.Lap) tcx.Lap {
+	lap := tcx.Lap{
+		StartTime:        l.StartTime,
+		TotalTimeSeconds: l.TotalTimerTime,
+		DistanceMeters:   l.TotalDistance,
+		MaximumSpeed:     l.MaxSpeed,
+		Calories:         l.TotalCalories,
+		Intensity:        "Active",
+		TriggerMethod:    "Manual",
+	}
+	if l.AvgHeartRate != nil {
+		lap.AverageHeartRateBpm = &tcx.HeartRate{Value: *l.AvgHeartRate}
+	}
+	if l.MaxHeartRate != nil {
+		lap.MaximumHeartRateBpm = &tcx.HeartRate{Value: *l.MaxHeartRate}
+	}
+	lx := &tcx.LX{AvgSpeed: l.AvgSpeed, AvgWatts: l.AvgPower, MaxWatts: l.MaxPower}
+	if l.Sport == fit.SportRunning {
+		lx.AvgRunCadence, lx.MaxRunCadence = l.AvgCadence, l.MaxCadence
+	} else {
+		lap.Cadence = l.AvgCadence
+	}
+	if *lx != (tcx.LX{}) {
+		lap.Extensions = &tcx.LapExtensions{LX: lx}
+	}
+	return lap
+}
+
+// lapFromRecords summarises records for files that carry no lap messages.
+func lapFromRecords(f *fit.File) fit.Lap {
+	var lap fit.Lap
+	if len(f.Records) == 0 {
+		return lap
+	}
+	first, last := f.Records[0], f.Records[len(f.Records)-1]
+	lap.StartTime, lap.Timestamp = first.Timestamp, last.Timestamp
+	lap.TotalElapsedTime = last.Timestamp.Sub(first.Timestamp).Seconds()
+	lap.TotalTimerTime = lap.TotalElapsedTime
+	if last.Distance != nil {
+		lap.TotalDistance = *last.Distance
+	}
+	if len(f.Sessions) > 0 {
+		lap.Sport = f.Sessions[0].Sport
+	}
+	return lap
+}
+
+func trackpointFromFIT(r fit.Record, sport string) tcx.Trackpoint {
+	tp := tcx.Trackpoint{
+		Time:           r.Timestamp,
+		AltitudeMeters: r.Altitude,
+		DistanceMeters: r.Distance,
+	}
+	if r.Position != nil {
+		tp.Position = &tcx.Position{LatitudeDegrees: r.Position.Lat, LongitudeDegrees: r.Position.Long}
+	}
+	if r.HeartRate != nil {
+		tp.HeartRateBpm = &tcx.HeartRate{Value: *r.HeartRate}
+	}
+	tpx := &tcx.TPX{Speed: r.Speed, Watts: r.Power}
+	if sport == "Running" {
+		tpx.RunCadence = r.Cadence
+	} else {
+		tp.Cadence = r.Cadence
+	}
+	if *tpx != (tcx.TPX{}) {
+		tp.Extensions = &tcx.TrackpointExtensions{TPX: tpx}
+	}
+	return tp
+}
+
+func recordFromTCX(tp tcx.Trackpoint) fit.Record {
+	r := fit.Record{
+		Timestamp: tp.Time,
+		Altitude:  tp.AltitudeMeters,
+		Distance:  tp.DistanceMeters,
+		Cadence:   tp.Cadence,
+	}
+	if tp.Position != nil {
+		r.Position = &fit.Position{Lat: tp.Position.LatitudeDegrees, Long: tp.Position.LongitudeDegrees}
+	}
+	if tp.HeartRateBpm != nil {
+		r.HeartRate = &tp.HeartRateBpm.Value
+	}
+	if tp.Extensions != nil && tp.Extensions.TPX != nil {
+		tpx := tp.Extensions.TPX
+		r.Speed = tpx.Speed
+		r.Power = tpx.Watts
+		if tpx.RunCadence != nil {
+			r.Cadence = tpx.RunCadence
+		}
+	}
+	return r
+}
+
+func creatorFromFIT(f *fit.File) *tcx.Creator {
+	if f.FileID.SerialNumber == 0 && len(f.Devices) == 0 {
+		return nil
+	}
+	creator := &tcx.Creator{
+		Name:      "Unknown",
+		UnitID:    f.FileID.SerialNumber,
+		ProductID: f.FileID.Product,
+	}
+	for _, device := range f.Devices {
+		if device.DeviceIndex != 0 {
+			continue
+		}
+		if device.ProductName != "" {
+			creator.Name = device.ProductName
+		}
+		if device.SoftwareVersion > 0 {
+			major := int(device.SoftwareVersion)
+			minor := int(math.Round((device.SoftwareVersion - float64(major)) * 100))
+			creator.Version = &tcx.Version{VersionMajor: major, VersionMinor: minor}
+		}
+	}
+	return creator
+}
+
+func tcxSport(sport uint8) string {
+	switch sport {
+	case fit.SportRunning:
+		return "Running"
+	case fit.SportCycling:
+		return "Biking"
+	}
+	return "Other"
+}
+
+func fitSport(sport string) uint8 {
+	switch sport {
+	case "Running":
+		return fit.SportRunning
+	case "Biking":
+		return fit.SportCycling
+	}
+	return fit.SportGeneric
+}
+
+// idFromTime formats a start time the way Garmin Connect writes TCX
+// activity IDs.
+func idFromTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,163 @@
+package convert
+
+import (
+	"math"
+	"strings"
+
+	"github.com/icalder/gravasync/gpx"
+	"github.com/icalder/gravasync/tcx"
+)
+
+const earthRadius = 6371008.8
+
+// FromGPX converts each GPX track to a TCX activity with one lap per track
+// segment. GPX has no lap summaries, so lap time and distance are computed
+// from the points.
+func FromGPX(g *gpx.GPX) *tcx.Database {
+	db := &tcx.Database{}
+	for _, track := range g.Tracks {
+		activity := tcx.Activity{Sport: sportFromGPXType(track.Type)}
+		var distance float64
+		for _, segment := range track.Segments {
+			if len(segment.Points) == 0 {
+				continue
+			}
+			lapStart := distance
+			lap := tcx.Lap{
+				StartTime:     segment.Points[0].Time,
+				Intensity:     "Active",
+				TriggerMethod: "Manual",
+			}
+			for i, p := range segment.Points {
+				if i > 0 {
+					prev := segment.Points[i-1]
+					distance += Distance(prev.Lat, prev.Lon, p.Lat, p.Lon)
+				}
+				lap.Track = append(lap.Track, trackpointFromGPX(p, distance, activity.Sport))
+			}
+			last := segment.Points[len(segment.Points)-1]
+			lap.TotalTimeSeconds = last.Time.Sub(lap.StartTime).Seconds()
+			lap.DistanceMeters = distance - lapStart
+			activity.Laps = append(activity.Laps, lap)
+		}
+		if len(activity.Laps) == 0 {
+			continue
+		}
+		activity.ID = idFromTime(activity.Laps[0].StartTime)
+		db.Activities = append(db.Activities, activity)
+	}
+	return db
+}
+
+// ToGPX converts each TCX activity to a GPX track with one segment per lap.
+// Trackpoints without a position cannot be represented and are dropped.
+func ToGPX(db *tcx.Database) *gpx.GPX {
+	g := &gpx.GPX{}
+	for _, activity := range db.Activities {
+		track := gpx.Track{Type: gpxTypeFromSport(activity.Sport)}
+		for _, lap := range activity.Laps {
+			var segment gpx.Segment
+			for _, tp := range lap.Track {
+				if tp.Position == nil {
+					continue
+				}
+				segment.Points = append(segment.Points, pointFromTCX(tp))
+			}
+			if len(segment.Points) > 0 {
+				track.Segments = append(track.Segments, segment)
+			}
+		}
+		if len(track.Segments) == 0 {
+			continue
+		}
+		if g.Metadata == nil {
+			g.Metadata = &gpx.Metadata{Time: track.Segments[0].Points[0].Time}
+		}
+		g.Tracks = append(g.Tracks, track)
+	}
+	return g
+}
+
+func trackpointFromGPX(p gpx.Point, distance float64, sport string) tcx.Trackpoint {
+	tp := tcx.Trackpoint{
+		Time:           p.Time,
+		Position:       &tcx.Position{LatitudeDegrees: p.Lat, LongitudeDegrees: p.Lon},
+		AltitudeMeters: p.Elevation,
+		DistanceMeters: &distance,
+	}
+	if p.Extensions == nil {
+		return tp
+	}
+	tpx := &tcx.TPX{Watts: p.Extensions.Power}
+	if ext := p.Extensions.TrackPointExtension; ext != nil {
+		if ext.HeartRate != nil {
+			tp.HeartRateBpm = &tcx.HeartRate{Value: *ext.HeartRate}
+		}
+		if sport == "Running" {
+			tpx.RunCadence = ext.Cadence
+		} else {
+			tp.Cadence = ext.Cadence
+		}
+	}
+	if *tpx != (tcx.TPX{}) {
+		tp.Extensions = &tcx.TrackpointExtensions{TPX: tpx}
+	}
+	return tp
+}
+
+func pointFromTCX(tp tcx.Trackpoint) gpx.Point {
+	p := gpx.Point{
+		Lat:       tp.Position.LatitudeDegrees,
+		Lon:       tp.Position.LongitudeDegrees,
+		Elevation: tp.AltitudeMeters,
+		Time:      tp.Time,
+	}
+	ext := &gpx.TrackPointExtension{Cadence: tp.Cadence}
+	if tp.HeartRateBpm != nil {
+		ext.HeartRate = &tp.HeartRateBpm.Value
+	}
+	var power *int
+	if tp.Extensions != nil && tp.Extensions.TPX != nil {
+		power = tp.Extensions.TPX.Watts
+		if tp.Extensions.TPX.RunCadence != nil {
+			ext.Cadence = tp.Extensions.TPX.RunCadence
+		}
+	}
+	if *ext != (gpx.TrackPointExtension{}) || power != nil {
+		p.Extensions = &gpx.Extensions{Power: power}
+		if *ext != (gpx.TrackPointExtension{}) {
+			p.Extensions.TrackPointExtension = ext
+		}
+	}
+	return p
+}
+
+func sportFromGPXType(t string) string {
+	switch strings.ToLower(t) {
+	case "running", "run", "trail_running", "treadmill_running":
+		return "Running"
+	case "cycling", "biking", "ride", "road_biking", "mountain_biking":
+		return "Biking"
+	}
+	return "Other"
+}
+
+func gpxTypeFromSport(sport string) string {
+	switch sport {
+	case "Running":
+		return "running"
+	case "Biking":
+		return "cycling"
+	}
+	return ""
+}
+
+// Distance returns the great circle distance in metres between two points
+// given in degrees.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dPhi := phi2 - phi1
+	dLambda := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,149 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// ErrNotFound is returned when a source has no value for a secret.
+var ErrNotFound = errors.New("credential not found")
+
+// Source resolves a single secret value such as a password or access token.
+type Source interface {
+	Secret() (string, error)
+}
+
+// Static returns a source holding a fixed value, e.g. from a flag or a
+// plaintext config entry.
+func Static(value string) Source {
+	return staticSource(value)
+}
+
+type staticSource string
+
+func (s staticSource) Secret() (string, error) {
+	if s == "" {
+		return "", ErrNotFound
+	}
+	return string(s), nil
+}
+
+// Env returns a source reading the named environment variable.
+func Env(name string) Source {
+	return envSource(name)
+}
+
+type envSource string
+
+func (s envSource) Secret() (string, error) {
+	value, ok := os.LookupEnv(string(s))
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Command returns a source that runs a shell command (e.g. "pass show garmin")
+// and uses the first line of its output.
+func Command(command string) Source {
+	return commandSource(command)
+}
+
+type commandSource string
+
+func (s commandSource) Secret() (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", string(s))
+	} else {
+		cmd = exec.Command("sh", "-c", string(s))
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Credential command %q failed: %v: %s", string(s), err, strings.TrimSpace(stderr.String()))
+	}
+	value := firstLine(out)
+	if value == "" {
+		return "", fmt.Errorf("Credential command %q produced no output", string(s))
+	}
+	return value, nil
+}
+
+// File returns a source reading the first line of a file. The file must not be
+// readable or writable by group or others.
+func File(path string) Source {
+	return fileSource(path)
+}
+
+type fileSource string
+
+func (s fileSource) Secret() (string, error) {
+	if err := CheckPermissions(string(s)); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(string(s))
+	if err != nil {
+		return "", err
+	}
+	value := firstLine(data)
+	if value == "" {
+		return "", fmt.Errorf("Credential file %s is empty", string(s))
+	}
+	return value, nil
+}
+
+// CheckPermissions returns an error if path is accessible to anyone other
+// than its owner. The check is skipped on Windows.
+func CheckPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s has permissions %04o, expected 0600 or stricter", path, perm)
+	}
+	return nil
+}
+
+// Chain returns a source trying each source in turn, returning the first value
+// found. Errors other than ErrNotFound stop the chain.
+func Chain(sources ...Source) Source {
+	return chainSource(sources)
+}
+
+type chainSource []Source
+
+func (c chainSource) Secret() (string, error) {
+	for _, source := range c {
+		if source == nil {
+			continue
+		}
+		value, err := source.Secret()
+		if err == nil {
+			return value, nil
+		}
+		if err != ErrNotFound {
+			return "", err
+		}
+	}
+	return "", ErrNotFound
+}
+
+func firstLine(data []byte) string {
+	line := string(data)
+	if idx := strings.IndexAny(line, "\r\n"); idx >= 0 {
+		line = line[:idx]
+	}
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,128 @@
+package credentials
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	os.Setenv("GRAVASYNC_TEST_SECRET", "from-env")
+	defer os.Unsetenv("GRAVASYNC_TEST_SECRET")
+	source := Chain(Static(""), Env("GRAVASYNC_TEST_UNSET"), Env("GRAVASYNC_TEST_SECRET"), Static("plain"))
+	value, err := source.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "from-env" {
+		t.Fatalf("value = %q", value)
+	}
+	if _, err := Chain(Static("")).Secret(); err != ErrNotFound {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	value, err := Command("printf 'hunter2\\nsecond line\\n'").Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "hunter2" {
+		t.Fatalf("value = %q", value)
+	}
+	if _, err := Command("exit 3").Secret(); err == nil {
+		t.Fatal("expected error from failing command")
+	}
+}
+
+func TestFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip()
+	}
+	path := filepath.Join(t.TempDir(), "pw")
+	if err := os.WriteFile(path, []byte("hunter2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := File(path).Secret(); err == nil {
+		t.Fatal("expected error for world-readable file")
+	}
+	os.Chmod(path, 0600)
+	value, err := File(path).Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "hunter2" {
+		t.Fatalf("value = %q", value)
+	}
+}
+
+func TestSecretsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	sf, err := OpenSecretsFile(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Set("garmin.password", "hunter2")
+	sf.Set("strava.accessToken", "abc123")
+	if err := sf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	sf, err = OpenSecretsFile(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := sf.Source("garmin.password").Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "hunter2" {
+		t.Fatalf("value = %q", value)
+	}
+	if keys := sf.Keys(); len(keys) != 2 {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	if _, err := OpenSecretsFile(path, "wrong"); err != ErrBadPassphrase {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestSecretsFileCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets")
+	sf, err := OpenSecretsFile(path, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf.Set("garmin.password", "hunter2")
+	if err := sf.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(*secretsEnvelope){
+		"short nonce":     func(e *secretsEnvelope) { e.Nonce = e.Nonce[:4] },
+		"zero iterations": func(e *secretsEnvelope) { e.Iterations = 0 },
+		"huge iterations": func(e *secretsEnvelope) { e.Iterations = 1 << 40 },
+	} {
+		var envelope secretsEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			t.Fatal(err)
+		}
+		corrupt(&envelope)
+		corrupted, _ := json.Marshal(envelope)
+		if err := os.WriteFile(path, corrupted, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenSecretsFile(path, "correct horse"); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const secretsFileVersion = 1
+const kdfIterations = 600000
+
+// maxKDFIterations bounds the iterations a secrets file may ask for, so a
+// corrupt file cannot keep key derivation busy for hours.
+const maxKDFIterations = 100 * kdfIterations
+
+// ErrBadPassphrase is returned when a secrets file cannot be decrypted.
+var ErrBadPassphrase = errors.New("secrets file: wrong passphrase or corrupt file")
+
+type secretsEnvelope struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// SecretsFile is a key/value store encrypted at rest with AES-256-GCM, using a
+// key derived from a passphrase.
+type SecretsFile struct {
+	path       string
+	passphrase string
+	secrets    map[string]string
+}
+
+// OpenSecretsFile decrypts the secrets file at path. A missing file yields an
+// empty store which is created on the first Save.
+func OpenSecretsFile(path, passphrase string) (*SecretsFile, error) {
+	sf := &SecretsFile{path: path, passphrase: passphrase, secrets: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckPermissions(path); err != nil {
+		return nil, err
+	}
+	var envelope secretsEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("Secrets file %s: %v", path, err)
+	}
+	if envelope.Version != secretsFileVersion {
+		return nil, fmt.Errorf("Secrets file %s: unsupported version %d", path, envelope.Version)
+	}
+	if envelope.Iterations < 1 || envelope.Iterations > maxKDFIterations {
+		return nil, fmt.Errorf("Secrets file %s: bad iteration count %d", path, envelope.Iterations)
+	}
+	gcm, err := newGCM(passphrase, envelope.Salt, envelope.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope.Nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("Secrets file %s: bad nonce length %d", path, len(envelope.Nonce))
+	}
+	plaintext, err := gcm.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	if err := json.Unmarshal(plaintext, &sf.secrets); err != nil {
+		return nil, fmt.Errorf("Secrets file %s: %v", path, err)
+	}
+	return sf, nil
+}
+
+// Path returns the location of the secrets file.
+func (sf *SecretsFile) Path() string {
+	return sf.path
+}
+
+// Get returns the secret stored under key.
+func (sf *SecretsFile) Get(key string) (string, error) {
+	value, ok := sf.secrets[key]
+	if !ok || value == "" {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Set stores a secret under key. Call Save to persist it.
+func (sf *SecretsFile) Set(key, value string) {
+	sf.secrets[key] = value
+}
+
+// Delete removes a secret. Call Save to persist the change.
+func (sf *SecretsFile) Delete(key string) {
+	delete(sf.secrets, key)
+}
+
+// Keys returns the stored secret names in sorted order.
+func (sf *SecretsFile) Keys() []string {
+	keys := make([]string, 0, len(sf.secrets))
+	for key := range sf.secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Source returns a Source reading key from the secrets file.
+func (sf *SecretsFile) Source(key string) Source {
+	return secretsFileSource{sf, key}
+}
+
+type secretsFileSource struct {
+	sf  *SecretsFile
+	key string
+}
+
+func (s secretsFileSource) Secret() (string, error) {
+	return s.sf.Get(s.key)
+}
+
+// Save encrypts the secrets with a fresh salt and nonce and atomically
+// replaces the file with mode 0600.
+func (sf *SecretsFile) Save() error {
+	plaintext, err := json.Marshal(sf.secrets)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(sf.passphrase, salt, kdfIterations)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	envelope := secretsEnvelope{
+		Version:    secretsFileVersion,
+		Iterations: kdfIterations,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	}
+	data, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(sf.path, data, 0600)
+}
+
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("secrets file: empty passphrase")
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
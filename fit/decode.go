@@ -0,0 +1,370 @@
+package fit
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+type fieldDefinition struct {
+	num      uint8
+	size     uint8
+	baseType BaseType
+}
+
+type developerFieldDefinition struct {
+	num                uint8
+	size               uint8
+	developerDataIndex uint8
+}
+
+type definition struct {
+	global          uint16
+	bigEndian       bool
+	fields          []fieldDefinition
+	developerFields []developerFieldDefinition
+}
+
+type decoder struct {
+	data          []byte
+	pos           int
+	definitions   [16]*definition
+	lastTimestamp uint32
+	descriptions  map[[2]uint8]FieldDescription
+	file          *File
+}
+
+// Decode reads a FIT activity file, checking its header and CRC.
+func Decode(r io.Reader) (*File, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, errors.New("FIT: file too short")
+	}
+	headerSize := int(data[0])
+	if headerSize < 12 || len(data) < headerSize || string(data[8:12]) != ".FIT" {
+		return nil, errors.New("FIT: not a FIT file")
+	}
+	dataSize := int(binary.LittleEndian.Uint32(data[4:8]))
+	end := headerSize + dataSize
+	if len(data) < end+2 {
+		return nil, fmt.Errorf("FIT: truncated file, header says %d bytes of data", dataSize)
+	}
+	if headerSize >= 14 {
+		if headerCRC := binary.LittleEndian.Uint16(data[12:14]); headerCRC != 0 && headerCRC != crc(data[:12]) {
+			return nil, errors.New("FIT: header CRC mismatch")
+		}
+	}
+	if fileCRC := binary.LittleEndian.Uint16(data[end : end+2]); fileCRC != crc(data[:end]) {
+		return nil, errors.New("FIT: file CRC mismatch")
+	}
+
+	d := &decoder{
+		data:         data[:end],
+		pos:          headerSize,
+		descriptions: map[[2]uint8]FieldDescription{},
+		file:         &File{},
+	}
+	for d.pos < len(d.data) {
+		if err := d.readRecord(); err != nil {
+			return nil, fmt.Errorf("FIT: at offset %d: %v", d.pos, err)
+		}
+	}
+	return d.file, nil
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if d.pos+n > len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) readRecord() error {
+	b, err := d.next(1)
+	if err != nil {
+		return err
+	}
+	header := b[0]
+	if header&0x80 != 0 {
+		// Compressed timestamp header
+		local := (header >> 5) & 0x03
+		offset := uint32(header & 0x1F)
+		timestamp := d.lastTimestamp&^0x1F + offset
+		if offset < d.lastTimestamp&0x1F {
+			timestamp += 0x20
+		}
+		d.lastTimestamp = timestamp
+		return d.readData(local, &timestamp)
+	}
+	local := header & 0x0F
+	if header&0x40 != 0 {
+		return d.readDefinition(local, header&0x20 != 0)
+	}
+	return d.readData(local, nil)
+}
+
+func (d *decoder) readDefinition(local uint8, hasDeveloperFields bool) error {
+	b, err := d.next(5)
+	if err != nil {
+		return err
+	}
+	def := &definition{bigEndian: b[1] == 1}
+	if def.bigEndian {
+		def.global = binary.BigEndian.Uint16(b[2:4])
+	} else {
+		def.global = binary.LittleEndian.Uint16(b[2:4])
+	}
+	numFields := int(b[4])
+	if b, err = d.next(3 * numFields); err != nil {
+		return err
+	}
+	for i := 0; i < numFields; i++ {
+		def.fields = append(def.fields, fieldDefinition{b[3*i], b[3*i+1], BaseType(b[3*i+2])})
+	}
+	if hasDeveloperFields {
+		if b, err = d.next(1); err != nil {
+			return err
+		}
+		numFields = int(b[0])
+		if b, err = d.next(3 * numFields); err != nil {
+			return err
+		}
+		for i := 0; i < numFields; i++ {
+			def.developerFields = append(def.developerFields, developerFieldDefinition{b[3*i], b[3*i+1], b[3*i+2]})
+		}
+	}
+	d.definitions[local] = def
+	return nil
+}
+
+func (d *decoder) readData(local uint8, timestamp *uint32) error {
+	def := d.definitions[local]
+	if def == nil {
+		return fmt.Errorf("data message for undefined local type %d", local)
+	}
+	msg := Message{Global: def.global}
+	for _, fd := range def.fields {
+		raw, err := d.next(int(fd.size))
+		if err != nil {
+			return err
+		}
+		field := Field{Num: fd.num, BaseType: fd.baseType, Raw: raw, BigEndian: def.bigEndian}
+		if fd.num == 253 {
+			if value, ok := field.Uint(); ok {
+				d.lastTimestamp = uint32(value)
+			}
+		}
+		msg.Fields = append(msg.Fields, field)
+	}
+	for _, dfd := range def.developerFields {
+		raw, err := d.next(int(dfd.size))
+		if err != nil {
+			return err
+		}
+		baseType := Byte
+		if desc, ok := d.descriptions[[2]uint8{dfd.developerDataIndex, dfd.num}]; ok {
+			baseType = desc.BaseType
+		}
+		msg.DeveloperFields = append(msg.DeveloperFields, DeveloperField{
+			DeveloperDataIndex: dfd.developerDataIndex,
+			Num:                dfd.num,
+			Field:              Field{Num: dfd.num, BaseType: baseType, Raw: raw, BigEndian: def.bigEndian},
+		})
+	}
+	if timestamp != nil {
+		raw := make([]byte, 4)
+		binary.LittleEndian.PutUint32(raw, *timestamp)
+		msg.Fields = append(msg.Fields, Field{Num: 253, BaseType: Uint32, Raw: raw})
+	}
+	d.file.Messages = append(d.file.Messages, msg)
+	d.interpret(msg)
+	return nil
+}
+
+// interpret adds a message to the typed slices of the file.
+func (d *decoder) interpret(msg Message) {
+	f := d.file
+	switch msg.Global {
+	case MesgFileID:
+		f.FileID = FileID{
+			Type:         uint8(getUint(msg, 0)),
+			Manufacturer: uint16(getUint(msg, 1)),
+			Product:      uint16(getUint(msg, 2)),
+			SerialNumber: uint32(getUint(msg, 3)),
+			TimeCreated:  getTime(msg, 4),
+		}
+	case MesgDeviceInfo:
+		device := DeviceInfo{
+			Timestamp:    getTime(msg, 253),
+			DeviceIndex:  uint8(getUint(msg, 0)),
+			Manufacturer: uint16(getUint(msg, 2)),
+			SerialNumber: uint32(getUint(msg, 3)),
+			Product:      uint16(getUint(msg, 4)),
+		}
+		if v := getScaled(msg, 5, 100, 0); v != nil {
+			device.SoftwareVersion = *v
+		}
+		if field, ok := msg.Field(27); ok {
+			device.ProductName = field.String()
+		}
+		f.Devices = append(f.Devices, device)
+	case MesgSession:
+		f.Sessions = append(f.Sessions, Session{
+			Timestamp:        getTime(msg, 253),
+			StartTime:        getTime(msg, 2),
+			Sport:            uint8(getUint(msg, 5)),
+			SubSport:         uint8(getUint(msg, 6)),
+			TotalElapsedTime: orZero(getScaled(msg, 7, 1000, 0)),
+			TotalTimerTime:   orZero(getScaled(msg, 8, 1000, 0)),
+			TotalDistance:    orZero(getScaled(msg, 9, 100, 0)),
+			TotalCalories:    int(getUint(msg, 11)),
+			AvgSpeed:         getScaled(msg, 14, 1000, 0),
+			MaxSpeed:         getScaled(msg, 15, 1000, 0),
+			AvgHeartRate:     getInt(msg, 16),
+			MaxHeartRate:     getInt(msg, 17),
+			AvgCadence:       getInt(msg, 18),
+			MaxCadence:       getInt(msg, 19),
+			AvgPower:         getInt(msg, 20),
+			MaxPower:         getInt(msg, 21),
+			TotalAscent:      getInt(msg, 22),
+			TotalDescent:     getInt(msg, 23),
+			NumLaps:          int(getUint(msg, 26)),
+		})
+	case MesgLap:
+		f.Laps = append(f.Laps, Lap{
+			Timestamp:        getTime(msg, 253),
+			StartTime:        getTime(msg, 2),
+			TotalElapsedTime: orZero(getScaled(msg, 7, 1000, 0)),
+			TotalTimerTime:   orZero(getScaled(msg, 8, 1000, 0)),
+			TotalDistance:    orZero(getScaled(msg, 9, 100, 0)),
+			TotalCalories:    int(getUint(msg, 11)),
+			AvgSpeed:         getScaled(msg, 13, 1000, 0),
+			MaxSpeed:         getScaled(msg, 14, 1000, 0),
+			AvgHeartRate:     getInt(msg, 15),
+			MaxHeartRate:     getInt(msg, 16),
+			AvgCadence:       getInt(msg, 17),
+			MaxCadence:       getInt(msg, 18),
+			AvgPower:         getInt(msg, 19),
+			MaxPower:         getInt(msg, 20),
+			TotalAscent:      getInt(msg, 21),
+			TotalDescent:     getInt(msg, 22),
+			Sport:            uint8(getUint(msg, 25)),
+		})
+	case MesgRecord:
+		record := Record{
+			Timestamp:   getTime(msg, 253),
+			Altitude:    getScaled(msg, 2, 5, 500),
+			HeartRate:   getInt(msg, 3),
+			Cadence:     getInt(msg, 4),
+			Distance:    getScaled(msg, 5, 100, 0),
+			Speed:       getScaled(msg, 6, 1000, 0),
+			Power:       getInt(msg, 7),
+			Temperature: getInt(msg, 13),
+		}
+		if v := getScaled(msg, 73, 1000, 0); v != nil {
+			record.Speed = v
+		}
+		if v := getScaled(msg, 78, 5, 500); v != nil {
+			record.Altitude = v
+		}
+		lat, latOK := getSint(msg, 0)
+		long, longOK := getSint(msg, 1)
+		if latOK && longOK {
+			record.Position = &Position{float64(lat) * semicircleDegrees, float64(long) * semicircleDegrees}
+		}
+		for _, dev := range msg.DeveloperFields {
+			desc, ok := d.descriptions[[2]uint8{dev.DeveloperDataIndex, dev.Num}]
+			if !ok {
+				continue
+			}
+			if value, ok := dev.Float(); ok {
+				if record.Developer == nil {
+					record.Developer = map[string]float64{}
+				}
+				record.Developer[desc.Name] = value
+			}
+		}
+		f.Records = append(f.Records, record)
+	case MesgEvent:
+		f.Events = append(f.Events, Event{
+			Timestamp: getTime(msg, 253),
+			Event:     uint8(getUint(msg, 0)),
+			EventType: uint8(getUint(msg, 1)),
+		})
+	case MesgFieldDescription:
+		desc := FieldDescription{
+			DeveloperDataIndex:    uint8(getUint(msg, 0)),
+			FieldDefinitionNumber: uint8(getUint(msg, 1)),
+			BaseType:              BaseType(getUint(msg, 2)),
+		}
+		if field, ok := msg.Field(3); ok {
+			desc.Name = field.String()
+		}
+		if field, ok := msg.Field(8); ok {
+			desc.Units = field.String()
+		}
+		d.descriptions[[2]uint8{desc.DeveloperDataIndex, desc.FieldDefinitionNumber}] = desc
+		f.DeveloperFields = append(f.DeveloperFields, desc)
+	}
+}
+
+func getUint(msg Message, num uint8) uint64 {
+	if field, ok := msg.Field(num); ok {
+		if value, ok := field.Uint(); ok {
+			return value
+		}
+	}
+	return 0
+}
+
+func getSint(msg Message, num uint8) (int64, bool) {
+	if field, ok := msg.Field(num); ok {
+		return field.Int()
+	}
+	return 0, false
+}
+
+func getInt(msg Message, num uint8) *int {
+	if field, ok := msg.Field(num); ok {
+		if value, ok := field.Int(); ok {
+			v := int(value)
+			return &v
+		}
+	}
+	return nil
+}
+
+// getScaled applies the profile's scale and offset: value/scale - offset.
+func getScaled(msg Message, num uint8, scale, offset float64) *float64 {
+	if field, ok := msg.Field(num); ok {
+		if value, ok := field.Float(); ok {
+			v := value/scale - offset
+			return &v
+		}
+	}
+	return nil
+}
+
+func getTime(msg Message, num uint8) time.Time {
+	if field, ok := msg.Field(num); ok {
+		if value, ok := field.Uint(); ok {
+			return Time(uint32(value))
+		}
+	}
+	return time.Time{}
+}
+
+func orZero(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
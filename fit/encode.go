@@ -0,0 +1,279 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// Event and event type values written around the recorded data.
+const (
+	eventTimer    = 0
+	eventSession  = 8
+	eventLap      = 9
+	eventActivity = 26
+	eventStart    = 0
+	eventStop     = 1
+	eventStopAll  = 4
+)
+
+type encodedField struct {
+	num      uint8
+	baseType BaseType
+	raw      []byte
+}
+
+type encoder struct {
+	buf    bytes.Buffer
+	locals map[uint16]uint8
+}
+
+// Encode writes f as a FIT activity file containing its file ID, records,
+// laps and sessions, followed by an activity message. Raw Messages and
+// developer fields are not written.
+func Encode(w io.Writer, f *File) error {
+	e := &encoder{locals: map[uint16]uint8{}}
+	e.write(MesgFileID, []encodedField{
+		enumField(0, f.FileID.Type),
+		uint16Field(1, uint64(f.FileID.Manufacturer), true),
+		uint16Field(2, uint64(f.FileID.Product), true),
+		{3, Uint32z, le32(f.FileID.SerialNumber)},
+		timeField(4, f.FileID.TimeCreated),
+	})
+	var start, end time.Time
+	if len(f.Records) > 0 {
+		start, end = f.Records[0].Timestamp, f.Records[len(f.Records)-1].Timestamp
+		e.writeEvent(start, eventTimer, eventStart)
+	}
+	for _, r := range f.Records {
+		var lat, long *float64
+		if r.Position != nil {
+			lat, long = &r.Position.Lat, &r.Position.Long
+		}
+		e.write(MesgRecord, []encodedField{
+			timeField(253, r.Timestamp),
+			semicircleField(0, lat),
+			semicircleField(1, long),
+			scaledField(2, Uint16, r.Altitude, 5, 500),
+			intField(3, Uint8, r.HeartRate),
+			intField(4, Uint8, r.Cadence),
+			scaledField(5, Uint32, r.Distance, 100, 0),
+			scaledField(6, Uint16, r.Speed, 1000, 0),
+			intField(7, Uint16, r.Power),
+			intField(13, Sint8, r.Temperature),
+		})
+	}
+	for _, lap := range f.Laps {
+		e.write(MesgLap, []encodedField{
+			timeField(253, lap.Timestamp),
+			enumField(0, eventLap),
+			enumField(1, eventStop),
+			timeField(2, lap.StartTime),
+			scaledField(7, Uint32, &lap.TotalElapsedTime, 1000, 0),
+			scaledField(8, Uint32, &lap.TotalTimerTime, 1000, 0),
+			scaledField(9, Uint32, &lap.TotalDistance, 100, 0),
+			uint16Field(11, uint64(lap.TotalCalories), true),
+			scaledField(13, Uint16, lap.AvgSpeed, 1000, 0),
+			scaledField(14, Uint16, lap.MaxSpeed, 1000, 0),
+			intField(15, Uint8, lap.AvgHeartRate),
+			intField(16, Uint8, lap.MaxHeartRate),
+			intField(17, Uint8, lap.AvgCadence),
+			intField(18, Uint8, lap.MaxCadence),
+			intField(19, Uint16, lap.AvgPower),
+			intField(20, Uint16, lap.MaxPower),
+			intField(21, Uint16, lap.TotalAscent),
+			intField(22, Uint16, lap.TotalDescent),
+			enumField(25, lap.Sport),
+		})
+	}
+	if !end.IsZero() {
+		e.writeEvent(end, eventTimer, eventStopAll)
+	}
+	var totalTimerTime float64
+	for _, s := range f.Sessions {
+		totalTimerTime += s.TotalTimerTime
+		e.write(MesgSession, []encodedField{
+			timeField(253, s.Timestamp),
+			enumField(0, eventSession),
+			enumField(1, eventStop),
+			timeField(2, s.StartTime),
+			enumField(5, s.Sport),
+			enumField(6, s.SubSport),
+			scaledField(7, Uint32, &s.TotalElapsedTime, 1000, 0),
+			scaledField(8, Uint32, &s.TotalTimerTime, 1000, 0),
+			scaledField(9, Uint32, &s.TotalDistance, 100, 0),
+			uint16Field(11, uint64(s.TotalCalories), true),
+			scaledField(14, Uint16, s.AvgSpeed, 1000, 0),
+			scaledField(15, Uint16, s.MaxSpeed, 1000, 0),
+			intField(16, Uint8, s.AvgHeartRate),
+			intField(17, Uint8, s.MaxHeartRate),
+			intField(18, Uint8, s.AvgCadence),
+			intField(19, Uint8, s.MaxCadence),
+			intField(20, Uint16, s.AvgPower),
+			intField(21, Uint16, s.MaxPower),
+			intField(22, Uint16, s.TotalAscent),
+			intField(23, Uint16, s.TotalDescent),
+			uint16Field(25, 0, true),
+			uint16Field(26, uint64(s.NumLaps), true),
+		})
+	}
+	activityTime := end
+	if len(f.Sessions) > 0 {
+		activityTime = f.Sessions[len(f.Sessions)-1].Timestamp
+	}
+	e.write(MesgActivity, []encodedField{
+		timeField(253, activityTime),
+		scaledField(0, Uint32, &totalTimerTime, 1000, 0),
+		uint16Field(1, uint64(len(f.Sessions)), true),
+		enumField(2, 0),
+		enumField(3, eventActivity),
+		enumField(4, eventStop),
+	})
+
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = 0x20
+	binary.LittleEndian.PutUint16(header[2:4], 2132)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(e.buf.Len()))
+	copy(header[8:12], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:14], crc(header[:12]))
+
+	fileCRC := crcUpdate(crc(header), e.buf.Bytes())
+	trailer := make([]byte, 2)
+	binary.LittleEndian.PutUint16(trailer, fileCRC)
+	for _, b := range [][]byte{header, e.buf.Bytes(), trailer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) writeEvent(t time.Time, event, eventType uint8) {
+	e.write(MesgEvent, []encodedField{
+		timeField(253, t),
+		enumField(0, event),
+		enumField(1, eventType),
+	})
+}
+
+// write emits a data message, preceded by a definition message the first
+// time the global message type is seen. Every message of a type must use the
+// same field layout.
+func (e *encoder) write(global uint16, fields []encodedField) {
+	local, ok := e.locals[global]
+	if !ok {
+		local = uint8(len(e.locals))
+		e.locals[global] = local
+		e.buf.WriteByte(0x40 | local)
+		e.buf.Write([]byte{0, 0})
+		binary.Write(&e.buf, binary.LittleEndian, global)
+		e.buf.WriteByte(uint8(len(fields)))
+		for _, f := range fields {
+			e.buf.Write([]byte{f.num, uint8(len(f.raw)), uint8(f.baseType)})
+		}
+	}
+	e.buf.WriteByte(local)
+	for _, f := range fields {
+		e.buf.Write(f.raw)
+	}
+}
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func enumField(num uint8, v uint8) encodedField {
+	return encodedField{num, Enum, []byte{v}}
+}
+
+func uint16Field(num uint8, v uint64, valid bool) encodedField {
+	if !valid || v >= 0xFFFF {
+		v = 0xFFFF
+	}
+	return encodedField{num, Uint16, le16(uint16(v))}
+}
+
+func timeField(num uint8, t time.Time) encodedField {
+	if t.IsZero() {
+		return encodedField{num, Uint32, le32(0xFFFFFFFF)}
+	}
+	return encodedField{num, Uint32, le32(Timestamp(t))}
+}
+
+// intField encodes an optional integer, writing the invalid value for nil or
+// out of range values.
+func intField(num uint8, baseType BaseType, v *int) encodedField {
+	switch baseType {
+	case Uint8:
+		if v == nil || *v < 0 || *v >= 0xFF {
+			return encodedField{num, Uint8, []byte{0xFF}}
+		}
+		return encodedField{num, Uint8, []byte{uint8(*v)}}
+	case Sint8:
+		if v == nil || *v < math.MinInt8 || *v >= math.MaxInt8 {
+			return encodedField{num, Sint8, []byte{0x7F}}
+		}
+		return encodedField{num, Sint8, []byte{uint8(int8(*v))}}
+	}
+	if v == nil {
+		return uint16Field(num, 0, false)
+	}
+	return uint16Field(num, uint64(*v), *v >= 0)
+}
+
+// scaledField encodes (v + offset) * scale as an unsigned integer.
+func scaledField(num uint8, baseType BaseType, v *float64, scale, offset float64) encodedField {
+	var scaled float64
+	valid := v != nil
+	if valid {
+		scaled = math.Round((*v + offset) * scale)
+		valid = scaled >= 0
+	}
+	if baseType == Uint16 {
+		return uint16Field(num, uint64(scaled), valid && scaled < 0xFFFF)
+	}
+	if !valid || scaled >= 0xFFFFFFFF {
+		return encodedField{num, Uint32, le32(0xFFFFFFFF)}
+	}
+	return encodedField{num, Uint32, le32(uint32(scaled))}
+}
+
+func semicircleField(num uint8, degrees *float64) encodedField {
+	if degrees == nil {
+		return encodedField{num, Sint32, le32(0x7FFFFFFF)}
+	}
+	return encodedField{num, Sint32, le32(uint32(int32(math.Round(*degrees / semicircleDegrees))))}
+}
+
+var crcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+// crc computes the FIT CRC-16 of data.
+func crc(data []byte) uint16 {
+	return crcUpdate(0, data)
+}
+
+func crcUpdate(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := crcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ crcTable[b&0xF]
+		tmp = crcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ crcTable[(b>>4)&0xF]
+	}
+	return crc
+}
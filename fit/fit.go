@@ -0,0 +1,326 @@
+package fit
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// Global message numbers of the messages this package understands.
+const (
+	MesgFileID           = 0
+	MesgSession          = 18
+	MesgLap              = 19
+	MesgRecord           = 20
+	MesgEvent            = 21
+	MesgDeviceInfo       = 23
+	MesgActivity         = 34
+	MesgFieldDescription = 206
+	MesgDeveloperDataID  = 207
+)
+
+// Sport values used in session and lap messages.
+const (
+	SportGeneric  = 0
+	SportRunning  = 1
+	SportCycling  = 2
+	SportSwimming = 5
+	SportWalking  = 11
+	SportHiking   = 17
+)
+
+// fitEpoch is the zero point of FIT timestamps, 1989-12-31T00:00:00Z.
+var fitEpoch = time.Date(1989, 12, 31, 0, 0, 0, 0, time.UTC)
+
+const semicircleDegrees = 180.0 / (1 << 31)
+
+// File is a decoded FIT activity file. Messages holds every data message in
+// file order; the typed slices hold the ones this package understands.
+type File struct {
+	FileID          FileID
+	Devices         []DeviceInfo
+	Sessions        []Session
+	Laps            []Lap
+	Records         []Record
+	Events          []Event
+	DeveloperFields []FieldDescription
+	Messages        []Message
+}
+
+type FileID struct {
+	Type         uint8
+	Manufacturer uint16
+	Product      uint16
+	SerialNumber uint32
+	TimeCreated  time.Time
+}
+
+type DeviceInfo struct {
+	Timestamp       time.Time
+	DeviceIndex     uint8
+	Manufacturer    uint16
+	Product         uint16
+	SerialNumber    uint32
+	SoftwareVersion float64
+	ProductName     string
+}
+
+// Session summarises an activity. Distances are in metres, times in seconds
+// and speeds in m/s; nil pointers were not recorded.
+type Session struct {
+	Timestamp        time.Time
+	StartTime        time.Time
+	Sport            uint8
+	SubSport         uint8
+	TotalElapsedTime float64
+	TotalTimerTime   float64
+	TotalDistance    float64
+	TotalCalories    int
+	AvgSpeed         *float64
+	MaxSpeed         *float64
+	AvgHeartRate     *int
+	MaxHeartRate     *int
+	AvgCadence       *int
+	MaxCadence       *int
+	AvgPower         *int
+	MaxPower         *int
+	TotalAscent      *int
+	TotalDescent     *int
+	NumLaps          int
+}
+
+// Lap uses the same units as Session.
+type Lap struct {
+	Timestamp        time.Time
+	StartTime        time.Time
+	TotalElapsedTime float64
+	TotalTimerTime   float64
+	TotalDistance    float64
+	TotalCalories    int
+	AvgSpeed         *float64
+	MaxSpeed         *float64
+	AvgHeartRate     *int
+	MaxHeartRate     *int
+	AvgCadence       *int
+	MaxCadence       *int
+	AvgPower         *int
+	MaxPower         *int
+	TotalAscent      *int
+	TotalDescent     *int
+	Sport            uint8
+}
+
+// Record is a single sample. Positions are in degrees, altitude and distance
+// in metres, speed in m/s and temperature in °C. Developer holds developer
+// field values keyed by field name.
+type Record struct {
+	Timestamp   time.Time
+	Position    *Position
+	Altitude    *float64
+	HeartRate   *int
+	Cadence     *int
+	Distance    *float64
+	Speed       *float64
+	Power       *int
+	Temperature *int
+	Developer   map[string]float64
+}
+
+type Position struct {
+	Lat  float64
+	Long float64
+}
+
+type Event struct {
+	Timestamp time.Time
+	Event     uint8
+	EventType uint8
+}
+
+// FieldDescription names a developer field.
+type FieldDescription struct {
+	DeveloperDataIndex    uint8
+	FieldDefinitionNumber uint8
+	BaseType              BaseType
+	Name                  string
+	Units                 string
+}
+
+// Message is a raw data message.
+type Message struct {
+	Global          uint16
+	Fields          []Field
+	DeveloperFields []DeveloperField
+}
+
+// Field returns the field with number num.
+func (m Message) Field(num uint8) (Field, bool) {
+	for _, f := range m.Fields {
+		if f.Num == num {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Field is a raw field value. Multi-element arrays hold several values of
+// BaseType back to back.
+type Field struct {
+	Num       uint8
+	BaseType  BaseType
+	Raw       []byte
+	BigEndian bool
+}
+
+// DeveloperField is a raw developer field value.
+type DeveloperField struct {
+	DeveloperDataIndex uint8
+	Num                uint8
+	Field
+}
+
+// BaseType is a FIT base type identifier.
+type BaseType uint8
+
+const (
+	Enum    BaseType = 0x00
+	Sint8   BaseType = 0x01
+	Uint8   BaseType = 0x02
+	Sint16  BaseType = 0x83
+	Uint16  BaseType = 0x84
+	Sint32  BaseType = 0x85
+	Uint32  BaseType = 0x86
+	String  BaseType = 0x07
+	Float32 BaseType = 0x88
+	Float64 BaseType = 0x89
+	Uint8z  BaseType = 0x0A
+	Uint16z BaseType = 0x8B
+	Uint32z BaseType = 0x8C
+	Byte    BaseType = 0x0D
+	Sint64  BaseType = 0x8E
+	Uint64  BaseType = 0x8F
+	Uint64z BaseType = 0x90
+)
+
+// Size returns the size in bytes of one value of the type.
+func (t BaseType) Size() int {
+	switch t {
+	case Sint16, Uint16, Uint16z:
+		return 2
+	case Sint32, Uint32, Uint32z, Float32:
+		return 4
+	case Float64, Sint64, Uint64, Uint64z:
+		return 8
+	}
+	return 1
+}
+
+func (f Field) order() binary.ByteOrder {
+	if f.BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// Uint returns the first value of an unsigned or enum field, and false if it
+// holds the type's invalid value.
+func (f Field) Uint() (uint64, bool) {
+	if len(f.Raw) < f.BaseType.Size() {
+		return 0, false
+	}
+	var value, invalid uint64
+	switch f.BaseType {
+	case Enum, Uint8, Byte:
+		value, invalid = uint64(f.Raw[0]), 0xFF
+	case Uint8z:
+		value, invalid = uint64(f.Raw[0]), 0
+	case Uint16:
+		value, invalid = uint64(f.order().Uint16(f.Raw)), 0xFFFF
+	case Uint16z:
+		value, invalid = uint64(f.order().Uint16(f.Raw)), 0
+	case Uint32:
+		value, invalid = uint64(f.order().Uint32(f.Raw)), 0xFFFFFFFF
+	case Uint32z:
+		value, invalid = uint64(f.order().Uint32(f.Raw)), 0
+	case Uint64:
+		value, invalid = f.order().Uint64(f.Raw), math.MaxUint64
+	case Uint64z:
+		value, invalid = f.order().Uint64(f.Raw), 0
+	case Sint8, Sint16, Sint32, Sint64, Float32, Float64:
+		v, ok := f.Int()
+		return uint64(v), ok && v >= 0
+	default:
+		return 0, false
+	}
+	return value, value != invalid
+}
+
+// Int returns the first value of a signed field, and false if it holds the
+// type's invalid value.
+func (f Field) Int() (int64, bool) {
+	if len(f.Raw) < f.BaseType.Size() {
+		return 0, false
+	}
+	switch f.BaseType {
+	case Sint8:
+		v := int8(f.Raw[0])
+		return int64(v), v != math.MaxInt8
+	case Sint16:
+		v := int16(f.order().Uint16(f.Raw))
+		return int64(v), v != math.MaxInt16
+	case Sint32:
+		v := int32(f.order().Uint32(f.Raw))
+		return int64(v), v != math.MaxInt32
+	case Sint64:
+		v := int64(f.order().Uint64(f.Raw))
+		return v, v != math.MaxInt64
+	case Float32, Float64:
+		v, ok := f.Float()
+		return int64(v), ok
+	}
+	v, ok := f.Uint()
+	return int64(v), ok
+}
+
+// Float returns the first value of any numeric field as a float64.
+func (f Field) Float() (float64, bool) {
+	switch f.BaseType {
+	case Float32:
+		if len(f.Raw) < 4 {
+			return 0, false
+		}
+		bits := f.order().Uint32(f.Raw)
+		return float64(math.Float32frombits(bits)), bits != 0xFFFFFFFF
+	case Float64:
+		if len(f.Raw) < 8 {
+			return 0, false
+		}
+		bits := f.order().Uint64(f.Raw)
+		return math.Float64frombits(bits), bits != math.MaxUint64
+	case Sint8, Sint16, Sint32, Sint64:
+		v, ok := f.Int()
+		return float64(v), ok
+	}
+	v, ok := f.Uint()
+	return float64(v), ok
+}
+
+// String returns a string field, up to its NUL terminator.
+func (f Field) String() string {
+	for i, b := range f.Raw {
+		if b == 0 {
+			return string(f.Raw[:i])
+		}
+	}
+	return string(f.Raw)
+}
+
+// Time converts a FIT timestamp to a time.Time.
+func Time(timestamp uint32) time.Time {
+	return fitEpoch.Add(time.Duration(timestamp) * time.Second)
+}
+
+// Timestamp converts a time.Time to a FIT timestamp.
+func Timestamp(t time.Time) uint32 {
+	return uint32(t.Sub(fitEpoch) / time.Second)
+}
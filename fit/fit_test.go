@@ -0,0 +1,131 @@
+package fit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func intPtr(v int) *int           { return &v }
+func floatPtr(v float64) *float64 { return &v }
+
+func sampleFile() *File {
+	start := time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)
+	f := &File{
+		FileID: FileID{Type: 4, Manufacturer: 1, Product: 2431, SerialNumber: 3912345678, TimeCreated: start},
+	}
+	for i := 0; i < 3; i++ {
+		f.Records = append(f.Records, Record{
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Position:  &Position{53.8 + float64(i)*0.0001, -1.55},
+			Altitude:  floatPtr(60.2),
+			HeartRate: intPtr(120 + i),
+			Distance:  floatPtr(float64(i) * 3),
+			Speed:     floatPtr(3),
+			Power:     intPtr(250),
+		})
+	}
+	f.Records[2].Position = nil
+	f.Laps = []Lap{{
+		StartTime:      start,
+		Timestamp:      start.Add(2 * time.Second),
+		TotalTimerTime: 2,
+		TotalDistance:  6,
+		TotalCalories:  1,
+		AvgHeartRate:   intPtr(121),
+		Sport:          SportRunning,
+	}}
+	f.Sessions = []Session{{
+		StartTime:      start,
+		Timestamp:      start.Add(2 * time.Second),
+		Sport:          SportRunning,
+		TotalTimerTime: 2,
+		TotalDistance:  6,
+		NumLaps:        1,
+	}}
+	return f
+}
+
+func TestRoundTrip(t *testing.T) {
+	var b bytes.Buffer
+	if err := Encode(&b, sampleFile()); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.FileID.SerialNumber != 3912345678 || f.FileID.Product != 2431 {
+		t.Fatalf("file ID = %+v", f.FileID)
+	}
+	if len(f.Records) != 3 || len(f.Laps) != 1 || len(f.Sessions) != 1 || len(f.Events) != 2 {
+		t.Fatalf("records %d laps %d sessions %d events %d", len(f.Records), len(f.Laps), len(f.Sessions), len(f.Events))
+	}
+	r := f.Records[1]
+	if !r.Timestamp.Equal(time.Date(2017, 12, 30, 9, 15, 1, 0, time.UTC)) {
+		t.Fatalf("timestamp = %v", r.Timestamp)
+	}
+	if math.Abs(r.Position.Lat-53.8001) > 1e-6 || math.Abs(r.Position.Long+1.55) > 1e-6 {
+		t.Fatalf("position = %+v", r.Position)
+	}
+	if math.Abs(*r.Altitude-60.2) > 0.2 || *r.HeartRate != 121 || *r.Power != 250 || *r.Distance != 3 || *r.Speed != 3 {
+		t.Fatalf("record = %+v", r)
+	}
+	if f.Records[2].Position != nil || f.Records[2].Cadence != nil {
+		t.Fatalf("invalid values decoded: %+v", f.Records[2])
+	}
+	if lap := f.Laps[0]; lap.TotalDistance != 6 || *lap.AvgHeartRate != 121 || lap.MaxHeartRate != nil || lap.Sport != SportRunning {
+		t.Fatalf("lap = %+v", lap)
+	}
+
+	corrupt := append([]byte(nil), b.Bytes()...)
+	corrupt[20] ^= 0xFF
+	if _, err := Decode(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("expected CRC error")
+	}
+}
+
+// TestDeveloperFieldsAndCompressedTimestamps decodes a hand built file with a
+// developer field description, a record carrying a developer field and a
+// record using a compressed timestamp header.
+func TestDeveloperFieldsAndCompressedTimestamps(t *testing.T) {
+	var data bytes.Buffer
+	// field_description: developer_data_index, field_definition_number,
+	// fit_base_type_id, field_name
+	data.Write([]byte{0x40, 0, 0, 206, 0, 4, 0, 1, 2, 1, 1, 2, 2, 1, 2, 3, 8, 7})
+	data.Write([]byte{0x00, 0, 0, byte(Uint16)})
+	data.WriteString("Stryd\x00\x00\x00")
+	// record: timestamp, heart_rate plus developer field 0 of developer 0
+	data.Write([]byte{0x61, 0, 0, 20, 0, 2, 253, 4, byte(Uint32), 3, 1, byte(Uint8), 1, 0, 2, 0})
+	timestamp := Timestamp(time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC))
+	data.WriteByte(0x01)
+	binary.Write(&data, binary.LittleEndian, timestamp)
+	data.WriteByte(130)
+	binary.Write(&data, binary.LittleEndian, uint16(280))
+	// record: heart_rate only, local type 2 used with a compressed timestamp
+	data.Write([]byte{0x42, 0, 0, 20, 0, 1, 3, 1, byte(Uint8)})
+	offset := (timestamp + 3) & 0x1F
+	data.Write([]byte{0x80 | 2<<5 | byte(offset), 131})
+
+	header := []byte{12, 0x10, 0, 0, 0, 0, 0, 0, '.', 'F', 'I', 'T'}
+	binary.LittleEndian.PutUint32(header[4:8], uint32(data.Len()))
+	file := append(header, data.Bytes()...)
+	file = append(file, 0, 0)
+	binary.LittleEndian.PutUint16(file[len(file)-2:], crc(file[:len(file)-2]))
+
+	f, err := Decode(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.DeveloperFields) != 1 || f.DeveloperFields[0].Name != "Stryd" {
+		t.Fatalf("developer fields = %+v", f.DeveloperFields)
+	}
+	if len(f.Records) != 2 || f.Records[0].Developer["Stryd"] != 280 {
+		t.Fatalf("records = %+v", f.Records)
+	}
+	if got := f.Records[1].Timestamp; !got.Equal(Time(timestamp + 3)) {
+		t.Fatalf("compressed timestamp = %v", got)
+	}
+}
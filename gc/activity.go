@@ -6,13 +6,56 @@ import (
 )
 
 type Activity struct {
-	ID         int64
-	Name       string
+	ID          int64
+	Name        string
+	Description string
+	// Type is the Garmin activity type key, e.g. "running" or "indoor_cycling".
+	Type string
+	// EventType is the Garmin event type key, e.g. "race" or "transportation".
+	EventType string
+	// Device is the name of the recording device, e.g. "Forerunner 235".
+	Device string
+	// LocationName is where the activity started, e.g. "Leeds".
+	LocationName string
+	// Distance and ElevationGain are in metres.
+	Distance      float64
+	ElevationGain float64
+	// Duration is the elapsed time; MovingTime leaves out stops.
+	Duration   time.Duration
+	MovingTime time.Duration
+	// AverageHR and MaxHR are in beats per minute, zero if no heart rate was
+	// recorded.
+	AverageHR float64
+	MaxHR     float64
+	// Calories is in kilocalories.
+	Calories   float64
 	UploadDate time.Time
-	StartTime  time.Time
-	EndTime    time.Time
+	// StartTime and EndTime are in the activity's time zone when it is known.
+	StartTime time.Time
+	EndTime   time.Time
+	// TimeZone is the zone the activity was recorded in, e.g. "Europe/London",
+	// or empty if it is not known.
+	TimeZone string
+	// ZoneAbbr and UTCOffset, in seconds east of UTC, describe the zone when
+	// Garmin gave only its offset, e.g. "IST" and 19800.
+	ZoneAbbr  string
+	UTCOffset int
+}
+
+// LocalStart returns the start time in the activity's own time zone, or in
+// fallback if that is not known.
+func (act Activity) LocalStart(fallback *time.Location) time.Time {
+	if act.TimeZone != "" {
+		if location, err := time.LoadLocation(act.TimeZone); err == nil {
+			return act.StartTime.In(location)
+		}
+	}
+	if act.ZoneAbbr != "" || act.UTCOffset != 0 {
+		return act.StartTime.In(time.FixedZone(act.ZoneAbbr, act.UTCOffset))
+	}
+	return act.StartTime.In(fallback)
 }
 
 func (act Activity) String() string {
-	return fmt.Sprintf("%d %s %v", act.ID, act.Name, act.UploadDate)
+	return fmt.Sprintf("%d %s %s", act.ID, act.Name, act.LocalStart(time.Local).Format("2006-01-02 15:04 MST"))
 }
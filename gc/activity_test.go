@@ -0,0 +1,74 @@
+package gc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimestampLayouts(t *testing.T) {
+	expected := time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)
+	for _, timestamp := range []gcTimestamp{
+		{Value: "2017-12-30T09:15:00.000Z"},
+		{Value: "2017-12-30T09:15:00Z"},
+		{Value: "2017-12-30T10:15:00.000+0100"},
+		{Value: "2017-12-30T09:15:00.0"},
+		{Value: "2017-12-30 09:15:00"},
+		{Value: "Sat, 30 Dec 2017 09:15", Millis: "1514625300000"},
+		{Millis: "1514625300000"},
+	} {
+		result, err := timestamp.goTime()
+		if err != nil || !result.Equal(expected) {
+			t.Fatalf("%+v = %v, %v", timestamp, result, err)
+		}
+	}
+	if result, err := (gcTimestamp{}).goTime(); err != nil || !result.IsZero() {
+		t.Fatalf("missing timestamp = %v, %v", result, err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		activity gcActivity
+		expected string
+	}{
+		{gcActivity{ID: 1, ActivitySummary: activitySummary{BeginTimestamp: gcTimestamp{Value: "yesterday"}}}, "Activity 1: start time: bad timestamp \"yesterday\""},
+		{gcActivity{ID: 2, UploadDate: gregorianCalendarTime{Millis: "soon"}}, "Activity 2: upload date"},
+		{gcActivity{ID: 3, ActivitySummary: activitySummary{SumDistance: gcMeasure{Value: "10,02", Unit: "kilometer"}}}, "Activity 3: distance: bad value \"10,02\""},
+		{gcActivity{ID: 4, ActivitySummary: activitySummary{MaxHeartRate: gcMeasure{Value: "--"}}}, "Activity 4: max heart rate"},
+		{gcActivity{ID: 5, TimeZone: gcTimeZone{Key: "Nowhere/Special", Offset: "east"}}, "Activity 5: time zone"},
+	} {
+		if _, err := test.activity.activity(); err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Fatalf("err = %v, expected %s", err, test.expected)
+		}
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	summary := activitySummary{BeginTimestamp: gcTimestamp{Value: "2017-12-30T09:15:00.000Z"}}
+	activity, err := gcActivity{ID: 1, TimeZone: gcTimeZone{Key: "Nowhere/Special", Offset: "5.5", Abbr: "IST"}, ActivitySummary: summary}.activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.TimeZone != "" || activity.StartTime.Format("15:04 MST") != "14:45 IST" {
+		t.Fatalf("start = %v in %q", activity.StartTime, activity.TimeZone)
+	}
+	// The offset survives being saved as JSON too.
+	saved := Activity{ID: 1, StartTime: activity.StartTime.UTC(), ZoneAbbr: activity.ZoneAbbr, UTCOffset: activity.UTCOffset}
+	if start := saved.LocalStart(time.UTC); start.Format("15:04 MST") != "14:45 IST" {
+		t.Fatalf("local start = %v", start)
+	}
+
+	activity, err = gcActivity{ID: 2, TimeZone: gcTimeZone{Key: "Australia/Sydney"}, ActivitySummary: summary}.activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The zone survives being saved as JSON in the retry queue.
+	saved = Activity{ID: 2, StartTime: activity.StartTime.UTC(), TimeZone: activity.TimeZone}
+	if start := saved.LocalStart(time.UTC); start.Format("2006-01-02 15:04") != "2017-12-30 20:15" {
+		t.Fatalf("local start = %v", start)
+	}
+	if s := saved.String(); !strings.HasSuffix(s, "2017-12-30 20:15 AEDT") {
+		t.Fatalf("String() = %q", s)
+	}
+}
@@ -1,29 +1,43 @@
 package gc
 
 import (
+	"archive/zip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type GarminConnect interface {
 	Login() error
 	NextActivity() *Activity
-	ExportTCX(activityID int64) ([]byte, error)
+	ExportTCX(activityID int64) (io.ReadCloser, error)
+	Export(activityID int64, format string) (io.ReadCloser, error)
+	Activities(since time.Time) ([]Activity, error)
+	Profile() (*Profile, error)
+	Gear(activityID int64) ([]Gear, error)
 }
 
 const userAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:57.0) Gecko/20100101 Firefox/57.0"
 
 const ssoURLStr = "https://sso.garmin.com/sso/login?service=https://connect.garmin.com/modern/&webhost=https://connect.garmin.com&source=https://connect.garmin.com/en-US/signin&redirectAfterAccountLoginUrl=https://connect.garmin.com/modern%&redirectAfterAccountCreationUrl=https://connect.garmin.com/modern/&gauthHost=https://sso.garmin.com/sso&locale=en_US&id=gauth-widget&cssUrl=https://static.garmincdn.com/com.garmin.connect/ui/css/gauth-custom-v1.2-min.css&privacyStatementUrl=//connect.garmin.com/en-US/privacy/&clientId=GarminConnect&rememberMeShown=true&rememberMeChecked=false&createAccountShown=true&openCreateAccount=false&displayNameShown=false&consumeServiceTicket=false&initialFocus=true&embedWidget=false&generateExtraServiceTicket=false&generateNoServiceTicket=false&globalOptInShown=true&globalOptInChecked=false&mobile=false&connectLegalTerms=true&locationPromptShown=true#"
 const activitySearchURLStr = "https://connect.garmin.com/proxy/activity-search-service-1.2/json/activities"
+const activitySearchPageSize = 100
 const exportTCXURLStr = "https://connect.garmin.com/modern/proxy/download-service/export/tcx/activity/%d"
+const exportGPXURLStr = "https://connect.garmin.com/modern/proxy/download-service/export/gpx/activity/%d"
+const exportOriginalURLStr = "https://connect.garmin.com/modern/proxy/download-service/files/activity/%d"
+const gearURLStr = "https://connect.garmin.com/modern/proxy/gear-service/gear/filterGear?activityId=%d"
+const socialProfileURLStr = "https://connect.garmin.com/modern/proxy/userprofile-service/socialProfile"
 
 var responseURLRegex = regexp.MustCompile(`\bvar response_url\s*=\s*"([^"]*)"`)
 
@@ -43,31 +57,220 @@ type gcActivityWrapper struct {
 type gcActivity struct {
 	ID              int64                 `json:"activityId"`
 	Name            string                `json:"activityName"`
+	Description     string                `json:"activityDescription"`
+	ActivityType    gcKey                 `json:"activityType"`
+	EventType       gcKey                 `json:"eventType"`
+	Device          gcDevice              `json:"device"`
+	LocationName    string                `json:"locationName"`
+	TimeZone        gcTimeZone            `json:"activityTimeZone"`
 	UploadDate      gregorianCalendarTime `json:"uploadDate"`
 	ActivitySummary activitySummary       `json:"activitySummary"`
 }
 
+// activity converts a search result, failing on any value that cannot be
+// parsed rather than leaving it zero.
+func (a gcActivity) activity() (Activity, error) {
+	activity := Activity{
+		ID:           a.ID,
+		Name:         a.Name,
+		Description:  a.Description,
+		Type:         a.ActivityType.Key,
+		EventType:    a.EventType.Key,
+		Device:       a.Device.Display,
+		LocationName: a.LocationName,
+	}
+	summary := a.ActivitySummary
+	var err error
+	fail := func(field string, err error) (Activity, error) {
+		return Activity{}, fmt.Errorf("Activity %d: %s: %v", a.ID, field, err)
+	}
+	for _, f := range []struct {
+		name  string
+		value func() (float64, error)
+		dest  *float64
+	}{
+		{"distance", summary.SumDistance.metres, &activity.Distance},
+		{"elevation gain", summary.GainElevation.metres, &activity.ElevationGain},
+		{"average heart rate", summary.WeightedMeanHeartRate.float, &activity.AverageHR},
+		{"max heart rate", summary.MaxHeartRate.float, &activity.MaxHR},
+		{"calories", summary.SumEnergy.float, &activity.Calories},
+	} {
+		if *f.dest, err = f.value(); err != nil {
+			return fail(f.name, err)
+		}
+	}
+	if activity.Duration, err = summary.SumElapsedDuration.duration(); err != nil {
+		return fail("duration", err)
+	}
+	if activity.MovingTime, err = summary.SumMovingDuration.duration(); err != nil {
+		return fail("moving time", err)
+	}
+	if activity.UploadDate, err = a.UploadDate.goTime(); err != nil {
+		return fail("upload date", err)
+	}
+	if activity.StartTime, err = summary.BeginTimestamp.goTime(); err != nil {
+		return fail("start time", err)
+	}
+	if activity.EndTime, err = summary.EndTimestamp.goTime(); err != nil {
+		return fail("end time", err)
+	}
+	location, err := a.TimeZone.location()
+	if err != nil {
+		return fail("time zone", err)
+	}
+	if location != nil {
+		activity.StartTime = activity.StartTime.In(location)
+		activity.EndTime = activity.EndTime.In(location)
+		if location.String() == a.TimeZone.Key {
+			activity.TimeZone = a.TimeZone.Key
+		} else {
+			activity.ZoneAbbr, activity.UTCOffset = activity.StartTime.Zone()
+		}
+	}
+	return activity, nil
+}
+
+type gcKey struct {
+	Key string `json:"key"`
+}
+
+type gcDevice struct {
+	Display string `json:"display"`
+}
+
+// gcTimeZone is the zone an activity was recorded in. Offset is in hours.
+type gcTimeZone struct {
+	Key    string `json:"key"`
+	Offset string `json:"offset"`
+	Abbr   string `json:"abbr"`
+}
+
+// location returns the zone named by Key, falling back to a fixed zone at
+// Offset when this system does not know the name. It returns nil if there is
+// neither.
+func (z gcTimeZone) location() (*time.Location, error) {
+	if z.Key != "" {
+		if location, err := time.LoadLocation(z.Key); err == nil {
+			return location, nil
+		}
+	}
+	if z.Offset == "" {
+		return nil, nil
+	}
+	hours, err := strconv.ParseFloat(z.Offset, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad offset %q", z.Offset)
+	}
+	return time.FixedZone(z.Abbr, int(hours*3600)), nil
+}
+
 type gregorianCalendarTime struct {
 	Millis string `json:"millis"`
 }
 
-func (g gregorianCalendarTime) goTime() time.Time {
-	millis, _ := strconv.ParseInt(g.Millis, 10, 64)
-	return time.Unix(0, millis*int64(time.Millisecond))
+// goTime returns the zero time if the value is missing.
+func (g gregorianCalendarTime) goTime() (time.Time, error) {
+	return millisTime(g.Millis)
+}
+
+func millisTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad timestamp %q", value)
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)), nil
 }
 
 type activitySummary struct {
-	BeginTimestamp gcTimestamp
-	EndTimestamp   gcTimestamp
+	BeginTimestamp        gcTimestamp
+	EndTimestamp          gcTimestamp
+	SumDistance           gcMeasure
+	SumElapsedDuration    gcMeasure
+	SumMovingDuration     gcMeasure
+	GainElevation         gcMeasure
+	WeightedMeanHeartRate gcMeasure
+	MaxHeartRate          gcMeasure
+	SumEnergy             gcMeasure
 }
 
-type gcTimestamp struct {
+type gcMeasure struct {
 	Value string `json:"value"`
+	Unit  string `json:"uom"`
 }
 
-func (t gcTimestamp) goTime() time.Time {
-	result, _ := time.Parse(time.RFC3339, t.Value)
-	return result
+// float returns the value as given, or zero if it is missing.
+func (m gcMeasure) float() (float64, error) {
+	if m.Value == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(m.Value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad value %q", m.Value)
+	}
+	return value, nil
+}
+
+// metres converts a distance measure to metres.
+func (m gcMeasure) metres() (float64, error) {
+	value, err := m.float()
+	switch m.Unit {
+	case "kilometer":
+		value *= 1000
+	case "mile":
+		value *= 1609.344
+	case "foot":
+		value *= 0.3048
+	case "centimeter":
+		value /= 100
+	}
+	return value, err
+}
+
+// duration converts a measure in seconds, or milliseconds, to a
+// time.Duration.
+func (m gcMeasure) duration() (time.Duration, error) {
+	value, err := m.float()
+	unit := time.Second
+	if m.Unit == "ms" || m.Unit == "millisecond" {
+		unit = time.Millisecond
+	}
+	return time.Duration(value * float64(unit)), err
+}
+
+type gcTimestamp struct {
+	Value  string `json:"value"`
+	Millis string `json:"millis"`
+}
+
+// timestampLayouts are the forms Garmin gives times in. Those without a zone
+// are UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000Z0700",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// goTime parses the value, falling back to millis. It returns the zero time
+// if both are missing.
+func (t gcTimestamp) goTime() (time.Time, error) {
+	if t.Value == "" {
+		return millisTime(t.Millis)
+	}
+	for _, layout := range timestampLayouts {
+		if result, err := time.Parse(layout, t.Value); err == nil {
+			return result, nil
+		}
+	}
+	if t.Millis != "" {
+		return millisTime(t.Millis)
+	}
+	return time.Time{}, fmt.Errorf("bad timestamp %q", t.Value)
 }
 
 type gcCookieJar struct {
@@ -92,9 +295,12 @@ func (jar *gcCookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
 	jar.CookieJar.SetCookies(u, cookies)
 }
 
+// garminConnectImpl is safe for concurrent use. mu guards client, which is
+// replaced by Login, and the activity list and position.
 type garminConnectImpl struct {
 	username        string
 	password        string
+	mu              sync.Mutex
 	client          *http.Client
 	activities      []Activity
 	activityCounter int
@@ -107,7 +313,9 @@ func NewGarminConnect(username, password string) GarminConnect {
 func (gc *garminConnectImpl) Login() error {
 	// 1st do a GET on the SSO URL to get a session cookie
 	cookieJar, _ := newCookieJar(nil)
+	gc.mu.Lock()
 	gc.client = &http.Client{Timeout: 10 * time.Second, Jar: cookieJar}
+	gc.mu.Unlock()
 	if err := gc.loadPage(ssoURLStr); err != nil {
 		return err
 	}
@@ -123,7 +331,7 @@ func (gc *garminConnectImpl) Login() error {
 	}
 	request.Header.Set("User-Agent", userAgent)
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := gc.client.Do(request)
+	resp, err := gc.httpClient().Do(request)
 	if err != nil {
 		return err
 	}
@@ -147,16 +355,13 @@ func (gc *garminConnectImpl) Login() error {
 		return err
 	}
 
-	//body, err = ioutil.ReadAll(resp.Body)
-	//fmt.Println(string(body))
-
 	return gc.getActivities()
 }
 
 func (gc *garminConnectImpl) loadPage(url string) error {
 	request, err := http.NewRequest("GET", url, nil)
 	request.Header.Set("User-Agent", userAgent)
-	resp, err := gc.client.Do(request)
+	resp, err := gc.httpClient().Do(request)
 	if err != nil {
 		return err
 	}
@@ -190,38 +395,92 @@ func getTGTCookie(jar *cookiejar.Jar) (string, error) {
 }
 
 func (gc *garminConnectImpl) getActivities() error {
-	request, err := http.NewRequest("GET", activitySearchURLStr, nil)
-	request.Header.Set("User-Agent", userAgent)
-	resp, err := gc.client.Do(request)
+	activities, _, err := gc.searchActivities(nil)
 	if err != nil {
 		return err
 	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.activities = activities
+	gc.activityCounter = 0
+	return nil
+}
+
+// Activities lists every activity started at or after since, newest first,
+// paging through the search results. Unlike NextActivity it is not limited
+// to the first page Login fetches.
+func (gc *garminConnectImpl) Activities(since time.Time) ([]Activity, error) {
+	var result []Activity
+	for start := 0; ; start += activitySearchPageSize {
+		params := url.Values{}
+		params.Set("start", strconv.Itoa(start))
+		params.Set("limit", strconv.Itoa(activitySearchPageSize))
+		page, listed, err := gc.searchActivities(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, activity := range page {
+			if activity.StartTime.Before(since) {
+				return result, nil
+			}
+			result = append(result, activity)
+		}
+		if listed < activitySearchPageSize {
+			return result, nil
+		}
+	}
+}
+
+// searchActivities fetches a page of search results, returning the
+// activities and how many results the page held. Activities that cannot be
+// decoded are logged and left out.
+func (gc *garminConnectImpl) searchActivities(params url.Values) ([]Activity, int, error) {
+	searchURL := activitySearchURLStr
+	if len(params) > 0 {
+		searchURL += "?" + params.Encode()
+	}
+	request, err := http.NewRequest("GET", searchURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	resp, err := gc.httpClient().Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		msg, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Activity search: unexpected status code : %d: %s", resp.StatusCode, msg)
+		return nil, 0, fmt.Errorf("Activity search: unexpected status code : %d: %s", resp.StatusCode, msg)
 	}
 	decoder := json.NewDecoder(resp.Body)
 	var activitiesPage activitiesPage
 	err = decoder.Decode(&activitiesPage)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	gc.activities = make([]Activity, len(activitiesPage.Results.Activities))
-	idx := 0
-	for _, gcActivityWrapper := range activitiesPage.Results.Activities {
-		gcActivity := gcActivityWrapper.Activity
-		gc.activities[idx] = Activity{ID: gcActivity.ID,
-			Name:       gcActivity.Name,
-			UploadDate: gcActivity.UploadDate.goTime(),
-			StartTime:  gcActivity.ActivitySummary.BeginTimestamp.goTime(),
-			EndTime:    gcActivity.ActivitySummary.EndTimestamp.goTime()}
-		idx++
+	results := activitiesPage.Results.Activities
+	activities := make([]Activity, 0, len(results))
+	for _, gcActivityWrapper := range results {
+		activity, err := gcActivityWrapper.Activity.activity()
+		if err != nil {
+			slog.Warn("Skipping activity", "error", err)
+			continue
+		}
+		activities = append(activities, activity)
 	}
-	return nil
+	return activities, len(results), nil
+}
+
+func (gc *garminConnectImpl) httpClient() *http.Client {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.client
 }
 
 func (gc *garminConnectImpl) NextActivity() *Activity {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
 	if len(gc.activities) > gc.activityCounter {
 		result := &gc.activities[gc.activityCounter]
 		gc.activityCounter++
@@ -230,18 +489,137 @@ func (gc *garminConnectImpl) NextActivity() *Activity {
 	return nil
 }
 
-func (gc garminConnectImpl) ExportTCX(activityID int64) ([]byte, error) {
-	exportURL := fmt.Sprintf(exportTCXURLStr, activityID)
+func (gc *garminConnectImpl) ExportTCX(activityID int64) (io.ReadCloser, error) {
+	return gc.Export(activityID, "tcx")
+}
+
+// Export streams an activity as "tcx", "gpx" or "fit"; the caller must close
+// it. FIT files are the originals recorded by the device.
+func (gc *garminConnectImpl) Export(activityID int64, format string) (io.ReadCloser, error) {
+	var exportURL string
+	switch format {
+	case "tcx":
+		exportURL = fmt.Sprintf(exportTCXURLStr, activityID)
+	case "gpx":
+		exportURL = fmt.Sprintf(exportGPXURLStr, activityID)
+	case "fit":
+		exportURL = fmt.Sprintf(exportOriginalURLStr, activityID)
+	default:
+		return nil, fmt.Errorf("Export: unsupported format %q", format)
+	}
 	request, err := http.NewRequest("GET", exportURL, nil)
+	if err != nil {
+		return nil, err
+	}
 	request.Header.Set("User-Agent", userAgent)
-	resp, err := gc.client.Do(request)
+	resp, err := gc.httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Export %s: unexpected status code : %d: %s", strings.ToUpper(format), resp.StatusCode, msg)
+	}
+	if format != "fit" {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	return unzipFIT(resp.Body)
+}
+
+// spooledFile is a file inside a zip archive spooled to disk, removed when
+// the file is closed.
+type spooledFile struct {
+	io.ReadCloser
+	cleanup func()
+}
+
+func (f spooledFile) Close() error {
+	err := f.ReadCloser.Close()
+	f.cleanup()
+	return err
+}
+
+// unzipFIT extracts the FIT file from an original activity download. The
+// zip directory is at the end of the archive, so the download is spooled to
+// a temporary file rather than held in memory.
+func unzipFIT(body io.Reader) (io.ReadCloser, error) {
+	spool, err := ioutil.TempFile("", "gravasync-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("Export FIT: %v", err)
+	}
+	for _, file := range archive.File {
+		if !strings.HasSuffix(strings.ToLower(file.Name), ".fit") {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		return spooledFile{ReadCloser: r, cleanup: cleanup}, nil
+	}
+	cleanup()
+	return nil, fmt.Errorf("Export FIT: no FIT file in original download")
+}
+
+func (gc *garminConnectImpl) Profile() (*Profile, error) {
+	request, err := http.NewRequest("GET", socialProfileURLStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	resp, err := gc.httpClient().Do(request)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		msg, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Export TCX: unexpected status code : %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("Profile: unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var profile Profile
+	if err = decoder.Decode(&profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+func (gc *garminConnectImpl) Gear(activityID int64) ([]Gear, error) {
+	request, err := http.NewRequest("GET", fmt.Sprintf(gearURLStr, activityID), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	resp, err := gc.httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Gear: unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var gear []Gear
+	if err = decoder.Decode(&gear); err != nil {
+		return nil, err
 	}
-	return ioutil.ReadAll(resp.Body)
+	return gear, nil
 }
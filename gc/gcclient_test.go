@@ -42,7 +42,12 @@ func TestExportActivity(t *testing.T) {
 		t.Fatal(fmt.Errorf("activity == nil"))
 	}
 	fmt.Println(activity)
-	tcxBytes, err := gc.ExportTCX(activity.ID)
+	export, err := gc.ExportTCX(activity.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer export.Close()
+	tcxBytes, err := ioutil.ReadAll(export)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,15 @@
+package gc
+
+type Gear struct {
+	UUID        string `json:"uuid"`
+	DisplayName string `json:"displayName"`
+	MakeModel   string `json:"customMakeModel"`
+	GearType    string `json:"gearTypeName"`
+}
+
+func (g Gear) String() string {
+	if g.DisplayName != "" {
+		return g.DisplayName
+	}
+	return g.MakeModel
+}
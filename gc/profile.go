@@ -0,0 +1,14 @@
+package gc
+
+import "fmt"
+
+type Profile struct {
+	DisplayName string `json:"displayName"`
+	FullName    string `json:"fullName"`
+	UserName    string `json:"userName"`
+	Location    string `json:"location"`
+}
+
+func (p Profile) String() string {
+	return fmt.Sprintf("%s (%s)", p.FullName, p.DisplayName)
+}
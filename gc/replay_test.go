@@ -0,0 +1,60 @@
+package gc
+
+import (
+	"math"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/httplog"
+)
+
+func replayClient(t *testing.T, cassette string) (*garminConnectImpl, *httplog.Replayer) {
+	replayer, err := httplog.NewReplayer(filepath.Join("testdata", cassette))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &garminConnectImpl{client: &http.Client{Transport: replayer}}, replayer
+}
+
+func TestActivitiesReplay(t *testing.T) {
+	gc, replayer := replayClient(t, "activity-search.json")
+	activities, err := gc.Activities(time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 || replayer.Pending() != 0 {
+		t.Fatalf("activities = %+v", activities)
+	}
+	run, ride := activities[0], activities[1]
+	if run.ID != 2417531412 || run.Type != "running" || run.Device != "Forerunner 235" || run.Distance != 10020 {
+		t.Fatalf("run = %+v", run)
+	}
+	if !run.StartTime.Equal(time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)) || run.Duration != 2833*time.Second {
+		t.Fatalf("run times = %v, %v", run.StartTime, run.Duration)
+	}
+	if !run.UploadDate.Equal(time.Unix(1514626000, 0)) {
+		t.Fatalf("upload date = %v", run.UploadDate)
+	}
+	if ride.EventType != "transportation" || math.Abs(ride.Distance-9817) > 1 {
+		t.Fatalf("ride = %+v", ride)
+	}
+	if run.MovingTime != 2790*time.Second || run.ElevationGain != 45 || run.AverageHR != 152 || run.MaxHR != 171 || run.Calories != 690 || run.LocationName != "Leeds" {
+		t.Fatalf("run summary = %+v", run)
+	}
+	if ride.TimeZone != "America/New_York" || ride.StartTime.Hour() != 8 || ride.EndTime.Minute() != 25 {
+		t.Fatalf("ride start = %v in %q", ride.StartTime, ride.TimeZone)
+	}
+}
+
+func TestActivitiesSkipsMalformed(t *testing.T) {
+	gc, _ := replayClient(t, "activity-search-malformed.json")
+	activities, err := gc.Activities(time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 1 || activities[0].Name != "Commute" {
+		t.Fatalf("activities = %+v", activities)
+	}
+}
@@ -0,0 +1,123 @@
+package gpx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+const (
+	// Namespace is the GPX 1.1 schema.
+	Namespace = "http://www.topografix.com/GPX/1/1"
+	// TrackPointExtensionNamespace is Garmin's TrackPointExtension v1 schema,
+	// used for heart rate, cadence and temperature.
+	TrackPointExtensionNamespace = "http://www.garmin.com/xmlschemas/TrackPointExtension/v1"
+)
+
+type GPX struct {
+	XMLName  xml.Name  `xml:"gpx"`
+	Xmlns    string    `xml:"xmlns,attr,omitempty"`
+	Version  string    `xml:"version,attr"`
+	Creator  string    `xml:"creator,attr"`
+	Metadata *Metadata `xml:"metadata,omitempty"`
+	Tracks   []Track   `xml:"trk"`
+}
+
+type Metadata struct {
+	Name string    `xml:"name,omitempty"`
+	Time time.Time `xml:"time"`
+}
+
+type Track struct {
+	Name     string    `xml:"name,omitempty"`
+	Type     string    `xml:"type,omitempty"`
+	Segments []Segment `xml:"trkseg"`
+}
+
+type Segment struct {
+	Points []Point `xml:"trkpt"`
+}
+
+// Point is a track point. Elevation is in metres.
+type Point struct {
+	Lat        float64     `xml:"lat,attr"`
+	Lon        float64     `xml:"lon,attr"`
+	Elevation  *float64    `xml:"ele,omitempty"`
+	Time       time.Time   `xml:"time"`
+	Extensions *Extensions `xml:"extensions,omitempty"`
+}
+
+// Extensions holds Garmin's TrackPointExtension and the widely used bare
+// power element.
+type Extensions struct {
+	TrackPointExtension *TrackPointExtension `xml:"TrackPointExtension,omitempty"`
+	Power               *int                 `xml:"power,omitempty"`
+}
+
+type TrackPointExtension struct {
+	Xmlns       string   `xml:"xmlns,attr,omitempty"`
+	Temperature *float64 `xml:"atemp,omitempty"`
+	HeartRate   *int     `xml:"hr,omitempty"`
+	Cadence     *int     `xml:"cad,omitempty"`
+}
+
+// Parse decodes a GPX document.
+func Parse(r io.Reader) (*GPX, error) {
+	var g GPX
+	if err := xml.NewDecoder(r).Decode(&g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ParseBytes decodes a GPX document held in memory.
+func ParseBytes(data []byte) (*GPX, error) {
+	return Parse(bytes.NewReader(data))
+}
+
+// Write encodes the document with GPX 1.1 namespaces.
+func (g *GPX) Write(w io.Writer) error {
+	g.Xmlns = Namespace
+	g.Version = "1.1"
+	if g.Creator == "" {
+		g.Creator = "gravasync"
+	}
+	for i := range g.Tracks {
+		for j := range g.Tracks[i].Segments {
+			for _, p := range g.Tracks[i].Segments[j].Points {
+				if p.Extensions != nil && p.Extensions.TrackPointExtension != nil {
+					p.Extensions.TrackPointExtension.Xmlns = TrackPointExtensionNamespace
+				}
+			}
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(g); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Bytes returns the encoded document.
+func (g *GPX) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	if err := g.Write(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Points returns all of a track's points in segment order.
+func (t Track) Points() []Point {
+	var result []Point
+	for _, s := range t.Segments {
+		result = append(result, s.Points...)
+	}
+	return result
+}
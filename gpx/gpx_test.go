@@ -0,0 +1,62 @@
+package gpx
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const sample = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx creator="Garmin Connect" version="1.1" xmlns="http://www.topografix.com/GPX/1/1" xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v1">
+  <metadata><time>2017-12-30T09:15:00.000Z</time></metadata>
+  <trk>
+    <name>Leeds Running</name>
+    <type>running</type>
+    <trkseg>
+      <trkpt lat="53.8" lon="-1.55">
+        <ele>60.2</ele>
+        <time>2017-12-30T09:15:00.000Z</time>
+        <extensions><gpxtpx:TrackPointExtension><gpxtpx:hr>120</gpxtpx:hr><gpxtpx:cad>84</gpxtpx:cad></gpxtpx:TrackPointExtension></extensions>
+      </trkpt>
+      <trkpt lat="53.8001" lon="-1.5501">
+        <time>2017-12-30T09:15:01.000Z</time>
+      </trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestParseAndWrite(t *testing.T) {
+	g, err := ParseBytes([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Tracks) != 1 || g.Tracks[0].Name != "Leeds Running" {
+		t.Fatalf("tracks = %+v", g.Tracks)
+	}
+	points := g.Tracks[0].Points()
+	if len(points) != 2 || points[0].Lat != 53.8 || *points[0].Elevation != 60.2 {
+		t.Fatalf("points = %+v", points)
+	}
+	ext := points[0].Extensions.TrackPointExtension
+	if ext == nil || *ext.HeartRate != 120 || *ext.Cadence != 84 {
+		t.Fatalf("extension = %+v", ext)
+	}
+	if !points[1].Time.Equal(time.Date(2017, 12, 30, 9, 15, 1, 0, time.UTC)) {
+		t.Fatalf("time = %v", points[1].Time)
+	}
+
+	out, err := g.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `<TrackPointExtension xmlns="`+TrackPointExtensionNamespace+`">`) {
+		t.Fatalf("extension namespace missing:\n%s", out)
+	}
+	again, err := ParseBytes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *again.Tracks[0].Points()[0].Extensions.TrackPointExtension.HeartRate != 120 {
+		t.Fatalf("round trip lost heart rate:\n%s", out)
+	}
+}
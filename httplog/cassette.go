@@ -0,0 +1,196 @@
+package httplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Interaction is one recorded request and its response. Text bodies are
+// stored redacted in Body, binary ones as they are in BodyBase64.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 []byte      `json:"bodyBase64,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 []byte      `json:"bodyBase64,omitempty"`
+}
+
+// Cassette is a file of recorded interactions, in request order.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette written by a Recorder.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("Cassette %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// Recorder is an http.RoundTripper saving every interaction to a cassette,
+// with credentials redacted. Bodies are read in full before the request is
+// sent and before the response is returned, so uploads are buffered in
+// memory while recording.
+type Recorder struct {
+	Base     http.RoundTripper
+	path     string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder writing to path, wrapping base or
+// http.DefaultTransport if base is nil. The cassette is rewritten after
+// every interaction.
+func NewRecorder(base http.RoundTripper, path string) (*Recorder, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return &Recorder{Base: base, path: path}, nil
+}
+
+func (r *Recorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	recorded := RecordedRequest{Method: request.Method, URL: Redact(request.URL.String()), Header: RedactHeader(request.Header)}
+	if request.Body != nil {
+		body, err := ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		request = request.Clone(request.Context())
+		request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		recorded.Body, recorded.BodyBase64 = encodeBody(body, request.Header.Get("Content-Type"))
+	}
+	resp, err := r.Base.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	response := RecordedResponse{StatusCode: resp.StatusCode, Header: RedactHeader(resp.Header)}
+	response.Body, response.BodyBase64 = encodeBody(body, resp.Header.Get("Content-Type"))
+	if err := r.add(Interaction{Request: recorded, Response: response}); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Record HTTP: %v", err)
+	}
+	return resp, nil
+}
+
+func (r *Recorder) add(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	data, err := json.MarshalIndent(&r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, data, 0600)
+}
+
+func encodeBody(body []byte, contentType string) (string, []byte) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	if isText(contentType) {
+		return Redact(string(body)), nil
+	}
+	return "", body
+}
+
+// Replayer is an http.RoundTripper serving the responses in a cassette.
+// Requests are matched on method and redacted URL; repeated requests get
+// the recorded responses in order.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// NewReplayer loads the cassette at path for replay.
+func NewReplayer(path string) (*Replayer, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}, nil
+}
+
+func (r *Replayer) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	url := Redact(request.URL.String())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != request.Method || interaction.Request.URL != url {
+			continue
+		}
+		r.used[i] = true
+		recorded := interaction.Response
+		body := recorded.BodyBase64
+		if body == nil {
+			body = []byte(recorded.Body)
+		}
+		header := recorded.Header
+		if header == nil {
+			header = http.Header{}
+		}
+		return &http.Response{
+			StatusCode:    recorded.StatusCode,
+			Status:        strconv.Itoa(recorded.StatusCode) + " " + http.StatusText(recorded.StatusCode),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       request,
+		}, nil
+	}
+	return nil, fmt.Errorf("Replay: no recorded response for %s %s", request.Method, url)
+}
+
+// Pending returns the number of recorded interactions not yet replayed.
+func (r *Replayer) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := 0
+	for _, used := range r.used {
+		if !used {
+			pending++
+		}
+	}
+	return pending
+}
@@ -0,0 +1,81 @@
+package httplog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/fit" {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte{0x0e, 0x10, 0xff, 0x00})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "CASTGC=TGT-secret; Path=/")
+		w.Write([]byte(`{"access_token":"secret-token","calls":` + strconv.Itoa(calls) + `}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fixtures", "session.json")
+
+	recorder, err := NewRecorder(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder}
+	get := func(client *http.Client, path string) string {
+		resp, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	var recorded []string
+	for _, path := range []string{"/token?code=secret-code", "/token?code=other-code", "/fit"} {
+		recorded = append(recorded, get(client, path))
+	}
+	if !strings.Contains(recorded[0], "secret-token") {
+		t.Fatal("recording should not alter the live response")
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(data), "secret-") || strings.Contains(string(data), "TGT-") {
+		t.Fatalf("credentials recorded:\n%s", data)
+	}
+
+	replayer, err := NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: replayer}
+	if body := get(client, "/token?code=anything"); !strings.Contains(body, `"calls":1`) || strings.Contains(body, "secret") {
+		t.Fatalf("first replay = %s", body)
+	}
+	if body := get(client, "/token?code=anything"); !strings.Contains(body, `"calls":2`) {
+		t.Fatalf("second replay = %s", body)
+	}
+	if body := get(client, "/fit"); body != recorded[2] {
+		t.Fatalf("binary replay = %q", body)
+	}
+	if replayer.Pending() != 0 || calls != 3 {
+		t.Fatalf("pending %d, server calls %d", replayer.Pending(), calls)
+	}
+	if _, err := client.Get(server.URL + "/fit"); err == nil {
+		t.Fatal("expected no more recorded responses")
+	}
+}
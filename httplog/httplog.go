@@ -0,0 +1,177 @@
+// Package httplog logs HTTP traffic for diagnosing breakages in the services
+// gravasync talks to. Passwords, cookies, OAuth codes, tickets and tokens are
+// redacted from everything it writes.
+package httplog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+}
+
+var (
+	sensitiveParam = regexp.MustCompile(`(?i)\b(password|code|ticket|client_secret|access_token|refresh_token|token)=[^&\s"'<>]*`)
+	sensitiveJSON  = regexp.MustCompile(`(?i)"(password|code|ticket|client_secret|access_token|refresh_token|token)"\s*:\s*"[^"]*"`)
+	bearerToken    = regexp.MustCompile(`(?i)\bBearer\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+// Redact removes credentials from text: query or form parameters, JSON
+// fields and bearer tokens.
+func Redact(text string) string {
+	text = sensitiveParam.ReplaceAllString(text, "${1}="+redacted)
+	text = sensitiveJSON.ReplaceAllString(text, `"${1}":"`+redacted+`"`)
+	return bearerToken.ReplaceAllString(text, "Bearer "+redacted)
+}
+
+// RedactHeader returns a copy of header with credential headers replaced,
+// cookie values replaced but their names kept, and other values, such as
+// redirect locations, passed through Redact.
+func RedactHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			switch name = http.CanonicalHeaderKey(name); {
+			case sensitiveHeaders[name]:
+				value = redacted
+			case name == "Cookie":
+				value = redactCookies(value, ";")
+			case name == "Set-Cookie":
+				value = redactCookies(value, "")
+			default:
+				value = Redact(value)
+			}
+			result[name] = append(result[name], value)
+		}
+	}
+	return result
+}
+
+// redactCookies replaces the values in a Cookie header, where cookies are
+// separated by "; ", or the first value of a Set-Cookie header, where the
+// rest are attributes.
+func redactCookies(value, separator string) string {
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		if i > 0 && separator == "" {
+			break
+		}
+		if eq := strings.Index(part, "="); eq >= 0 {
+			parts[i] = part[:eq+1] + redacted
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// Transport is an http.RoundTripper logging each request and response at
+// debug level: method, URL, status, timing and headers.
+type Transport struct {
+	Base   http.RoundTripper
+	Logger *slog.Logger
+	// BodyDir, if set, receives each request and response body in a file
+	// numbered in request order. Text bodies are redacted; binary ones, such
+	// as FIT files, are written as they are. Only the part of a body that is
+	// read is written.
+	BodyDir string
+	seq     int64
+}
+
+// New returns a Transport wrapping base, or http.DefaultTransport if base is
+// nil.
+func New(base http.RoundTripper, logger *slog.Logger, bodyDir string) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Logger: logger, BodyDir: bodyDir}
+}
+
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	seq := atomic.AddInt64(&t.seq, 1)
+	url := Redact(request.URL.String())
+	t.Logger.Debug("HTTP request", "seq", seq, "method", request.Method, "url", url, "headers", RedactHeader(request.Header))
+	if t.BodyDir != "" && request.Body != nil {
+		name := t.bodyFile(seq, request, "request")
+		request = request.Clone(request.Context())
+		request.Body = t.capture(request.Body, request.Header.Get("Content-Type"), name)
+	}
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(request)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Logger.Debug("HTTP request failed", "seq", seq, "method", request.Method, "url", url, "duration", elapsed, "err", Redact(err.Error()))
+		return nil, err
+	}
+	t.Logger.Debug("HTTP response", "seq", seq, "method", request.Method, "url", url, "status", resp.StatusCode, "duration", elapsed, "headers", RedactHeader(resp.Header))
+	if t.BodyDir != "" {
+		resp.Body = t.capture(resp.Body, resp.Header.Get("Content-Type"), t.bodyFile(seq, request, "response"))
+	}
+	return resp, nil
+}
+
+func (t *Transport) bodyFile(seq int64, request *http.Request, kind string) string {
+	return filepath.Join(t.BodyDir, fmt.Sprintf("%04d-%s-%s-%s", seq, request.Method, request.URL.Hostname(), kind))
+}
+
+func (t *Transport) capture(body io.ReadCloser, contentType, name string) io.ReadCloser {
+	return &capturedBody{body: body, text: isText(contentType), name: name, logger: t.Logger}
+}
+
+// capturedBody copies a body as it is read and writes the copy to a file
+// when closed.
+type capturedBody struct {
+	body   io.ReadCloser
+	text   bool
+	name   string
+	logger *slog.Logger
+	copy   bytes.Buffer
+	closed bool
+}
+
+func (c *capturedBody) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	c.copy.Write(p[:n])
+	return n, err
+}
+
+func (c *capturedBody) Close() error {
+	err := c.body.Close()
+	if c.closed {
+		return err
+	}
+	c.closed = true
+	data := c.copy.Bytes()
+	if c.text {
+		data = []byte(Redact(string(data)))
+	}
+	if writeErr := ioutil.WriteFile(c.name, data, 0600); writeErr != nil {
+		c.logger.Warn("Could not save HTTP body", "file", c.name, "err", writeErr)
+	}
+	return err
+}
+
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		strings.HasSuffix(mediaType, "javascript") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
@@ -0,0 +1,84 @@
+package httplog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://sso.garmin.com/?ticket=ST-123&service=x": "https://sso.garmin.com/?ticket=REDACTED&service=x",
+		"username=bob&password=hunter2&embed=false":       "username=bob&password=REDACTED&embed=false",
+		`{"access_token":"abc","refresh_token": "def"}`:   `{"access_token":"REDACTED","refresh_token":"REDACTED"}`,
+		"client_id=1&client_secret=s3cret&code=xyz":       "client_id=1&client_secret=REDACTED&code=REDACTED",
+		"Bearer 0123abcd": "Bearer REDACTED",
+		`var response_url = "https://x/?ticket=ST-9-abc";`: `var response_url = "https://x/?ticket=REDACTED";`,
+		"postcode=LS1": "postcode=LS1",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+	header := RedactHeader(http.Header{
+		"Set-Cookie": {"CASTGC=TGT-1; Path=/"},
+		"Cookie":     {"SESSION=abc; CASTGC=TGT-1"},
+		"Location":   {"https://connect.garmin.com/modern/?ticket=ST-1"},
+		"X-Other":    {"plain"},
+	})
+	if header.Get("Set-Cookie") != "CASTGC=REDACTED; Path=/" || header.Get("Cookie") != "SESSION=REDACTED; CASTGC=REDACTED" || header.Get("Location") != "https://connect.garmin.com/modern/?ticket=REDACTED" || header.Get("X-Other") != "plain" {
+		t.Fatalf("header = %v", header)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "SESSION=secret-session")
+		w.Write([]byte(`{"access_token":"secret-token","athlete":{"id":1}}`))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "httplog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := &http.Client{Transport: New(nil, logger, dir)}
+	request, _ := http.NewRequest("POST", server.URL+"/oauth/token?code=secret-code", strings.NewReader("client_secret=secret-client"))
+	request.Header.Set("Authorization", "Bearer secret-bearer")
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "secret-token") {
+		t.Fatal("the caller should see the real body")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Fatalf("body files = %v", files)
+	}
+	saved := logs.String()
+	for _, file := range files {
+		data, _ := ioutil.ReadFile(file)
+		saved += string(data)
+	}
+	if strings.Contains(saved, "secret-") {
+		t.Fatalf("credentials leaked:\n%s", saved)
+	}
+	if !strings.Contains(logs.String(), `"status":200`) || !strings.Contains(saved, `"athlete":{"id":1}`) {
+		t.Fatalf("logs = %s", saved)
+	}
+}
@@ -0,0 +1,125 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry records a Garmin Connect activity that has been synced to Strava.
+type Entry struct {
+	GarminID         int64     `json:"garminId"`
+	StravaActivityID int64     `json:"stravaActivityId,omitempty"`
+	Name             string    `json:"name"`
+	UploadedAt       time.Time `json:"uploadedAt"`
+}
+
+// Ledger tracks which Garmin activities have already been uploaded so that
+// repeated syncs do not create duplicates. Implementations are safe for
+// concurrent use.
+type Ledger interface {
+	Contains(garminID int64) bool
+	Get(garminID int64) (Entry, bool)
+	Record(entry Entry) error
+	Entries() []Entry
+}
+
+type fileLedger struct {
+	mu      sync.RWMutex
+	path    string
+	entries []Entry
+	index   map[int64]int
+}
+
+// Open loads the ledger stored at path, creating parent directories as
+// needed. The file holds one JSON entry per line and is appended to on every
+// Record.
+func Open(path string) (Ledger, error) {
+	l := &fileLedger{path: path, index: map[int64]int{}}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("Ledger %s line %d: %v", path, line, err)
+		}
+		l.add(entry)
+	}
+	return l, scanner.Err()
+}
+
+func (l *fileLedger) add(entry Entry) {
+	if idx, ok := l.index[entry.GarminID]; ok {
+		l.entries[idx] = entry
+		return
+	}
+	l.index[entry.GarminID] = len(l.entries)
+	l.entries = append(l.entries, entry)
+}
+
+func (l *fileLedger) Contains(garminID int64) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.index[garminID]
+	return ok
+}
+
+func (l *fileLedger) Get(garminID int64) (Entry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	idx, ok := l.index[garminID]
+	if !ok {
+		return Entry{}, false
+	}
+	return l.entries[idx], true
+}
+
+func (l *fileLedger) Record(entry Entry) error {
+	if entry.UploadedAt.IsZero() {
+		entry.UploadedAt = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	l.add(entry)
+	return nil
+}
+
+func (l *fileLedger) Entries() []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return append([]Entry(nil), l.entries...)
+}
@@ -0,0 +1,38 @@
+package ledger
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alice", "ledger.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Contains(42) {
+		t.Fatal("empty ledger contains 42")
+	}
+	if err := l.Record(Entry{GarminID: 42, Name: "Leeds Running"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(Entry{GarminID: 43, Name: "Leeds Cycling"}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := l.Get(42)
+	if !ok {
+		t.Fatal("reloaded ledger is missing 42")
+	}
+	if entry.Name != "Leeds Running" || entry.UploadedAt.IsZero() {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if n := len(l.Entries()); n != 2 {
+		t.Fatalf("len(Entries()) = %d", n)
+	}
+}
@@ -0,0 +1,100 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+// Activities joins the first activity of each database into one, for
+// recordings split by a watch crash or an accidental stop. Laps are
+// concatenated in time order and trackpoint distances are offset so they
+// carry on from the previous recording. Activities must be the same sport
+// and must not overlap.
+func Activities(dbs ...*tcx.Database) (*tcx.Database, error) {
+	if len(dbs) < 2 {
+		return nil, errors.New("Merge: need at least two activities")
+	}
+	var activities []tcx.Activity
+	for i, db := range dbs {
+		if len(db.Activities) == 0 {
+			return nil, fmt.Errorf("Merge: file %d has no activities", i+1)
+		}
+		activities = append(activities, db.Activities[0])
+	}
+	sort.SliceStable(activities, func(i, j int) bool {
+		return Summarise(activities[i]).Start.Before(Summarise(activities[j]).Start)
+	})
+
+	merged := tcx.Activity{Sport: activities[0].Sport, Creator: activities[0].Creator}
+	var notes []string
+	var offset float64
+	var previous Summary
+	for i, activity := range activities {
+		summary := Summarise(activity)
+		if i > 0 {
+			if activity.Sport != merged.Sport {
+				return nil, fmt.Errorf("Merge: cannot merge %s with %s", activity.Sport, merged.Sport)
+			}
+			if summary.Start.Before(previous.End) {
+				return nil, fmt.Errorf("Merge: activities starting %v and %v overlap", previous.Start, summary.Start)
+			}
+		}
+		for _, lap := range activity.Laps {
+			track := make([]tcx.Trackpoint, len(lap.Track))
+			for k, tp := range lap.Track {
+				if tp.DistanceMeters != nil {
+					d := *tp.DistanceMeters + offset
+					tp.DistanceMeters = &d
+				}
+				track[k] = tp
+			}
+			lap.Track = track
+			merged.Laps = append(merged.Laps, lap)
+		}
+		if activity.Notes != "" {
+			notes = append(notes, activity.Notes)
+		}
+		offset += summary.Distance
+		previous = summary
+	}
+	merged.Notes = strings.Join(notes, "\n")
+	merged.ID = Summarise(merged).Start.UTC().Format("2006-01-02T15:04:05.000Z")
+	return &tcx.Database{Activities: []tcx.Activity{merged}}, nil
+}
+
+// Summary totals an activity's laps. Elapsed includes pauses and gaps
+// between laps; TimerTime does not.
+type Summary struct {
+	Start     time.Time
+	End       time.Time
+	Elapsed   time.Duration
+	TimerTime time.Duration
+	Distance  float64
+	Laps      int
+}
+
+// Summarise recomputes an activity's totals from its laps and trackpoints.
+func Summarise(activity tcx.Activity) Summary {
+	s := Summary{Laps: len(activity.Laps)}
+	for _, lap := range activity.Laps {
+		end := lap.StartTime.Add(time.Duration(lap.TotalTimeSeconds * float64(time.Second)))
+		if n := len(lap.Track); n > 0 && lap.Track[n-1].Time.After(end) {
+			end = lap.Track[n-1].Time
+		}
+		if s.Start.IsZero() || lap.StartTime.Before(s.Start) {
+			s.Start = lap.StartTime
+		}
+		if end.After(s.End) {
+			s.End = end
+		}
+		s.TimerTime += time.Duration(lap.TotalTimeSeconds * float64(time.Second))
+		s.Distance += lap.DistanceMeters
+	}
+	s.Elapsed = s.End.Sub(s.Start)
+	return s
+}
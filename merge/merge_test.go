@@ -0,0 +1,65 @@
+package merge
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+var start = time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)
+
+// recording returns a one lap run starting offset after start, with a point
+// every minute for minutes minutes at 200m per minute.
+func recording(offset time.Duration, minutes int, sport string) *tcx.Database {
+	lap := tcx.Lap{
+		StartTime:        start.Add(offset),
+		TotalTimeSeconds: float64(minutes * 60),
+		DistanceMeters:   float64(minutes * 200),
+	}
+	for i := 0; i <= minutes; i++ {
+		d := float64(i * 200)
+		lap.Track = append(lap.Track, tcx.Trackpoint{Time: lap.StartTime.Add(time.Duration(i) * time.Minute), DistanceMeters: &d})
+	}
+	return &tcx.Database{Activities: []tcx.Activity{{Sport: sport, Laps: []tcx.Lap{lap}, Notes: sport + " part"}}}
+}
+
+func TestActivities(t *testing.T) {
+	second := recording(25*time.Minute, 10, "Running")
+	first := recording(0, 20, "Running")
+	db, err := Activities(second, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activity := db.Activities[0]
+	if activity.ID != "2017-12-30T09:15:00.000Z" || len(activity.Laps) != 2 {
+		t.Fatalf("activity = %s with %d laps", activity.ID, len(activity.Laps))
+	}
+	points := activity.Trackpoints()
+	if last := *points[len(points)-1].DistanceMeters; last != 6000 {
+		t.Fatalf("final distance = %v", last)
+	}
+	if *first.Activities[0].Laps[0].Track[1].DistanceMeters != 200 || *second.Activities[0].Laps[0].Track[1].DistanceMeters != 200 {
+		t.Fatal("inputs were modified")
+	}
+	if strings.Count(activity.Notes, "part") != 2 {
+		t.Fatalf("notes = %q", activity.Notes)
+	}
+	summary := Summarise(activity)
+	if summary.Elapsed != 35*time.Minute || summary.TimerTime != 30*time.Minute || summary.Distance != 6000 || summary.Laps != 2 {
+		t.Fatalf("summary = %+v", summary)
+	}
+}
+
+func TestActivitiesRejected(t *testing.T) {
+	if _, err := Activities(recording(0, 20, "Running"), recording(10*time.Minute, 20, "Running")); err == nil {
+		t.Fatal("expected overlapping activities to be rejected")
+	}
+	if _, err := Activities(recording(0, 20, "Running"), recording(30*time.Minute, 20, "Biking")); err == nil {
+		t.Fatal("expected different sports to be rejected")
+	}
+	if _, err := Activities(recording(0, 20, "Running")); err == nil {
+		t.Fatal("expected a single activity to be rejected")
+	}
+}
@@ -0,0 +1,187 @@
+package merge
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+// SensorOptions control Sensors.
+type SensorOptions struct {
+	// MaxGap is how far a secondary sample may be from a trackpoint and
+	// still be used. Defaults to 10 seconds.
+	MaxGap time.Duration
+	// Overwrite replaces values the primary already has; by default only
+	// missing values are filled in.
+	Overwrite bool
+}
+
+type sample struct {
+	time  time.Time
+	value float64
+}
+
+type series []sample
+
+// Sensors copies heart rate, cadence and power from a secondary recording,
+// such as a watch, into the trackpoints of a primary one, such as a bike
+// computer, interpolating between secondary samples by time. Lap heart rate
+// and power summaries are recomputed. It returns the combined database and
+// the number of trackpoints changed; the inputs are not modified.
+func Sensors(primary, secondary *tcx.Database, options SensorOptions) (*tcx.Database, int, error) {
+	if len(primary.Activities) == 0 || len(secondary.Activities) == 0 {
+		return nil, 0, errors.New("Merge sensors: no activity")
+	}
+	if options.MaxGap == 0 {
+		options.MaxGap = 10 * time.Second
+	}
+	var heartRate, cadence, power series
+	for _, tp := range secondary.Activities[0].Trackpoints() {
+		if tp.HeartRateBpm != nil {
+			heartRate = append(heartRate, sample{tp.Time, float64(tp.HeartRateBpm.Value)})
+		}
+		if c, ok := trackpointCadence(tp); ok {
+			cadence = append(cadence, sample{tp.Time, float64(c)})
+		}
+		if watts, ok := tp.Power(); ok {
+			power = append(power, sample{tp.Time, float64(watts)})
+		}
+	}
+	for _, s := range []series{heartRate, cadence, power} {
+		sort.SliceStable(s, func(i, j int) bool { return s[i].time.Before(s[j].time) })
+	}
+
+	activity := primary.Activities[0]
+	running := activity.Sport == "Running"
+	laps := make([]tcx.Lap, len(activity.Laps))
+	changed := 0
+	for i, lap := range activity.Laps {
+		track := make([]tcx.Trackpoint, len(lap.Track))
+		lapChanged := false
+		for k, tp := range lap.Track {
+			updated := false
+			if v, ok := heartRate.at(tp.Time, options.MaxGap); ok && (tp.HeartRateBpm == nil || options.Overwrite) {
+				tp.HeartRateBpm = &tcx.HeartRate{Value: v}
+				updated = true
+			}
+			if v, ok := cadence.at(tp.Time, options.MaxGap); ok {
+				if _, has := trackpointCadence(tp); !has || options.Overwrite {
+					if running {
+						tpx(&tp).RunCadence = &v
+						tp.Cadence = nil
+					} else {
+						tp.Cadence = &v
+					}
+					updated = true
+				}
+			}
+			if v, ok := power.at(tp.Time, options.MaxGap); ok {
+				if _, has := tp.Power(); !has || options.Overwrite {
+					tpx(&tp).Watts = &v
+					updated = true
+				}
+			}
+			if updated {
+				changed++
+				lapChanged = true
+			}
+			track[k] = tp
+		}
+		lap.Track = track
+		if lapChanged {
+			summariseSensors(&lap, options.Overwrite)
+		}
+		laps[i] = lap
+	}
+	activity.Laps = laps
+	combined := *primary
+	combined.Activities = append([]tcx.Activity{activity}, primary.Activities[1:]...)
+	return &combined, changed, nil
+}
+
+// at returns the value at t, interpolated between the samples either side
+// if both are within maxGap, otherwise the nearest sample within maxGap.
+func (s series) at(t time.Time, maxGap time.Duration) (int, bool) {
+	i := sort.Search(len(s), func(i int) bool { return !s[i].time.Before(t) })
+	var before, after *sample
+	if i < len(s) && s[i].time.Sub(t) <= maxGap {
+		after = &s[i]
+	}
+	if i > 0 && t.Sub(s[i-1].time) <= maxGap {
+		before = &s[i-1]
+	}
+	switch {
+	case after != nil && after.time.Equal(t):
+		return int(after.value), true
+	case before != nil && after != nil:
+		fraction := t.Sub(before.time).Seconds() / after.time.Sub(before.time).Seconds()
+		return int(math.Round(before.value + fraction*(after.value-before.value))), true
+	case before != nil:
+		return int(before.value), true
+	case after != nil:
+		return int(after.value), true
+	}
+	return 0, false
+}
+
+func trackpointCadence(tp tcx.Trackpoint) (int, bool) {
+	if tp.Cadence != nil {
+		return *tp.Cadence, true
+	}
+	if tp.Extensions != nil && tp.Extensions.TPX != nil && tp.Extensions.TPX.RunCadence != nil {
+		return *tp.Extensions.TPX.RunCadence, true
+	}
+	return 0, false
+}
+
+// tpx returns the trackpoint's TPX extension, replacing any shared one with
+// a copy so the input database is left alone.
+func tpx(tp *tcx.Trackpoint) *tcx.TPX {
+	ext := &tcx.TPX{}
+	if tp.Extensions != nil && tp.Extensions.TPX != nil {
+		*ext = *tp.Extensions.TPX
+	}
+	tp.Extensions = &tcx.TrackpointExtensions{TPX: ext}
+	return ext
+}
+
+// summariseSensors sets a lap's heart rate and power averages from its
+// trackpoints where missing, or always if overwrite is set.
+func summariseSensors(lap *tcx.Lap, overwrite bool) {
+	var hrSum, hrCount, hrMax, wSum, wCount, wMax int
+	for _, tp := range lap.Track {
+		if tp.HeartRateBpm != nil {
+			hrSum += tp.HeartRateBpm.Value
+			hrCount++
+			if tp.HeartRateBpm.Value > hrMax {
+				hrMax = tp.HeartRateBpm.Value
+			}
+		}
+		if watts, ok := tp.Power(); ok {
+			wSum += watts
+			wCount++
+			if watts > wMax {
+				wMax = watts
+			}
+		}
+	}
+	if hrCount > 0 && (lap.AverageHeartRateBpm == nil || overwrite) {
+		lap.AverageHeartRateBpm = &tcx.HeartRate{Value: int(math.Round(float64(hrSum) / float64(hrCount)))}
+		lap.MaximumHeartRateBpm = &tcx.HeartRate{Value: hrMax}
+	}
+	if wCount == 0 {
+		return
+	}
+	lx := &tcx.LX{}
+	if lap.Extensions != nil && lap.Extensions.LX != nil {
+		*lx = *lap.Extensions.LX
+	}
+	if lx.AvgWatts == nil || overwrite {
+		avg := int(math.Round(float64(wSum) / float64(wCount)))
+		lx.AvgWatts, lx.MaxWatts = &avg, &wMax
+	}
+	lap.Extensions = &tcx.LapExtensions{LX: lx}
+}
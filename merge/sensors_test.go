@@ -0,0 +1,69 @@
+package merge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestSensors(t *testing.T) {
+	// The bike computer records GPS and power every second, the watch heart
+	// rate every 4 seconds, starting a second later.
+	var bike, watch tcx.Lap
+	for i := 0; i < 10; i++ {
+		bike.Track = append(bike.Track, tcx.Trackpoint{
+			Time:       start.Add(time.Duration(i) * time.Second),
+			Position:   &tcx.Position{LatitudeDegrees: 53.8, LongitudeDegrees: -1.55},
+			Extensions: &tcx.TrackpointExtensions{TPX: &tcx.TPX{Watts: intPtr(200)}},
+		})
+	}
+	for i := 1; i < 10; i += 4 {
+		watch.Track = append(watch.Track, tcx.Trackpoint{
+			Time:         start.Add(time.Duration(i) * time.Second),
+			HeartRateBpm: &tcx.HeartRate{Value: 100 + i*10},
+			Cadence:      intPtr(90),
+			Extensions:   &tcx.TrackpointExtensions{TPX: &tcx.TPX{Watts: intPtr(999)}},
+		})
+	}
+	primary := &tcx.Database{Activities: []tcx.Activity{{Sport: "Biking", Laps: []tcx.Lap{bike}}}}
+	secondary := &tcx.Database{Activities: []tcx.Activity{{Sport: "Biking", Laps: []tcx.Lap{watch}}}}
+
+	combined, changed, err := Sensors(primary, secondary, SensorOptions{MaxGap: 4 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 10 {
+		t.Fatalf("changed %d trackpoints", changed)
+	}
+	points := combined.Activities[0].Trackpoints()
+	if hr := points[3].HeartRateBpm.Value; hr != 130 {
+		t.Fatalf("interpolated heart rate = %d, expected 130", hr)
+	}
+	if hr := points[0].HeartRateBpm.Value; hr != 110 {
+		t.Fatalf("nearest heart rate = %d, expected 110", hr)
+	}
+	if watts, _ := points[3].Power(); watts != 200 || *points[3].Cadence != 90 {
+		t.Fatalf("power %d, cadence %v", watts, points[3].Cadence)
+	}
+	lap := combined.Activities[0].Laps[0]
+	if lap.AverageHeartRateBpm == nil || lap.MaximumHeartRateBpm.Value != 190 {
+		t.Fatalf("lap heart rate = %+v %+v", lap.AverageHeartRateBpm, lap.MaximumHeartRateBpm)
+	}
+	if primary.Activities[0].Laps[0].Track[3].HeartRateBpm != nil {
+		t.Fatal("primary was modified")
+	}
+
+	combined, _, err = Sensors(primary, secondary, SensorOptions{Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watts, _ := combined.Activities[0].Trackpoints()[3].Power(); watts != 999 {
+		t.Fatalf("overwritten power = %d", watts)
+	}
+	if primary.Activities[0].Laps[0].Track[3].Extensions.TPX.Watts == nil || *primary.Activities[0].Laps[0].Track[3].Extensions.TPX.Watts != 200 {
+		t.Fatal("primary extensions were modified")
+	}
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"strings"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/strava"
+)
+
+// DefaultSportTypes maps Garmin activity type keys to Strava sport types.
+var DefaultSportTypes = map[string]string{
+	"running":                         "Run",
+	"street_running":                  "Run",
+	"track_running":                   "Run",
+	"treadmill_running":               "Run",
+	"indoor_running":                  "Run",
+	"trail_running":                   "TrailRun",
+	"virtual_run":                     "VirtualRun",
+	"cycling":                         "Ride",
+	"road_biking":                     "Ride",
+	"indoor_cycling":                  "Ride",
+	"mountain_biking":                 "MountainBikeRide",
+	"gravel_cycling":                  "GravelRide",
+	"virtual_ride":                    "VirtualRide",
+	"e_bike_fitness":                  "EBikeRide",
+	"e_bike_mountain":                 "EMountainBikeRide",
+	"walking":                         "Walk",
+	"casual_walking":                  "Walk",
+	"speed_walking":                   "Walk",
+	"hiking":                          "Hike",
+	"lap_swimming":                    "Swim",
+	"open_water_swimming":             "Swim",
+	"strength_training":               "WeightTraining",
+	"hiit":                            "HighIntensityIntervalTraining",
+	"yoga":                            "Yoga",
+	"pilates":                         "Pilates",
+	"elliptical":                      "Elliptical",
+	"stair_climbing":                  "StairStepper",
+	"rowing":                          "Rowing",
+	"indoor_rowing":                   "Rowing",
+	"resort_skiing_snowboarding":      "AlpineSki",
+	"backcountry_skiing_snowboarding": "BackcountrySki",
+	"cross_country_skiing":            "NordicSki",
+	"skate_skiing":                    "NordicSki",
+	"stand_up_paddleboarding":         "StandUpPaddling",
+	"kayaking":                        "Kayaking",
+	"rock_climbing":                   "RockClimbing",
+	"indoor_climbing":                 "RockClimbing",
+	"surfing":                         "Surfing",
+	"golf":                            "Golf",
+}
+
+// indoorTypes are the Garmin activity types recorded on a trainer or
+// treadmill.
+var indoorTypes = map[string]bool{
+	"indoor_cycling":    true,
+	"virtual_ride":      true,
+	"treadmill_running": true,
+	"indoor_running":    true,
+	"virtual_run":       true,
+	"indoor_rowing":     true,
+}
+
+// commuteEventType is the Garmin event type used for commutes.
+const commuteEventType = "transportation"
+
+// Mapper converts Garmin activity metadata into a Strava activity update.
+type Mapper struct {
+	// SportTypes overrides DefaultSportTypes, keyed by Garmin type key.
+	SportTypes map[string]string
+	// Gear maps Garmin gear UUIDs or names to Strava gear IDs.
+	Gear map[string]string
+	// Private makes every activity visible only to the athlete.
+	Private      bool
+	HideFromHome bool
+}
+
+// SportType returns the Strava sport type for a Garmin activity type key, or
+// "" if there is no mapping.
+func (m Mapper) SportType(garminType string) string {
+	garminType = strings.ToLower(garminType)
+	if sportType, ok := lookup(m.SportTypes, garminType); ok {
+		return sportType
+	}
+	return DefaultSportTypes[garminType]
+}
+
+// GearID returns the Strava gear ID for the first Garmin gear with a mapping.
+func (m Mapper) GearID(gear []gc.Gear) string {
+	for _, g := range gear {
+		for _, key := range []string{g.UUID, g.DisplayName, g.MakeModel} {
+			if gearID, ok := lookup(m.Gear, key); ok && key != "" {
+				return gearID
+			}
+		}
+	}
+	return ""
+}
+
+// Update builds the Strava update for a Garmin activity and its gear.
+func (m Mapper) Update(activity gc.Activity, gear []gc.Gear) strava.ActivityUpdate {
+	update := strava.ActivityUpdate{
+		SportType:   m.SportType(activity.Type),
+		Description: activity.Description,
+		GearID:      m.GearID(gear),
+	}
+	if activity.EventType == commuteEventType {
+		update.Commute = boolPtr(true)
+	}
+	if indoorTypes[strings.ToLower(activity.Type)] {
+		update.Trainer = boolPtr(true)
+	}
+	if m.HideFromHome {
+		update.HideFromHome = boolPtr(true)
+	}
+	if m.Private {
+		update.Private = boolPtr(true)
+	}
+	return update
+}
+
+// lookup finds key case-insensitively, since config loaders lowercase map
+// keys.
+func lookup(table map[string]string, key string) (string, bool) {
+	if value, ok := table[key]; ok {
+		return value, true
+	}
+	for k, value := range table {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
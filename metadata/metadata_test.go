@@ -0,0 +1,35 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+func TestUpdate(t *testing.T) {
+	m := Mapper{
+		SportTypes: map[string]string{"cycling": "GravelRide"},
+		Gear:       map[string]string{"commuter bike": "b1234"},
+	}
+	activity := gc.Activity{Type: "cycling", EventType: "transportation", Description: "To work"}
+	update := m.Update(activity, []gc.Gear{{UUID: "abc", DisplayName: "Commuter Bike"}})
+	if update.SportType != "GravelRide" || update.GearID != "b1234" || update.Description != "To work" {
+		t.Fatalf("update = %+v", update)
+	}
+	if update.Commute == nil || !*update.Commute || update.Trainer != nil {
+		t.Fatalf("update = %+v", update)
+	}
+}
+
+func TestIndoorDefaults(t *testing.T) {
+	update := Mapper{}.Update(gc.Activity{Type: "treadmill_running"}, nil)
+	if update.SportType != "Run" || update.Trainer == nil || !*update.Trainer {
+		t.Fatalf("update = %+v", update)
+	}
+	if update.Commute != nil || update.GearID != "" {
+		t.Fatalf("update = %+v", update)
+	}
+	if !(Mapper{}).Update(gc.Activity{Type: "unknown"}, nil).IsEmpty() {
+		t.Fatal("expected empty update for unknown type")
+	}
+}
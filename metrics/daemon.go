@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/strava"
+)
+
+// Daemon holds the metrics and health of a long running sync, labelled by
+// profile.
+type Daemon struct {
+	Registry *Registry
+
+	activities     *Counter
+	exportSeconds  *Histogram
+	uploadSeconds  *Histogram
+	rateLimitUsage *Gauge
+	rateLimitLimit *Gauge
+	lastSuccess    *Gauge
+
+	mu       sync.Mutex
+	now      func() time.Time
+	started  map[int64]time.Time
+	profiles map[string]*Health
+}
+
+// Health is the sync state of one profile, as reported by /healthz.
+type Health struct {
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastAttempt time.Time  `json:"lastAttempt"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+func NewDaemon() *Daemon {
+	r := NewRegistry()
+	d := &Daemon{
+		Registry:       r,
+		activities:     r.Counter("gravasync_activities_total", "Activities by outcome: listed, skipped, uploaded or failed.", "profile", "outcome"),
+		exportSeconds:  r.Histogram("gravasync_export_duration_seconds", "Time to export and prepare an activity from Garmin Connect.", DefaultBuckets, "profile"),
+		uploadSeconds:  r.Histogram("gravasync_upload_duration_seconds", "Time to upload an activity and for Strava to process it.", DefaultBuckets, "profile"),
+		rateLimitUsage: r.Gauge("gravasync_strava_rate_limit_usage", "Strava API requests used in the current window.", "profile", "window"),
+		rateLimitLimit: r.Gauge("gravasync_strava_rate_limit", "Strava API requests allowed per window.", "profile", "window"),
+		lastSuccess:    r.Gauge("gravasync_last_success_timestamp_seconds", "Unix time of the last sync that completed without error.", "profile"),
+		now:            time.Now,
+		started:        map[int64]time.Time{},
+		profiles:       map[string]*Health{},
+	}
+	return d
+}
+
+// Events returns a pipeline.Options.Events function counting a profile's
+// activities and timing their exports and uploads.
+func (d *Daemon) Events(profile string) func(pipeline.Event) {
+	return func(event pipeline.Event) {
+		now := d.now()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		var id int64
+		if event.Activity != nil {
+			id = event.Activity.ID
+		}
+		switch event.Type {
+		case pipeline.Listed:
+			d.activities.Inc(profile, "listed")
+		case pipeline.Skipped:
+			d.activities.Inc(profile, "skipped")
+		case pipeline.Exporting:
+			d.started[id] = now
+		case pipeline.Uploading:
+			if start, ok := d.started[id]; ok {
+				d.exportSeconds.Observe(now.Sub(start).Seconds(), profile)
+			}
+			d.started[id] = now
+		case pipeline.Done:
+			if start, ok := d.started[id]; ok {
+				d.uploadSeconds.Observe(now.Sub(start).Seconds(), profile)
+			}
+			delete(d.started, id)
+			d.activities.Inc(profile, "uploaded")
+		case pipeline.Failed:
+			delete(d.started, id)
+			d.activities.Inc(profile, "failed")
+		}
+	}
+}
+
+// RateLimit records a profile's Strava API usage.
+func (d *Daemon) RateLimit(profile string, limit strava.RateLimit) {
+	d.rateLimitUsage.Set(float64(limit.ShortUsage), profile, "15m")
+	d.rateLimitUsage.Set(float64(limit.LongUsage), profile, "day")
+	d.rateLimitLimit.Set(float64(limit.ShortLimit), profile, "15m")
+	d.rateLimitLimit.Set(float64(limit.LongLimit), profile, "day")
+}
+
+// SyncFinished records the outcome of a profile's pipeline.
+func (d *Daemon) SyncFinished(profile string, err error) {
+	now := d.now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	health := d.profiles[profile]
+	if health == nil {
+		health = &Health{}
+		d.profiles[profile] = health
+	}
+	health.LastAttempt = now
+	health.LastError = ""
+	if err != nil {
+		health.LastError = err.Error()
+		return
+	}
+	health.LastSuccess = &now
+	d.lastSuccess.Set(float64(now.Unix()), profile)
+}
+
+// Healthz reports each profile's last successful sync as JSON, with status
+// 503 if any profile's latest sync failed.
+func (d *Daemon) Healthz(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	status := "ok"
+	profiles := map[string]Health{}
+	for name, health := range d.profiles {
+		profiles[name] = *health
+		if health.LastError != "" {
+			status = "failing"
+		}
+	}
+	d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(struct {
+		Status   string            `json:"status"`
+		Profiles map[string]Health `json:"profiles"`
+	}{status, profiles})
+}
+
+// Handler serves /metrics and /healthz.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", d.Registry)
+	mux.HandleFunc("/healthz", d.Healthz)
+	return mux
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/strava"
+)
+
+func TestDaemon(t *testing.T) {
+	now := time.Unix(1514626000, 0)
+	d := NewDaemon()
+	d.now = func() time.Time { return now }
+	events := d.Events("default")
+	activity := &gc.Activity{ID: 1}
+	events(pipeline.Event{Type: pipeline.Listed, Activity: activity})
+	events(pipeline.Event{Type: pipeline.Exporting, Activity: activity})
+	now = now.Add(2 * time.Second)
+	events(pipeline.Event{Type: pipeline.Uploading, Activity: activity})
+	now = now.Add(20 * time.Second)
+	events(pipeline.Event{Type: pipeline.Done, Activity: activity})
+	events(pipeline.Event{Type: pipeline.Listed, Activity: &gc.Activity{ID: 2}})
+	events(pipeline.Event{Type: pipeline.Skipped, Activity: &gc.Activity{ID: 2}})
+	d.RateLimit("default", strava.RateLimit{ShortLimit: 600, ShortUsage: 12, LongLimit: 30000, LongUsage: 340})
+	d.SyncFinished("default", nil)
+
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+	body := get(t, server.URL+"/metrics", http.StatusOK)
+	for _, line := range []string{
+		`gravasync_activities_total{profile="default",outcome="listed"} 2`,
+		`gravasync_activities_total{profile="default",outcome="uploaded"} 1`,
+		`gravasync_export_duration_seconds_sum{profile="default"} 2`,
+		`gravasync_upload_duration_seconds_bucket{profile="default",le="30"} 1`,
+		`gravasync_strava_rate_limit_usage{profile="default",window="day"} 340`,
+		`gravasync_last_success_timestamp_seconds{profile="default"} 1514626022`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if body := get(t, server.URL+"/healthz", http.StatusOK); !strings.Contains(body, `"status":"ok"`) || !strings.Contains(body, `"lastSuccess":"2017-12-30T`) {
+		t.Fatalf("healthz = %s", body)
+	}
+
+	d.SyncFinished("other", errors.New("Login: unexpected status code: 403"))
+	if body := get(t, server.URL+"/healthz", http.StatusServiceUnavailable); !strings.Contains(body, "403") {
+		t.Fatalf("healthz = %s", body)
+	}
+}
+
+func get(t *testing.T, url string, status int) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%s: status %d, want %d", url, resp.StatusCode, status)
+	}
+	return string(b)
+}
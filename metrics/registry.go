@@ -0,0 +1,195 @@
+// Package metrics exposes gravasync's progress to Prometheus. It writes the
+// text exposition format itself, which is all a handful of counters, gauges
+// and histograms need.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit export and upload latencies, in seconds.
+var DefaultBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Registry holds metric families and writes them in registration order.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// Counter is a family of monotonically increasing values.
+type Counter struct {
+	registry *Registry
+	family   *family
+}
+
+// Gauge is a family of values that can go up and down.
+type Gauge struct {
+	registry *Registry
+	family   *family
+}
+
+// Histogram is a family of observation distributions.
+type Histogram struct {
+	registry *Registry
+	family   *family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := &family{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r, r.register(name, help, "counter", nil, labels)}
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r, r.register(name, help, "gauge", nil, labels)}
+}
+
+// Histogram registers a histogram with the given upper bucket bounds, in
+// increasing order.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r, r.register(name, help, "histogram", buckets, labels)}
+}
+
+// get returns the series for labelValues, creating it; r.mu must be held.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	c.family.get(labelValues).value += delta
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.registry.mu.Lock()
+	defer g.registry.mu.Unlock()
+	g.family.get(labelValues).value = value
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.registry.mu.Lock()
+	defer h.registry.mu.Unlock()
+	s := h.family.get(labelValues)
+	for i, bound := range h.family.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Write writes every family in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := bufio.NewWriter(w)
+	for _, f := range r.families {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(out, "%s%s %s\n", f.name, labels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+				continue
+			}
+			for i, bound := range f.buckets {
+				fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, labels(f.labels, s.labelValues, "le", formatValue(bound)), s.counts[i])
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, labels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+			fmt.Fprintf(out, "%s_sum%s %s\n", f.name, labels(f.labels, s.labelValues, "", ""), formatValue(s.sum))
+			fmt.Fprintf(out, "%s_count%s %d\n", f.name, labels(f.labels, s.labelValues, "", ""), s.count)
+		}
+	}
+	return out.Flush()
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+func labels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
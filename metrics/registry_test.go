@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("uploads_total", "Uploads.", "profile")
+	g := r.Gauge("temperature", "Line one\nline two.")
+	h := r.Histogram("latency_seconds", "Latency.", []float64{1, 5})
+	c.Inc("a\"b")
+	c.Add(2, "default")
+	g.Set(-1.5)
+	h.Observe(0.5)
+	h.Observe(3)
+	h.Observe(7)
+	var b bytes.Buffer
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP uploads_total Uploads.
+# TYPE uploads_total counter
+uploads_total{profile="a\"b"} 1
+uploads_total{profile="default"} 2
+# HELP temperature Line one\nline two.
+# TYPE temperature gauge
+temperature -1.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="5"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 10.5
+latency_seconds_count 3
+`
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
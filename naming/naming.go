@@ -0,0 +1,176 @@
+package naming
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+const metresPerMile = 1609.344
+
+// Config holds text/template sources for upload names and descriptions.
+// Types overrides them per Garmin activity type key.
+type Config struct {
+	Name        string
+	Description string
+	Types       map[string]TypeConfig
+}
+
+// TypeConfig holds the templates for one activity type. Empty fields fall
+// back to the global templates.
+type TypeConfig struct {
+	Name        string
+	Description string
+}
+
+// Data is passed to the templates. All gc.Activity fields are available, plus
+// Start, the start time in the activity's own zone, or the local zone if that
+// is not known.
+type Data struct {
+	gc.Activity
+	Start time.Time
+}
+
+// Renderer renders upload names and descriptions for activities.
+type Renderer struct {
+	name        *template.Template
+	description *template.Template
+	types       map[string]typeTemplates
+	location    *time.Location
+}
+
+type typeTemplates struct {
+	name        *template.Template
+	description *template.Template
+}
+
+// Funcs are the helper functions available to templates.
+var Funcs = template.FuncMap{
+	"km":        func(metres float64) string { return fmt.Sprintf("%.2f", metres/1000) },
+	"mi":        func(metres float64) string { return fmt.Sprintf("%.2f", metres/metresPerMile) },
+	"pace":      func(d time.Duration, metres float64) string { return pace(d, metres/1000) + "/km" },
+	"paceMi":    func(d time.Duration, metres float64) string { return pace(d, metres/metresPerMile) + "/mi" },
+	"hms":       hms,
+	"weekday":   func(t time.Time) string { return t.Weekday().String() },
+	"timeOfDay": timeOfDay,
+	"date":      func(layout string, t time.Time) string { return t.Format(layout) },
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"title":     title,
+}
+
+// New parses the templates in config. Times are shown in the activity's own
+// zone, or the local zone if that is not known.
+func New(config Config) (*Renderer, error) {
+	r := &Renderer{types: map[string]typeTemplates{}, location: time.Local}
+	var err error
+	if r.name, err = parse("name", config.Name); err != nil {
+		return nil, err
+	}
+	if r.description, err = parse("description", config.Description); err != nil {
+		return nil, err
+	}
+	for activityType, typeConfig := range config.Types {
+		var t typeTemplates
+		if t.name, err = parse(activityType+".name", typeConfig.Name); err != nil {
+			return nil, err
+		}
+		if t.description, err = parse(activityType+".description", typeConfig.Description); err != nil {
+			return nil, err
+		}
+		r.types[strings.ToLower(activityType)] = t
+	}
+	return r, nil
+}
+
+func parse(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	t, err := template.New(name).Funcs(Funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Template %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// Name returns the upload name for an activity, or its Garmin name if no
+// template applies.
+func (r *Renderer) Name(activity gc.Activity) (string, error) {
+	t := r.name
+	if typeTemplates, ok := r.types[strings.ToLower(activity.Type)]; ok && typeTemplates.name != nil {
+		t = typeTemplates.name
+	}
+	if t == nil {
+		return activity.Name, nil
+	}
+	name, err := r.execute(t, activity)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(name), nil
+}
+
+// Description returns the description for an activity, and false if no
+// template applies.
+func (r *Renderer) Description(activity gc.Activity) (string, bool, error) {
+	t := r.description
+	if typeTemplates, ok := r.types[strings.ToLower(activity.Type)]; ok && typeTemplates.description != nil {
+		t = typeTemplates.description
+	}
+	if t == nil {
+		return "", false, nil
+	}
+	description, err := r.execute(t, activity)
+	return description, err == nil, err
+}
+
+func (r *Renderer) execute(t *template.Template, activity gc.Activity) (string, error) {
+	var b bytes.Buffer
+	data := Data{Activity: activity, Start: activity.LocalStart(r.location)}
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("Template %s: %v", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+func pace(d time.Duration, units float64) string {
+	if units <= 0 {
+		return "-"
+	}
+	secs := int(d.Seconds()/units + 0.5)
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+func hms(d time.Duration) string {
+	secs := int(d.Round(time.Second).Seconds())
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%d:%02d", secs/60, secs%60)
+}
+
+func timeOfDay(t time.Time) string {
+	switch hour := t.Hour(); {
+	case hour >= 5 && hour < 12:
+		return "Morning"
+	case hour >= 12 && hour < 17:
+		return "Afternoon"
+	case hour >= 17 && hour < 21:
+		return "Evening"
+	}
+	return "Night"
+}
+
+// title turns a Garmin type key such as "trail_running" into "Trail Running".
+func title(s string) string {
+	words := strings.Fields(strings.Replace(s, "_", " ", -1))
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
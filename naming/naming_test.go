@@ -0,0 +1,88 @@
+package naming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+var activity = gc.Activity{
+	Name:      "Leeds Running",
+	Type:      "running",
+	Device:    "Forerunner 235",
+	Distance:  10000,
+	Duration:  50*time.Minute + 30*time.Second,
+	StartTime: time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC),
+}
+
+func TestName(t *testing.T) {
+	r, err := New(Config{
+		Name: "{{timeOfDay .Start}} {{title .Type}}",
+		Types: map[string]TypeConfig{
+			"Running": {Name: "{{km .Distance}}km @ {{pace .Duration .Distance}} ({{hms .Duration}})"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.location = time.UTC
+	name, err := r.Name(activity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "10.00km @ 5:03/km (50:30)" {
+		t.Fatalf("name = %q", name)
+	}
+	cycling := activity
+	cycling.Type = "road_biking"
+	if name, _ = r.Name(cycling); name != "Morning Road Biking" {
+		t.Fatalf("name = %q", name)
+	}
+	if _, ok, _ := r.Description(activity); ok {
+		t.Fatal("expected no description template")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	r, err := New(Config{Description: "{{weekday .Start}} on {{.Device}}, {{mi .Distance}}mi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.location = time.UTC
+	if name, _ := r.Name(activity); name != "Leeds Running" {
+		t.Fatalf("name = %q", name)
+	}
+	description, ok, err := r.Description(activity)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if description != "Saturday on Forerunner 235, 6.21mi" {
+		t.Fatalf("description = %q", description)
+	}
+}
+
+func TestBadTemplate(t *testing.T) {
+	if _, err := New(Config{Name: "{{.Nope"}); err == nil {
+		t.Fatal("expected parse error")
+	}
+	r, _ := New(Config{Name: "{{.Nope}}"})
+	if _, err := r.Name(activity); err == nil {
+		t.Fatal("expected execute error")
+	}
+}
+
+func TestActivityTimeZone(t *testing.T) {
+	r, err := New(Config{Name: "{{timeOfDay .Start}} run in {{.LocationName}} at {{date \"15:04\" .Start}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.location = time.UTC
+	abroad := activity
+	abroad.LocationName = "New York"
+	abroad.TimeZone = "America/New_York"
+	abroad.StartTime = time.Date(2017, 12, 30, 23, 30, 0, 0, time.UTC)
+	if name, err := r.Name(abroad); err != nil || name != "Evening run in New York at 18:30" {
+		t.Fatalf("name = %q, %v", name, err)
+	}
+}
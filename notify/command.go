@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command runs a local program with the message body on stdin. The subject,
+// counts and the whole report as JSON are passed in GRAVASYNC_* environment
+// variables.
+type command struct {
+	args    []string
+	timeout time.Duration
+}
+
+func newCommand(config Config) (Notifier, error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("command is required")
+	}
+	return &command{args: config.Command, timeout: config.Timeout}, nil
+}
+
+func (c *command) Notify(report *Report, message Message) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.args[0], c.args[1:]...)
+	cmd.Stdin = strings.NewReader(message.Body)
+	cmd.Env = append(os.Environ(),
+		"GRAVASYNC_PROFILE="+report.Profile,
+		"GRAVASYNC_SUBJECT="+message.Subject,
+		"GRAVASYNC_UPLOADED="+strconv.Itoa(len(report.Uploaded)),
+		"GRAVASYNC_FAILED="+strconv.Itoa(len(report.Failed)),
+		"GRAVASYNC_ERROR="+report.Error,
+		"GRAVASYNC_REPORT="+string(data),
+	)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Run %s: %v: %s", c.args[0], err, bytes.TrimSpace(output.Bytes()))
+	}
+	return nil
+}
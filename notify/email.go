@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSMTPPort = 587
+
+// email sends the message over SMTP, using STARTTLS when the server offers
+// it. Username and password are only sent if set.
+type email struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+	// send is smtp.SendMail, replaced in tests.
+	send func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newEmail(config Config) (Notifier, error) {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("host, from and to are required")
+	}
+	port := config.Port
+	if port == 0 {
+		port = defaultSMTPPort
+	}
+	return &email{
+		addr:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		host:     config.Host,
+		username: config.Username,
+		password: config.Password,
+		from:     config.From,
+		to:       config.To,
+		send:     smtp.SendMail,
+	}, nil
+}
+
+func (e *email) Notify(report *Report, message Message) error {
+	var auth smtp.Auth
+	if e.username != "" {
+		auth = smtp.PlainAuth("", e.username, e.password, e.host)
+	}
+	if err := e.send(e.addr, auth, e.from, e.to, e.compose(message)); err != nil {
+		return fmt.Errorf("Send email via %s: %v", e.addr, err)
+	}
+	return nil
+}
+
+func (e *email) compose(message Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.Replace(message.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
@@ -0,0 +1,261 @@
+// Package notify reports the outcome of sync runs by webhook, email or a
+// local command.
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/icalder/gravasync/naming"
+	"github.com/icalder/gravasync/pipeline"
+	"github.com/icalder/gravasync/strava"
+)
+
+// Report summarises one run of a profile's sync for notifications.
+type Report struct {
+	Profile  string    `json:"profile"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Uploaded []Upload  `json:"uploaded"`
+	Failed   []Failure `json:"failed"`
+	// Error is set if the run stopped early, for example at a failed login.
+	Error string `json:"error,omitempty"`
+}
+
+// Upload is an activity uploaded to Strava.
+type Upload struct {
+	ActivityID       int64     `json:"activityId"`
+	Name             string    `json:"name"`
+	Type             string    `json:"type"`
+	StartTime        time.Time `json:"startTime"`
+	StravaActivityID int64     `json:"stravaActivityId"`
+	URL              string    `json:"url"`
+}
+
+// Failure is an activity that could not be uploaded. Retry says what happens
+// next, if it was added to the retry queue.
+type Failure struct {
+	ActivityID int64     `json:"activityId"`
+	Name       string    `json:"name"`
+	StartTime  time.Time `json:"startTime"`
+	Error      string    `json:"error"`
+	Retry      string    `json:"retry,omitempty"`
+}
+
+// NewReport starts a report for a run of the named profile.
+func NewReport(profile string) *Report {
+	return &Report{Profile: profile, Started: time.Now()}
+}
+
+// Event adds uploads and failures to the report. It is a pipeline.Options.Events
+// listener.
+func (r *Report) Event(event pipeline.Event) {
+	if event.Activity == nil {
+		return
+	}
+	switch event.Type {
+	case pipeline.Done:
+		r.Uploaded = append(r.Uploaded, Upload{
+			ActivityID:       event.Activity.ID,
+			Name:             event.Activity.Name,
+			Type:             event.Activity.Type,
+			StartTime:        event.Activity.StartTime,
+			StravaActivityID: event.Entry.StravaActivityID,
+			URL:              strava.ActivityURL(event.Entry.StravaActivityID),
+		})
+	case pipeline.Failed:
+		failure := Failure{ActivityID: event.Activity.ID, Name: event.Activity.Name, StartTime: event.Activity.StartTime}
+		if event.Err != nil {
+			failure.Error = event.Err.Error()
+		}
+		r.Failed = append(r.Failed, failure)
+	case pipeline.Queued:
+		if n := len(r.Failed); n > 0 && r.Failed[n-1].ActivityID == event.Activity.ID {
+			r.Failed[n-1].Retry = event.Message
+		}
+	}
+}
+
+// Finish records the end of the run and the error that stopped it, if any.
+func (r *Report) Finish(err error) {
+	r.Finished = time.Now()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// HasErrors reports whether anything failed.
+func (r *Report) HasErrors() bool {
+	return r.Error != "" || len(r.Failed) > 0
+}
+
+// Notifier sends a report somewhere.
+type Notifier interface {
+	Notify(report *Report, message Message) error
+}
+
+// Message is a report rendered with a notifier's templates.
+type Message struct {
+	Subject string
+	Body    string
+}
+
+// When a notifier fires.
+const (
+	// Always sends a report after every run.
+	Always = "always"
+	// Changes sends a report when something was uploaded or failed.
+	Changes = "changes"
+	// Errors sends a report only when something failed.
+	Errors = "errors"
+)
+
+// DefaultSubject and DefaultBody are used when a notifier has no templates.
+const DefaultSubject = `gravasync {{.Profile}}: {{len .Uploaded}} uploaded, {{len .Failed}} failed{{if .Error}}, stopped early{{end}}`
+const DefaultBody = `{{range .Uploaded}}Uploaded {{.Name}} ({{date "2006-01-02 15:04" .StartTime}}): {{.URL}}
+{{end}}{{range .Failed}}Failed {{.Name}} ({{date "2006-01-02 15:04" .StartTime}}): {{.Error}}{{if .Retry}}, {{.Retry}}{{end}}
+{{end}}{{if .Error}}Stopped: {{.Error}}
+{{end}}`
+
+// Config configures one notifier. Type is webhook, email or command; the
+// other fields used depend on the type. Subject and Body are text/templates
+// executed with the Report, using the same functions as upload names.
+type Config struct {
+	Type    string
+	On      string
+	Subject string
+	Body    string
+	Timeout time.Duration
+
+	// Webhook. Header values may hold credentials.
+	URL     string
+	Headers map[string]string
+
+	// Email
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+
+	// Command is the program and its arguments.
+	Command []string
+}
+
+const defaultTimeout = 30 * time.Second
+
+type entry struct {
+	name     string
+	on       string
+	subject  *template.Template
+	body     *template.Template
+	notifier Notifier
+}
+
+// Notifiers sends reports to each configured notifier.
+type Notifiers struct {
+	entries []entry
+}
+
+// New checks and sets up the notifiers, returning nil if there are none.
+func New(configs []Config) (*Notifiers, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+	n := &Notifiers{}
+	for i, config := range configs {
+		name := fmt.Sprintf("notify[%d] %s", i, config.Type)
+		e := entry{name: name, on: strings.ToLower(config.On)}
+		switch e.on {
+		case "":
+			e.on = Always
+		case Always, Changes, Errors:
+		default:
+			return nil, fmt.Errorf("Notifier %s: on must be always, changes or errors, not %q", name, config.On)
+		}
+		if config.Timeout == 0 {
+			config.Timeout = defaultTimeout
+		}
+		var err error
+		if e.subject, err = parse(name+".subject", config.Subject, DefaultSubject); err != nil {
+			return nil, err
+		}
+		if e.body, err = parse(name+".body", config.Body, DefaultBody); err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(config.Type) {
+		case "webhook":
+			e.notifier, err = newWebhook(config)
+		case "email":
+			e.notifier, err = newEmail(config)
+		case "command":
+			e.notifier, err = newCommand(config)
+		default:
+			err = fmt.Errorf("unknown type %q, expected webhook, email or command", config.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Notifier %s: %v", name, err)
+		}
+		n.entries = append(n.entries, e)
+	}
+	return n, nil
+}
+
+func parse(name, text, defaultText string) (*template.Template, error) {
+	if text == "" {
+		text = defaultText
+	}
+	t, err := template.New(name).Funcs(naming.Funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Template %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// Notify sends the report to every notifier it is wanted by, carrying on past
+// failures. A nil Notifiers sends nothing.
+func (n *Notifiers) Notify(report *Report) error {
+	if n == nil {
+		return nil
+	}
+	var errs []error
+	for _, e := range n.entries {
+		if !e.wants(report) {
+			continue
+		}
+		message, err := e.render(report)
+		if err == nil {
+			err = e.notifier.Notify(report, message)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Notifier %s: %v", e.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (e entry) wants(report *Report) bool {
+	switch e.on {
+	case Errors:
+		return report.HasErrors()
+	case Changes:
+		return report.HasErrors() || len(report.Uploaded) > 0
+	}
+	return true
+}
+
+func (e entry) render(report *Report) (Message, error) {
+	var subject, body bytes.Buffer
+	if err := e.subject.Execute(&subject, report); err != nil {
+		return Message{}, err
+	}
+	if err := e.body.Execute(&body, report); err != nil {
+		return Message{}, err
+	}
+	return Message{Subject: strings.TrimSpace(subject.String()), Body: body.String()}, nil
+}
@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/pipeline"
+)
+
+var start = time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)
+
+func testReport() *Report {
+	r := NewReport("default")
+	run := &gc.Activity{ID: 1, Name: "Morning Run", Type: "running", StartTime: start}
+	ride := &gc.Activity{ID: 2, Name: "Commute", Type: "cycling", StartTime: start.Add(time.Hour)}
+	r.Event(pipeline.Event{Type: pipeline.Listed, Activity: run})
+	r.Event(pipeline.Event{Type: pipeline.Done, Activity: run, Entry: ledger.Entry{GarminID: 1, StravaActivityID: 1001}})
+	r.Event(pipeline.Event{Type: pipeline.Failed, Activity: ride, Err: errors.New("export failed")})
+	r.Event(pipeline.Event{Type: pipeline.Queued, Activity: ride, Message: "queued for retry"})
+	r.Finish(nil)
+	return r
+}
+
+func TestReport(t *testing.T) {
+	r := testReport()
+	if len(r.Uploaded) != 1 || r.Uploaded[0].URL != "https://www.strava.com/activities/1001" {
+		t.Fatalf("uploaded = %+v", r.Uploaded)
+	}
+	if len(r.Failed) != 1 || r.Failed[0].Error != "export failed" || r.Failed[0].Retry != "queued for retry" {
+		t.Fatalf("failed = %+v", r.Failed)
+	}
+	if !r.HasErrors() {
+		t.Fatal("expected errors")
+	}
+}
+
+func TestDefaultTemplates(t *testing.T) {
+	n, err := New([]Config{{Type: "command", Command: []string{"true"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := n.entries[0].render(testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Subject != "gravasync default: 1 uploaded, 1 failed" {
+		t.Fatalf("subject = %q", message.Subject)
+	}
+	expected := "Uploaded Morning Run (2017-12-30 09:15): https://www.strava.com/activities/1001\n" +
+		"Failed Commute (2017-12-30 10:15): export failed, queued for retry\n"
+	if message.Body != expected {
+		t.Fatalf("body = %q", message.Body)
+	}
+}
+
+func TestOn(t *testing.T) {
+	quiet := NewReport("default")
+	quiet.Finish(nil)
+	uploaded := NewReport("default")
+	uploaded.Uploaded = []Upload{{ActivityID: 1}}
+	failed := NewReport("default")
+	failed.Finish(errors.New("login failed"))
+	for _, test := range []struct {
+		on       string
+		expected []bool
+	}{
+		{Always, []bool{true, true, true}},
+		{Changes, []bool{false, true, true}},
+		{Errors, []bool{false, false, true}},
+	} {
+		e := entry{on: test.on}
+		for i, report := range []*Report{quiet, uploaded, failed} {
+			if e.wants(report) != test.expected[i] {
+				t.Fatalf("%s: wants report %d = %v", test.on, i, !test.expected[i])
+			}
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, config := range []Config{
+		{Type: "pager"},
+		{Type: "webhook"},
+		{Type: "email", Host: "smtp.example.com"},
+		{Type: "command", On: "sometimes", Command: []string{"true"}},
+		{Type: "command", Command: []string{"true"}, Body: "{{.Nope"},
+	} {
+		if _, err := New([]Config{config}); err == nil {
+			t.Fatalf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var payload map[string]interface{}
+	var token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+	n, err := New([]Config{{Type: "webhook", URL: server.URL, Headers: map[string]string{"Authorization": "Bearer abc"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if token != "Bearer abc" || payload["profile"] != "default" || !strings.Contains(payload["text"].(string), "activities/1001") {
+		t.Fatalf("payload = %v, token = %q", payload, token)
+	}
+	if uploaded := payload["uploaded"].([]interface{}); len(uploaded) != 1 {
+		t.Fatalf("uploaded = %v", uploaded)
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer server.Close()
+	n, err := New([]Config{{Type: "webhook", URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testReport()); err == nil || !strings.Contains(err.Error(), "no such hook") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	n, err := New([]Config{{Type: "email", On: Errors, Host: "smtp.example.com", Username: "me", Password: "pw", From: "gravasync@example.com", To: []string{"me@example.com"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addr string
+	var msg []byte
+	e := n.entries[0].notifier.(*email)
+	e.send = func(a string, auth smtp.Auth, from string, to []string, m []byte) error {
+		addr, msg = a, m
+		return nil
+	}
+	if err := n.Notify(testReport()); err != nil {
+		t.Fatal(err)
+	}
+	text := string(msg)
+	if addr != "smtp.example.com:587" || !strings.Contains(text, "Subject: gravasync default: 1 uploaded, 1 failed\r\n") || !strings.Contains(text, "\r\nFailed Commute") {
+		t.Fatalf("sent to %s:\n%s", addr, text)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	n, err := New([]Config{{Type: "command", Command: []string{"sh", "-c", `{ echo "$GRAVASYNC_PROFILE $GRAVASYNC_FAILED"; cat; } > "$0"`, out}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(testReport()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "default 1\nUploaded Morning Run") {
+		t.Fatalf("output = %q", data)
+	}
+
+	n, _ = New([]Config{{Type: "command", Command: []string{"sh", "-c", "echo broken >&2; exit 3"}}})
+	if err := n.Notify(testReport()); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("err = %v", err)
+	}
+}
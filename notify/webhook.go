@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// webhook POSTs the report as JSON, with the rendered message as subject and
+// text fields.
+type webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+type webhookPayload struct {
+	*Report
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+}
+
+func newWebhook(config Config) (Notifier, error) {
+	if config.URL == "" {
+		return nil, errors.New("url is required")
+	}
+	return &webhook{url: config.URL, headers: config.Headers, client: &http.Client{Timeout: config.Timeout}}, nil
+}
+
+func (w *webhook) Notify(report *Report, message Message) error {
+	body, err := json.Marshal(webhookPayload{Report: report, Subject: message.Subject, Text: message.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		text, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("POST %s: %s %s", w.url, resp.Status, bytes.TrimSpace(text))
+	}
+	return nil
+}
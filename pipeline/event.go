@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+)
+
+// EventType is a stage in an activity's progress through a Syncer.
+type EventType int
+
+const (
+	// Listed is sent for each activity Run finds on Garmin Connect.
+	Listed EventType = iota
+	// Skipped activities are already in the ledger or skipped by a rule.
+	Skipped
+	Exporting
+	Uploading
+	Done
+	Failed
+	// Queued follows Failed when the activity is added to the retry queue.
+	Queued
+)
+
+var eventTypeNames = []string{"listed", "skipped", "exporting", "uploading", "done", "failed", "queued"}
+
+func (t EventType) String() string {
+	if int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return "unknown"
+}
+
+// Event reports progress. Message depends on the type: why an activity was
+// skipped, the export format, the upload name, the Strava activity created
+// or what happens next to a failed upload. Notes carry warnings such as TCX
+// validation problems.
+type Event struct {
+	Type     EventType
+	Activity *gc.Activity
+	// Rule names the matching rule, for Skipped events.
+	Rule    string
+	Message string
+	Notes   []string
+	// Entry is the ledger entry recorded, for Done events.
+	Entry ledger.Entry
+	Err   error
+}
@@ -0,0 +1,172 @@
+package pipeline
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/rules"
+)
+
+// job is one activity moving through the pipeline. seq numbers jobs in
+// listing order so results can be recorded in that order.
+type job struct {
+	seq      int
+	activity *gc.Activity
+	actions  rules.Actions
+	format   string
+	data     []byte
+	notes    []string
+	entry    ledger.Entry
+	uploaded bool
+	err      error
+}
+
+// runPipelined lists activities on the calling goroutine while export
+// workers fetch and prepare them and upload workers send them to Strava and
+// poll for the result. A single recorder writes ledger entries in listing
+// order, holding back results that finish early. Failures queued for retry
+// are passed over; after any other failure no new activities are started,
+// but those in flight are finished and recorded.
+func (s *Syncer) runPipelined() error {
+	exportWorkers, uploadWorkers := s.options.ExportWorkers, s.options.UploadWorkers
+	if exportWorkers < 1 {
+		exportWorkers = 1
+	}
+	if uploadWorkers < 1 {
+		uploadWorkers = 1
+	}
+	exports := make(chan *job)
+	uploads := make(chan *job, uploadWorkers)
+	results := make(chan *job, uploadWorkers)
+	failed := make(chan struct{})
+	var failOnce sync.Once
+
+	var exportGroup, uploadGroup sync.WaitGroup
+	for i := 0; i < exportWorkers; i++ {
+		exportGroup.Add(1)
+		go func() {
+			defer exportGroup.Done()
+			for j := range exports {
+				if s.exportJob(j); j.err != nil {
+					results <- j
+					continue
+				}
+				uploads <- j
+			}
+		}()
+	}
+	for i := 0; i < uploadWorkers; i++ {
+		uploadGroup.Add(1)
+		go func() {
+			defer uploadGroup.Done()
+			for j := range uploads {
+				s.uploadJob(j)
+				results <- j
+			}
+		}()
+	}
+	go func() {
+		exportGroup.Wait()
+		close(uploads)
+		uploadGroup.Wait()
+		close(results)
+	}()
+
+	recorded := make(chan error, 1)
+	go func() {
+		var firstErr error
+		pending := map[int]*job{}
+		next := 0
+		for j := range results {
+			pending[j.seq] = j
+			for j, ok := pending[next]; ok; j, ok = pending[next] {
+				delete(pending, next)
+				next++
+				if err := s.recordJob(j); err != nil && firstErr == nil && !s.queued(j.activity) {
+					firstErr = err
+					failOnce.Do(func() { close(failed) })
+				}
+			}
+		}
+		recorded <- firstErr
+	}()
+
+	seq := 0
+list:
+	for activity := s.source.NextActivity(); activity != nil; activity = s.source.NextActivity() {
+		if s.Skip(activity) {
+			continue
+		}
+		select {
+		case <-failed:
+			break list
+		default:
+		}
+		select {
+		case exports <- &job{seq: seq, activity: activity}:
+			seq++
+		case <-failed:
+			break list
+		}
+	}
+	close(exports)
+	return <-recorded
+}
+
+// exportJob downloads and prepares an activity, trimming it if AutoTrim is
+// set.
+func (s *Syncer) exportJob(j *job) {
+	j.actions = s.Actions(j.activity)
+	format, export, err := s.export(j.activity, j.actions)
+	if err != nil {
+		j.err = err
+		return
+	}
+	defer export.Close()
+	data, err := ioutil.ReadAll(export)
+	if err != nil {
+		j.err = err
+		return
+	}
+	if s.options.Trimmer != nil && s.options.AutoTrim {
+		if data, j.notes, err = s.trimIdle(format, data, j.notes); err != nil {
+			j.err = err
+			return
+		}
+	}
+	j.format = format
+	j.data, j.notes, j.err = s.prepare(format, data, j.notes)
+}
+
+// uploadJob sends a prepared activity to Strava and applies its metadata.
+func (s *Syncer) uploadJob(j *job) {
+	entry, err := s.transfer(j.activity, j.actions, j.format, bytes.NewReader(j.data), j.notes)
+	j.data = nil
+	if err != nil {
+		j.err = err
+		return
+	}
+	j.entry, j.uploaded = entry, true
+	var notes []string
+	notes, j.err = s.applyMetadata(j.activity, entry.StravaActivityID, j.actions)
+	j.notes = append(j.notes, notes...)
+}
+
+// recordJob writes a finished job to the ledger and reports its outcome. An
+// activity that was uploaded is recorded even if applying its metadata
+// failed, so it is not uploaded again.
+func (s *Syncer) recordJob(j *job) error {
+	if j.uploaded {
+		if err := s.record(j.entry, nil); err != nil {
+			return s.queueFailure(s.fail(j.activity, err), j.activity, nil)
+		}
+	}
+	if j.err != nil {
+		return s.queueFailure(s.fail(j.activity, j.err, j.notes...), j.activity, nil)
+	}
+	s.done(j.activity, j.entry, j.notes...)
+	return nil
+}
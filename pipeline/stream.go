@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+var errAbandoned = errors.New("upload abandoned")
+
+// checkedTCX passes a TCX export through while a second goroutine validates
+// it. The end of the export is only reported once validation has finished,
+// and is replaced by the validation error if Strava would reject the file,
+// so the upload fails instead of completing.
+type checkedTCX struct {
+	r        io.Reader
+	w        *io.PipeWriter
+	done     chan struct{}
+	problems []tcx.Problem
+	err      error
+}
+
+func newCheckedTCX(r io.Reader) *checkedTCX {
+	pr, pw := io.Pipe()
+	c := &checkedTCX{r: io.TeeReader(r, pw), w: pw, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		problems, err := tcx.ValidateStream(pr)
+		if err != nil {
+			c.err = fmt.Errorf("Export TCX: %v", err)
+		} else if errs := tcx.Errors(problems); len(errs) > 0 {
+			c.err = &ValidationError{Errors: len(errs)}
+		}
+		c.problems = problems
+		// Keep reading so the upload is not held up by a validator which
+		// stopped early.
+		io.Copy(ioutil.Discard, pr)
+	}()
+	return c
+}
+
+func (c *checkedTCX) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.EOF {
+		c.w.Close()
+		<-c.done
+		if c.err != nil {
+			return n, c.err
+		}
+	} else if err != nil {
+		c.w.CloseWithError(err)
+	}
+	return n, err
+}
+
+// Close stops validation if the upload ended before the export did.
+func (c *checkedTCX) Close() error {
+	c.w.CloseWithError(errAbandoned)
+	<-c.done
+	return nil
+}
+
+// result adds the problems found to notes and returns the validation error,
+// if validation finished.
+func (c *checkedTCX) result(notes []string) ([]string, error) {
+	select {
+	case <-c.done:
+	default:
+		return notes, nil
+	}
+	for _, problem := range c.problems {
+		notes = append(notes, problem.String())
+	}
+	return notes, c.err
+}
@@ -0,0 +1,579 @@
+// Package pipeline copies activities from Garmin Connect to Strava. It holds the
+// export, transform and upload pipeline used by the gravasync command, with
+// progress reported through events rather than printed.
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icalder/gravasync/convert"
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/merge"
+	"github.com/icalder/gravasync/metadata"
+	"github.com/icalder/gravasync/naming"
+	"github.com/icalder/gravasync/privacy"
+	"github.com/icalder/gravasync/retry"
+	"github.com/icalder/gravasync/rules"
+	"github.com/icalder/gravasync/strava"
+	"github.com/icalder/gravasync/tcx"
+	"github.com/icalder/gravasync/trim"
+)
+
+// DefaultUploadTimeout bounds how long to wait for Strava to process an
+// upload when Options.UploadTimeout is zero.
+const DefaultUploadTimeout = 2 * time.Minute
+
+// Options configure a Syncer. Only Ledger is required; nil transforms are
+// skipped.
+type Options struct {
+	Ledger ledger.Ledger
+	Rules  *rules.Engine
+	// Mapper sets Strava sport type, gear and privacy after upload.
+	Mapper *metadata.Mapper
+	// Names renders upload names and descriptions; without it the Garmin
+	// name is used.
+	Names   *naming.Renderer
+	Privacy *privacy.Filter
+	Trimmer *trim.Trimmer
+	// ExportWorkers and UploadWorkers bound how many Garmin exports and
+	// Strava uploads Run performs at once. With either above one, Run
+	// pipelines exports ahead of uploads, holding each export in memory
+	// until it is uploaded. Ledger entries are still written in listing
+	// order.
+	ExportWorkers int
+	UploadWorkers int
+	// AutoTrim applies the Trimmer's suggestion on every upload.
+	AutoTrim bool
+	// SkipValidation sends TCX exports to Strava without checking them
+	// first. Exports are streamed straight through when nothing needs the
+	// whole file: no privacy zones and no trimming. Validation checks TCX as
+	// it streams, abandoning the upload if Strava would reject it.
+	SkipValidation bool
+	// Compress gzips uploads on the fly.
+	Compress      bool
+	UploadTimeout time.Duration
+	// Retry, if set, keeps failed uploads to be tried again. Entries are
+	// removed once the activity is recorded in the ledger.
+	Retry retry.Queue
+	// Events receives progress. Calls are serialised but may come from
+	// worker goroutines.
+	Events func(Event)
+}
+
+// Syncer uploads Garmin Connect activities to Strava.
+type Syncer struct {
+	source      gc.GarminConnect
+	destination strava.Strava
+	options     Options
+	eventsMu    sync.Mutex
+}
+
+// New returns a Syncer for logged in clients.
+func New(source gc.GarminConnect, destination strava.Strava, options Options) (*Syncer, error) {
+	if options.Ledger == nil {
+		return nil, errors.New("Sync: a ledger is required")
+	}
+	if options.Rules == nil {
+		options.Rules, _ = rules.New(nil)
+	}
+	if options.UploadTimeout == 0 {
+		options.UploadTimeout = DefaultUploadTimeout
+	}
+	return &Syncer{source: source, destination: destination, options: options}, nil
+}
+
+func (s *Syncer) emit(event Event) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	if s.options.Events != nil {
+		s.options.Events(event)
+	}
+}
+
+// Run uploads every listed activity not already in the ledger and not
+// skipped by a rule, stopping at the first failure that was not queued for
+// retry.
+func (s *Syncer) Run() error {
+	if s.options.ExportWorkers > 1 || s.options.UploadWorkers > 1 {
+		return s.runPipelined()
+	}
+	for activity := s.source.NextActivity(); activity != nil; activity = s.source.NextActivity() {
+		if s.Skip(activity) {
+			continue
+		}
+		if err := s.Upload(activity); err != nil && !s.queued(activity) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Skip reports a listed activity and whether to pass over it, because it is
+// in the ledger, parked in the retry queue or a rule says to skip it.
+func (s *Syncer) Skip(activity *gc.Activity) bool {
+	s.emit(Event{Type: Listed, Activity: activity})
+	if s.options.Ledger.Contains(activity.ID) {
+		s.emit(Event{Type: Skipped, Activity: activity, Message: "already uploaded"})
+		return true
+	}
+	if s.options.Retry != nil {
+		if item, ok := s.options.Retry.Get(activity.ID); ok && item.Parked {
+			s.emit(Event{Type: Skipped, Activity: activity, Message: "parked: " + item.Error})
+			return true
+		}
+	}
+	if rule, ok := s.Match(activity); ok && rule.Then.Skip {
+		s.emit(Event{Type: Skipped, Activity: activity, Rule: rule.Name, Message: "skipped by rule " + rule.Name})
+		return true
+	}
+	return false
+}
+
+// Match returns the first rule matching activity.
+func (s *Syncer) Match(activity *gc.Activity) (*rules.Rule, bool) {
+	return s.options.Rules.Match(*activity)
+}
+
+// Actions returns what the first matching rule says to do with activity.
+func (s *Syncer) Actions(activity *gc.Activity) rules.Actions {
+	if rule, ok := s.Match(activity); ok {
+		return rule.Then
+	}
+	return rules.Actions{}
+}
+
+// Upload copies an activity to Strava, trimming idle time first if
+// AutoTrim is set.
+func (s *Syncer) Upload(activity *gc.Activity) error {
+	return s.queueFailure(s.upload(activity), activity, nil)
+}
+
+func (s *Syncer) upload(activity *gc.Activity) error {
+	actions := s.Actions(activity)
+	format, export, err := s.Export(activity, actions)
+	if err != nil {
+		return err
+	}
+	defer export.Close()
+	autoTrim := s.options.Trimmer != nil && s.options.AutoTrim
+	if !autoTrim && !s.needsWholeFile(format) {
+		return s.stream(activity, actions, format, export, nil)
+	}
+	data, err := ioutil.ReadAll(export)
+	if err != nil {
+		return s.fail(activity, err)
+	}
+	var notes []string
+	if autoTrim {
+		if data, notes, err = s.trimIdle(format, data, notes); err != nil {
+			return s.fail(activity, err)
+		}
+	}
+	return s.send(activity, actions, format, data, notes, nil)
+}
+
+// trimIdle applies the Trimmer's suggestion, noting the idle time removed.
+func (s *Syncer) trimIdle(format string, data []byte, notes []string) ([]byte, []string, error) {
+	suggestion, err := s.options.Trimmer.SuggestFile(data, format)
+	if err != nil {
+		return nil, notes, err
+	}
+	if suggestion.IsEmpty() {
+		return data, notes, nil
+	}
+	if data, err = suggestion.ApplyFile(data, format); err != nil {
+		return nil, notes, err
+	}
+	return data, append(notes, "idle time: "+suggestion.String()), nil
+}
+
+// Export starts downloading an activity in the format its rule asks for.
+// The caller must close the returned reader.
+func (s *Syncer) Export(activity *gc.Activity, actions rules.Actions) (string, io.ReadCloser, error) {
+	format, export, err := s.export(activity, actions)
+	if err != nil {
+		return "", nil, s.fail(activity, err)
+	}
+	return format, export, nil
+}
+
+func (s *Syncer) export(activity *gc.Activity, actions rules.Actions) (string, io.ReadCloser, error) {
+	format := actions.Format
+	if format == "" {
+		format = convert.TCX
+	}
+	s.emit(Event{Type: Exporting, Activity: activity, Message: format})
+	export, err := s.source.Export(activity.ID, format)
+	return format, export, err
+}
+
+// Send uploads an exported activity, with positions in privacy zones
+// removed, records it in the ledger and then applies the Garmin metadata to
+// the new Strava activity. Merged are the IDs of any other Garmin activities
+// included in the upload; they are recorded in the ledger too.
+// A failed upload is not queued for retry, as the queue cannot hold the
+// changes the caller made to the data.
+func (s *Syncer) Send(activity *gc.Activity, actions rules.Actions, format string, data io.Reader, merged ...int64) error {
+	if !s.needsWholeFile(format) {
+		return s.stream(activity, actions, format, data, merged)
+	}
+	buffered, err := ioutil.ReadAll(data)
+	if err != nil {
+		return s.fail(activity, err)
+	}
+	return s.send(activity, actions, format, buffered, nil, merged)
+}
+
+// UploadMerged joins several Garmin activities into one Strava upload. The
+// earliest activity's rules and metadata are used.
+func (s *Syncer) UploadMerged(activities []*gc.Activity) error {
+	sort.Slice(activities, func(i, j int) bool { return activities[i].StartTime.Before(activities[j].StartTime) })
+	return s.queueFailure(s.uploadMerged(activities), activities[0], activities[1:])
+}
+
+func (s *Syncer) uploadMerged(activities []*gc.Activity) error {
+	combined := *activities[0]
+	var dbs []*tcx.Database
+	var others []int64
+	for i, activity := range activities {
+		s.emit(Event{Type: Exporting, Activity: activity, Message: convert.TCX})
+		export, err := s.source.ExportTCX(activity.ID)
+		if err != nil {
+			return s.fail(activity, err)
+		}
+		db, err := tcx.Parse(export)
+		export.Close()
+		if err != nil {
+			return s.fail(activity, fmt.Errorf("Export TCX %d: %v", activity.ID, err))
+		}
+		dbs = append(dbs, db)
+		if i > 0 {
+			combined.Distance += activity.Distance
+			combined.Duration += activity.Duration
+			combined.EndTime = activity.EndTime
+			others = append(others, activity.ID)
+		}
+	}
+	db, err := merge.Activities(dbs...)
+	if err != nil {
+		return s.fail(&combined, err)
+	}
+	actions := s.Actions(&combined)
+	format := actions.Format
+	if format == "" {
+		format = convert.TCX
+	}
+	data, err := convert.Write(db, format)
+	if err != nil {
+		return s.fail(&combined, err)
+	}
+	return s.send(&combined, actions, format, data, nil, others)
+}
+
+// needsWholeFile reports whether privacy zones have to read the activity
+// before it is uploaded. TCX validation does not: it checks the export as it
+// is streamed.
+func (s *Syncer) needsWholeFile(format string) bool {
+	return s.options.Privacy != nil
+}
+
+// stream uploads an export as it is read, validating TCX on the way. The
+// upload is abandoned, and never completes, if the export is invalid.
+func (s *Syncer) stream(activity *gc.Activity, actions rules.Actions, format string, data io.Reader, merged []int64) error {
+	if format != convert.TCX || s.options.SkipValidation {
+		return s.importActivity(activity, actions, format, data, nil, merged)
+	}
+	checked := newCheckedTCX(data)
+	defer checked.Close()
+	return s.importActivity(activity, actions, format, checked, nil, merged)
+}
+
+// send applies privacy zones and validation to a buffered activity and
+// uploads it.
+func (s *Syncer) send(activity *gc.Activity, actions rules.Actions, format string, data []byte, notes []string, merged []int64) error {
+	data, notes, err := s.prepare(format, data, notes)
+	if err != nil {
+		return s.fail(activity, err, notes...)
+	}
+	return s.importActivity(activity, actions, format, bytes.NewReader(data), notes, merged)
+}
+
+// prepare removes positions in privacy zones and validates TCX, adding any
+// problems found to notes.
+func (s *Syncer) prepare(format string, data []byte, notes []string) ([]byte, []string, error) {
+	var err error
+	if s.options.Privacy != nil {
+		var stripped int
+		if data, stripped, err = s.options.Privacy.Apply(data, format); err != nil {
+			return nil, notes, fmt.Errorf("Privacy zones: %v", err)
+		}
+		if stripped > 0 {
+			notes = append(notes, fmt.Sprintf("removed %d GPS points inside privacy zones", stripped))
+		}
+	}
+	if format == convert.TCX && !s.options.SkipValidation {
+		problems, err := checkTCX(data)
+		for _, problem := range problems {
+			notes = append(notes, problem.String())
+		}
+		if err != nil {
+			return nil, notes, err
+		}
+	}
+	return data, notes, nil
+}
+
+// importActivity streams an activity to Strava, waits for it to be
+// processed, records it in the ledger and applies the metadata.
+func (s *Syncer) importActivity(activity *gc.Activity, actions rules.Actions, format string, data io.Reader, notes []string, merged []int64) error {
+	entry, err := s.transfer(activity, actions, format, data, notes)
+	if checked, ok := data.(*checkedTCX); ok {
+		var validationErr error
+		notes, validationErr = checked.result(notes)
+		if validationErr != nil {
+			err = validationErr
+		}
+	}
+	if err != nil {
+		return s.fail(activity, err, notes...)
+	}
+	if err := s.record(entry, merged); err != nil {
+		return s.fail(activity, err)
+	}
+	metadataNotes, err := s.applyMetadata(activity, entry.StravaActivityID, actions)
+	if err != nil {
+		return s.fail(activity, err)
+	}
+	s.done(activity, entry, append(notes, metadataNotes...)...)
+	return nil
+}
+
+// transfer uploads an activity and waits for Strava to process it,
+// returning the ledger entry to record.
+func (s *Syncer) transfer(activity *gc.Activity, actions rules.Actions, format string, data io.Reader, notes []string) (ledger.Entry, error) {
+	name, err := s.uploadName(activity, actions)
+	if err != nil {
+		return ledger.Entry{}, err
+	}
+	dataType := format
+	if s.options.Compress {
+		compressed := strava.Gzip(data)
+		defer compressed.Close()
+		data, dataType = compressed, format+".gz"
+	}
+	s.emit(Event{Type: Uploading, Activity: activity, Message: name, Notes: notes})
+	upload, err := s.destination.Import(name, actions.Private, dataType, data)
+	if err != nil {
+		return ledger.Entry{}, err
+	}
+	if upload, err = s.destination.WaitForUpload(upload, s.options.UploadTimeout); err != nil {
+		return ledger.Entry{}, err
+	}
+	return ledger.Entry{GarminID: activity.ID, StravaActivityID: upload.ActivityID, Name: name}, nil
+}
+
+// record writes the ledger entry for an upload, and for any activities merged
+// into it.
+func (s *Syncer) record(entry ledger.Entry, merged []int64) error {
+	for _, garminID := range append([]int64{entry.GarminID}, merged...) {
+		e := entry
+		e.GarminID = garminID
+		if err := s.options.Ledger.Record(e); err != nil {
+			return &recordError{err}
+		}
+		if s.options.Retry != nil {
+			if _, err := s.options.Retry.Remove(garminID); err != nil {
+				return &recordError{err}
+			}
+		}
+	}
+	return nil
+}
+
+// queueFailure adds an activity whose upload failed to the retry queue, if
+// there is one, and returns err. Merged are the other activities of a merged
+// upload. Activities already in the ledger are not queued: only their
+// metadata update failed. Nor are uploads Strava accepted but which could not
+// be recorded, as trying them again would upload them twice.
+func (s *Syncer) queueFailure(err error, activity *gc.Activity, merged []*gc.Activity) error {
+	var recordErr *recordError
+	if err == nil || s.options.Retry == nil || errors.As(err, &recordErr) || s.options.Ledger.Contains(activity.ID) {
+		return err
+	}
+	var others []gc.Activity
+	for _, m := range merged {
+		others = append(others, *m)
+	}
+	item, queueErr := s.options.Retry.Add(*activity, others, err, permanent(err))
+	if queueErr != nil {
+		return fmt.Errorf("%v (could not queue for retry: %v)", err, queueErr)
+	}
+	message := fmt.Sprintf("queued for retry after %d attempts", item.Attempts)
+	if item.Parked {
+		message = fmt.Sprintf("parked after %d attempts", item.Attempts)
+	}
+	s.emit(Event{Type: Queued, Activity: activity, Message: message, Err: err})
+	return err
+}
+
+// queued reports whether activity is in the retry queue, so that a failure
+// to upload it need not stop a run.
+func (s *Syncer) queued(activity *gc.Activity) bool {
+	if s.options.Retry == nil {
+		return false
+	}
+	_, ok := s.options.Retry.Get(activity.ID)
+	return ok
+}
+
+// recordError is a failure to record an upload which Strava accepted.
+type recordError struct {
+	err error
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// permanent reports whether an upload would fail the same way if tried
+// again.
+func permanent(err error) bool {
+	var uploadErr *strava.UploadError
+	var validationErr *ValidationError
+	return errors.As(err, &uploadErr) || errors.As(err, &validationErr)
+}
+
+// Retry tries the uploads in the retry queue again, including parked ones
+// if parked is set, and returns how many succeeded. Failures are counted
+// against their queue entries as usual.
+func (s *Syncer) Retry(parked bool) (int, error) {
+	if s.options.Retry == nil {
+		return 0, errors.New("Sync: no retry queue")
+	}
+	uploaded := 0
+	for _, item := range s.options.Retry.Items() {
+		if item.Parked && !parked {
+			continue
+		}
+		activity := item.Activity
+		if s.options.Ledger.Contains(activity.ID) {
+			if _, err := s.options.Retry.Remove(activity.ID); err != nil {
+				return uploaded, err
+			}
+			continue
+		}
+		var err error
+		if len(item.Merged) == 0 {
+			err = s.Upload(&activity)
+		} else {
+			activities := []*gc.Activity{&activity}
+			for i := range item.Merged {
+				activities = append(activities, &item.Merged[i])
+			}
+			err = s.UploadMerged(activities)
+		}
+		if err == nil {
+			uploaded++
+		}
+	}
+	return uploaded, nil
+}
+
+func (s *Syncer) done(activity *gc.Activity, entry ledger.Entry, notes ...string) {
+	s.emit(Event{Type: Done, Activity: activity, Entry: entry, Message: fmt.Sprintf("Uploaded as Strava activity %d", entry.StravaActivityID), Notes: notes})
+}
+
+func (s *Syncer) fail(activity *gc.Activity, err error, notes ...string) error {
+	s.emit(Event{Type: Failed, Activity: activity, Err: err, Notes: notes})
+	return err
+}
+
+// checkTCX validates an exported TCX file, failing if Strava would reject
+// it.
+func checkTCX(data []byte) ([]tcx.Problem, error) {
+	db, err := tcx.ParseBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("Export TCX: %v", err)
+	}
+	problems := db.Validate()
+	if errs := tcx.Errors(problems); len(errs) > 0 {
+		return problems, &ValidationError{Errors: len(errs)}
+	}
+	return problems, nil
+}
+
+// ValidationError is an export with problems Strava would reject.
+type ValidationError struct {
+	Errors int
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Export TCX: %d validation errors, not uploading", e.Errors)
+}
+
+// uploadName renders a rule's rename template if it has one, otherwise the
+// configured name template.
+func (s *Syncer) uploadName(activity *gc.Activity, actions rules.Actions) (string, error) {
+	if actions.Rename != "" {
+		renamer, err := naming.New(naming.Config{Name: actions.Rename})
+		if err != nil {
+			return "", err
+		}
+		return renamer.Name(*activity)
+	}
+	if s.options.Names == nil {
+		return activity.Name, nil
+	}
+	return s.options.Names.Name(*activity)
+}
+
+// applyMetadata updates the Strava activity with the mapped Garmin metadata,
+// the templated description and any flags set by rules. If the Garmin gear
+// cannot be fetched the rest is still applied, with a note saying why gear
+// was not set.
+func (s *Syncer) applyMetadata(activity *gc.Activity, stravaActivityID int64, actions rules.Actions) ([]string, error) {
+	var update strava.ActivityUpdate
+	var notes []string
+	if s.options.Mapper != nil {
+		gear, err := s.source.Gear(activity.ID)
+		if err != nil {
+			notes = append(notes, fmt.Sprintf("gear not set: %v", err))
+		}
+		update = s.options.Mapper.Update(*activity, gear)
+	}
+	if s.options.Names != nil {
+		description, ok, err := s.options.Names.Description(*activity)
+		if err != nil {
+			return notes, err
+		}
+		if ok {
+			update.Description = description
+		}
+	}
+	set := true
+	if actions.HideFromHome {
+		update.HideFromHome = &set
+	}
+	if actions.Commute {
+		update.Commute = &set
+	}
+	if actions.Gear != "" {
+		update.GearID = actions.Gear
+	}
+	if update.IsEmpty() {
+		return notes, nil
+	}
+	return notes, s.destination.UpdateActivity(stravaActivityID, update)
+}
@@ -0,0 +1,411 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+	"github.com/icalder/gravasync/ledger"
+	"github.com/icalder/gravasync/metadata"
+	"github.com/icalder/gravasync/retry"
+	"github.com/icalder/gravasync/rules"
+	"github.com/icalder/gravasync/strava"
+	"github.com/icalder/gravasync/tcx"
+)
+
+var start = time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)
+
+type fakeGarmin struct {
+	gc.GarminConnect
+	activities []*gc.Activity
+	next       int
+	broken     int64
+	// invalid is exported without trackpoints.
+	invalid int64
+}
+
+func (f *fakeGarmin) NextActivity() *gc.Activity {
+	if f.next == len(f.activities) {
+		return nil
+	}
+	f.next++
+	return f.activities[f.next-1]
+}
+
+func (f *fakeGarmin) Gear(activityID int64) ([]gc.Gear, error) {
+	return nil, errors.New("gear unavailable")
+}
+
+func (f *fakeGarmin) ExportTCX(activityID int64) (io.ReadCloser, error) {
+	return f.Export(activityID, "tcx")
+}
+
+func (f *fakeGarmin) Export(activityID int64, format string) (io.ReadCloser, error) {
+	if activityID == f.broken {
+		return nil, errors.New("export failed")
+	}
+	for _, activity := range f.activities {
+		if activity.ID == activityID {
+			lap := tcx.Lap{StartTime: activity.StartTime, TotalTimeSeconds: 60, DistanceMeters: 200, Intensity: "Active", TriggerMethod: "Manual"}
+			for i := 0; i < 2 && activityID != f.invalid; i++ {
+				lap.Track = append(lap.Track, tcx.Trackpoint{
+					Time:     activity.StartTime.Add(time.Duration(i) * time.Minute),
+					Position: &tcx.Position{LatitudeDegrees: 53.8, LongitudeDegrees: -1.55 + float64(i)*0.003},
+				})
+			}
+			db := tcx.Database{Activities: []tcx.Activity{{Sport: "Running", ID: activity.StartTime.Format(time.RFC3339), Laps: []tcx.Lap{lap}}}}
+			data, err := db.Bytes()
+			return ioutil.NopCloser(bytes.NewReader(data)), err
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+type fakeStrava struct {
+	strava.Strava
+	mu        sync.Mutex
+	uploads   []string
+	dataTypes []string
+	updates   []strava.ActivityUpdate
+}
+
+func (f *fakeStrava) UpdateActivity(activityID int64, update strava.ActivityUpdate) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updates = append(f.updates, update)
+	return nil
+}
+
+func (f *fakeStrava) Import(name string, private bool, dataType string, data io.Reader) (*strava.Upload, error) {
+	if _, err := ioutil.ReadAll(data); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploads = append(f.uploads, name)
+	f.dataTypes = append(f.dataTypes, dataType)
+	return &strava.Upload{ID: int64(len(f.uploads)), Status: "Your activity is still being processed."}, nil
+}
+
+// WaitForUpload takes longer for earlier uploads, so that concurrent
+// uploads finish out of order.
+func (f *fakeStrava) WaitForUpload(upload *strava.Upload, timeout time.Duration) (*strava.Upload, error) {
+	time.Sleep(time.Duration(10-upload.ID%10) * time.Millisecond)
+	return &strava.Upload{ID: upload.ID, ActivityID: 1000 + upload.ID, Status: "Your activity is ready."}, nil
+}
+
+func newTestSyncer(t *testing.T, activities []*gc.Activity, ruleList []rules.Rule) (*Syncer, *fakeStrava, ledger.Ledger, *[]Event) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := ledger.Open(filepath.Join(dir, "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, err := rules.New(ruleList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	destination := &fakeStrava{}
+	s, err := New(&fakeGarmin{activities: activities}, destination, Options{
+		Ledger: l,
+		Rules:  engine,
+		Events: func(e Event) { events = append(events, e) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, destination, l, &events
+}
+
+func TestRun(t *testing.T) {
+	activities := []*gc.Activity{
+		{ID: 1, Name: "Morning Run", Type: "running", StartTime: start},
+		{ID: 2, Name: "Commute", Type: "cycling", StartTime: start.Add(-24 * time.Hour)},
+		{ID: 3, Name: "Old Run", Type: "running", StartTime: start.Add(-48 * time.Hour)},
+	}
+	s, destination, l, events := newTestSyncer(t, activities, []rules.Rule{{Name: "no-bikes", When: rules.Conditions{Types: []string{"cycling"}}, Then: rules.Actions{Skip: true}}})
+	if err := l.Record(ledger.Entry{GarminID: 3, StravaActivityID: 99}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.uploads) != 1 || destination.uploads[0] != "Morning Run" {
+		t.Fatalf("uploads = %v", destination.uploads)
+	}
+	if entry, ok := l.Get(1); !ok || entry.StravaActivityID != 1001 {
+		t.Fatalf("ledger entry = %+v", entry)
+	}
+	var types []EventType
+	for _, e := range *events {
+		types = append(types, e.Type)
+	}
+	expected := []EventType{Listed, Exporting, Uploading, Done, Listed, Skipped, Listed, Skipped}
+	if len(types) != len(expected) {
+		t.Fatalf("events = %v", types)
+	}
+	for i := range types {
+		if types[i] != expected[i] {
+			t.Fatalf("events = %v", types)
+		}
+	}
+	if (*events)[5].Rule != "no-bikes" || (*events)[3].Entry.GarminID != 1 {
+		t.Fatalf("events = %+v", *events)
+	}
+}
+
+func TestUploadMerged(t *testing.T) {
+	activities := []*gc.Activity{
+		{ID: 2, Name: "Race part 2", Type: "running", StartTime: start.Add(10 * time.Minute), Distance: 200, Duration: time.Minute},
+		{ID: 1, Name: "Race", Type: "running", StartTime: start, Distance: 200, Duration: time.Minute},
+	}
+	s, destination, l, _ := newTestSyncer(t, activities, nil)
+	if err := s.UploadMerged(activities); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.uploads) != 1 || destination.uploads[0] != "Race" {
+		t.Fatalf("uploads = %v", destination.uploads)
+	}
+	first, _ := l.Get(1)
+	second, _ := l.Get(2)
+	if first.StravaActivityID != 1001 || second.StravaActivityID != 1001 {
+		t.Fatalf("ledger = %+v", l.Entries())
+	}
+}
+
+func TestFailedEvent(t *testing.T) {
+	s, _, _, events := newTestSyncer(t, nil, nil)
+	if err := s.Upload(&gc.Activity{ID: 7}); err == nil {
+		t.Fatal("expected an export error")
+	}
+	last := (*events)[len(*events)-1]
+	if last.Type != Failed || last.Err == nil || last.Activity.ID != 7 {
+		t.Fatalf("last event = %+v", last)
+	}
+}
+
+func TestUploadStreamsCompressed(t *testing.T) {
+	s, destination, _, _ := newTestSyncer(t, []*gc.Activity{{ID: 1, Name: "Long Ride", StartTime: start}}, nil)
+	s.options.SkipValidation = true
+	s.options.Compress = true
+	if s.needsWholeFile("tcx") {
+		t.Fatal("expected no buffering once validation is skipped")
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.dataTypes) != 1 || destination.dataTypes[0] != "tcx.gz" {
+		t.Fatalf("data types = %v", destination.dataTypes)
+	}
+}
+
+func TestRunPipelined(t *testing.T) {
+	var activities []*gc.Activity
+	for i := 1; i <= 8; i++ {
+		activities = append(activities, &gc.Activity{ID: int64(i), Name: "Run", StartTime: start.Add(-time.Duration(i) * time.Hour)})
+	}
+	s, destination, l, events := newTestSyncer(t, activities, nil)
+	s.options.ExportWorkers = 2
+	s.options.UploadWorkers = 3
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(destination.uploads) != 8 {
+		t.Fatalf("uploads = %v", destination.uploads)
+	}
+	entries := l.Entries()
+	for i, entry := range entries {
+		if entry.GarminID != int64(i+1) {
+			t.Fatalf("ledger out of listing order: %+v", entries)
+		}
+	}
+	var done []int64
+	for _, e := range *events {
+		if e.Type == Done {
+			done = append(done, e.Activity.ID)
+		}
+	}
+	if len(done) != 8 || done[0] != 1 || done[7] != 8 {
+		t.Fatalf("done events = %v", done)
+	}
+}
+
+func TestRunPipelinedStopsAtFailure(t *testing.T) {
+	var activities []*gc.Activity
+	for i := 1; i <= 20; i++ {
+		activities = append(activities, &gc.Activity{ID: int64(i), Name: "Run", StartTime: start.Add(-time.Duration(i) * time.Hour)})
+	}
+	s, destination, l, _ := newTestSyncer(t, activities, nil)
+	s.source.(*fakeGarmin).broken = 2
+	s.options.ExportWorkers = 2
+	if err := s.Run(); err == nil || err.Error() != "export failed" {
+		t.Fatalf("err = %v", err)
+	}
+	if _, ok := l.Get(1); !ok {
+		t.Fatal("activity listed before the failure was not recorded")
+	}
+	if len(destination.uploads) == 20 {
+		t.Fatal("expected listing to stop after the failure")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	activities := []*gc.Activity{{ID: 1, Name: "Morning Run", StartTime: start}}
+	s, destination, l, events := newTestSyncer(t, activities, nil)
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	q, err := retry.Open(filepath.Join(dir, "retry.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.options.Retry = q
+	s.source.(*fakeGarmin).broken = 1
+	if err := s.Run(); err != nil {
+		t.Fatalf("queued failure stopped the run: %v", err)
+	}
+	if item, ok := q.Get(1); !ok || item.Attempts != 1 || item.Parked {
+		t.Fatalf("queued item = %+v", item)
+	}
+	if last := (*events)[len(*events)-1]; last.Type != Queued {
+		t.Fatalf("last event = %+v", last)
+	}
+
+	s.source.(*fakeGarmin).broken = 0
+	if uploaded, err := s.Retry(false); err != nil || uploaded != 1 {
+		t.Fatalf("retry = %d, %v", uploaded, err)
+	}
+	if len(q.Items()) != 0 || !l.Contains(1) || len(destination.uploads) != 1 {
+		t.Fatalf("queue = %+v", q.Items())
+	}
+}
+
+func TestRunSkipsParked(t *testing.T) {
+	for _, workers := range []int{1, 2} {
+		activities := []*gc.Activity{
+			{ID: 1, Name: "Malformed", StartTime: start},
+			{ID: 2, Name: "Older", StartTime: start.Add(-time.Hour)},
+		}
+		s, destination, l, events := newTestSyncer(t, activities, nil)
+		q, err := retry.Open(filepath.Join(t.TempDir(), "retry.json"), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.options.Retry = q
+		s.options.ExportWorkers = workers
+		source := s.source.(*fakeGarmin)
+		source.broken = 1
+		if err := s.Run(); err != nil {
+			t.Fatalf("workers %d: first run: %v", workers, err)
+		}
+		if item, ok := q.Get(1); !ok || !item.Parked {
+			t.Fatalf("workers %d: queued item = %+v", workers, item)
+		}
+		if !l.Contains(2) {
+			t.Fatalf("workers %d: older activity was not uploaded", workers)
+		}
+
+		source.next = 0
+		*events = nil
+		if err := s.Run(); err != nil {
+			t.Fatalf("workers %d: second run: %v", workers, err)
+		}
+		if item, _ := q.Get(1); item.Attempts != 1 {
+			t.Fatalf("workers %d: parked activity was tried again: %+v", workers, item)
+		}
+		if len(destination.uploads) != 1 {
+			t.Fatalf("workers %d: uploads = %v", workers, destination.uploads)
+		}
+		if skipped := (*events)[1]; skipped.Type != Skipped || skipped.Message != "parked: export failed" {
+			t.Fatalf("workers %d: event = %+v", workers, skipped)
+		}
+	}
+}
+
+type brokenLedger struct {
+	ledger.Ledger
+}
+
+func (brokenLedger) Record(entry ledger.Entry) error {
+	return errors.New("disk full")
+}
+
+func TestRecordFailureNotQueued(t *testing.T) {
+	s, destination, l, _ := newTestSyncer(t, []*gc.Activity{{ID: 1, Name: "Morning Run", StartTime: start}}, nil)
+	q, err := retry.Open(filepath.Join(t.TempDir(), "retry.json"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.options.Retry = q
+	s.options.Ledger = brokenLedger{l}
+	for _, workers := range []int{1, 2} {
+		s.source.(*fakeGarmin).next = 0
+		s.options.UploadWorkers = workers
+		if err := s.Run(); err == nil || err.Error() != "disk full" {
+			t.Fatalf("workers %d: err = %v", workers, err)
+		}
+		if items := q.Items(); len(items) != 0 {
+			t.Fatalf("workers %d: queued %+v", workers, items)
+		}
+	}
+	if len(destination.uploads) != 2 {
+		t.Fatalf("uploads = %v", destination.uploads)
+	}
+}
+
+func TestGearFailureSkipsGear(t *testing.T) {
+	activities := []*gc.Activity{{ID: 1, Name: "Morning Run", StartTime: start}}
+	s, destination, l, events := newTestSyncer(t, activities, nil)
+	s.options.Mapper = &metadata.Mapper{Private: true}
+	if err := s.Upload(activities[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !l.Contains(1) {
+		t.Fatal("upload not recorded")
+	}
+	if len(destination.updates) != 1 || destination.updates[0].Private == nil || destination.updates[0].GearID != "" {
+		t.Fatalf("updates = %+v", destination.updates)
+	}
+	if last := (*events)[len(*events)-1]; last.Type != Done || len(last.Notes) != 1 {
+		t.Fatalf("last event = %+v", last)
+	}
+}
+
+func TestUploadStreamsValidated(t *testing.T) {
+	activities := []*gc.Activity{{ID: 1, Name: "Empty", StartTime: start}}
+	s, destination, _, events := newTestSyncer(t, activities, nil)
+	s.source.(*fakeGarmin).invalid = 1
+	err := s.Upload(activities[0])
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(destination.uploads) != 0 {
+		t.Fatalf("invalid export uploaded: %v", destination.uploads)
+	}
+	if last := (*events)[len(*events)-1]; last.Type != Failed || len(last.Notes) == 0 {
+		t.Fatalf("last event = %+v", last)
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if !permanent(&strava.UploadError{UploadID: 3, Message: "malformed file"}) || !permanent(&ValidationError{Errors: 2}) {
+		t.Fatal("rejected uploads should be permanent")
+	}
+	if permanent(errors.New("connection reset")) {
+		t.Fatal("network errors should be retried")
+	}
+}
@@ -0,0 +1,96 @@
+package privacy
+
+import (
+	"fmt"
+
+	"github.com/icalder/gravasync/convert"
+	"github.com/icalder/gravasync/tcx"
+)
+
+// Zone is a circle around a location, such as home, whose GPS points should
+// never leave the machine. Lat and Lon are in degrees and Radius in metres.
+type Zone struct {
+	Name   string
+	Lat    float64
+	Lon    float64
+	Radius float64
+}
+
+// Contains reports whether a point lies within the zone.
+func (z Zone) Contains(lat, lon float64) bool {
+	return convert.Distance(z.Lat, z.Lon, lat, lon) <= z.Radius
+}
+
+// Filter removes positions inside privacy zones from activity files.
+type Filter struct {
+	zones []Zone
+}
+
+// New validates zones.
+func New(zones []Zone) (*Filter, error) {
+	for i, z := range zones {
+		name := z.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		if z.Lat < -90 || z.Lat > 90 || z.Lon < -180 || z.Lon > 180 {
+			return nil, fmt.Errorf("Privacy zone %s: bad location %v,%v", name, z.Lat, z.Lon)
+		}
+		if z.Radius <= 0 {
+			return nil, fmt.Errorf("Privacy zone %s: radius must be positive", name)
+		}
+	}
+	return &Filter{zones: zones}, nil
+}
+
+// Contains reports whether a point lies within any zone.
+func (f *Filter) Contains(lat, lon float64) bool {
+	for _, z := range f.zones {
+		if z.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strip blanks the position of every trackpoint inside a zone, keeping its
+// time, heart rate and other sensor data, and returns how many were changed.
+func (f *Filter) Strip(db *tcx.Database) int {
+	stripped := 0
+	for i := range db.Activities {
+		for j := range db.Activities[i].Laps {
+			track := db.Activities[i].Laps[j].Track
+			for k := range track {
+				p := track[k].Position
+				if p != nil && f.Contains(p.LatitudeDegrees, p.LongitudeDegrees) {
+					track[k].Position = nil
+					stripped++
+				}
+			}
+		}
+	}
+	return stripped
+}
+
+// Apply strips positions from an activity file in any supported format.
+// Files with nothing to strip are returned unchanged; others are re-encoded,
+// which drops GPX points entirely (GPX points must have a position) and
+// anything the converters do not carry over, such as FIT developer fields.
+func (f *Filter) Apply(data []byte, format string) ([]byte, int, error) {
+	if len(f.zones) == 0 {
+		return data, 0, nil
+	}
+	db, err := convert.Read(data, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	stripped := f.Strip(db)
+	if stripped == 0 {
+		return data, 0, nil
+	}
+	data, err = convert.Write(db, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, stripped, nil
+}
@@ -0,0 +1,97 @@
+package privacy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/icalder/gravasync/convert"
+	"github.com/icalder/gravasync/tcx"
+)
+
+var home = Zone{Name: "home", Lat: 53.8, Lon: -1.55, Radius: 200}
+
+// sample reads the shared run, whose first 12 trackpoints are within 200m of
+// home.
+func sample(t *testing.T) *tcx.Database {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "run.tcx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := tcx.ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStrip(t *testing.T) {
+	filter, err := New([]Zone{home})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sample(t)
+	if stripped := filter.Strip(db); stripped != 12 {
+		t.Fatalf("stripped %d points, expected 12", stripped)
+	}
+	points := db.Activities[0].Trackpoints()
+	if points[0].Position != nil || points[11].Position != nil || points[12].Position == nil {
+		t.Fatalf("positions = %v %v %v", points[0].Position, points[11].Position, points[12].Position)
+	}
+	if points[0].HeartRateBpm.Value != 100 || points[0].Time.IsZero() {
+		t.Fatalf("sensor data lost: %+v", points[0])
+	}
+}
+
+func TestApply(t *testing.T) {
+	filter, err := New([]Zone{home})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{convert.TCX, convert.GPX, convert.FIT} {
+		data, err := convert.Write(sample(t), format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered, stripped, err := filter.Apply(data, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if stripped != 12 {
+			t.Fatalf("%s: stripped %d points, expected 12", format, stripped)
+		}
+		db, err := convert.Read(filtered, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		for _, tp := range db.Activities[0].Trackpoints() {
+			if tp.Position != nil && home.Contains(tp.Position.LatitudeDegrees, tp.Position.LongitudeDegrees) {
+				t.Fatalf("%s: position inside zone survived: %+v", format, tp.Position)
+			}
+		}
+	}
+}
+
+func TestApplyUnchanged(t *testing.T) {
+	filter, err := New([]Zone{{Lat: 51.5, Lon: -0.12, Radius: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := convert.Write(sample(t), convert.FIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, stripped, err := filter.Apply(data, convert.FIT)
+	if err != nil || stripped != 0 || &filtered[0] != &data[0] {
+		t.Fatalf("expected the file back untouched, stripped %d, err %v", stripped, err)
+	}
+}
+
+func TestNewRejectsBadZones(t *testing.T) {
+	if _, err := New([]Zone{{Lat: 53.8, Lon: -1.55}}); err == nil {
+		t.Fatal("expected an error for a zone without a radius")
+	}
+	if _, err := New([]Zone{{Lat: 93.8, Lon: -1.55, Radius: 100}}); err == nil {
+		t.Fatal("expected an error for a bad latitude")
+	}
+}
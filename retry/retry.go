@@ -0,0 +1,143 @@
+package retry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+// DefaultMaxAttempts is how many times an upload is tried before it is
+// parked, when Open is given no limit.
+const DefaultMaxAttempts = 5
+
+// Item is an upload that failed, waiting to be tried again.
+type Item struct {
+	Activity gc.Activity `json:"activity"`
+	// Merged are further activities to be joined with Activity into one
+	// upload.
+	Merged        []gc.Activity `json:"merged,omitempty"`
+	Error         string        `json:"error"`
+	Attempts      int           `json:"attempts"`
+	FirstFailedAt time.Time     `json:"firstFailedAt"`
+	LastFailedAt  time.Time     `json:"lastFailedAt"`
+	// Parked items failed permanently, or too many times, and are only
+	// retried on request. Error says why.
+	Parked bool `json:"parked,omitempty"`
+}
+
+// Queue holds failed uploads between runs, keyed by Garmin activity ID.
+// Implementations are safe for concurrent use.
+type Queue interface {
+	// Add records a failed attempt to upload activity, parking it if the
+	// failure is permanent or it has failed too often.
+	Add(activity gc.Activity, merged []gc.Activity, err error, permanent bool) (Item, error)
+	Remove(garminID int64) (bool, error)
+	Get(garminID int64) (Item, bool)
+	// Items returns the queue in the order activities first failed.
+	Items() []Item
+}
+
+type fileQueue struct {
+	mu          sync.Mutex
+	path        string
+	maxAttempts int
+	items       []Item
+}
+
+// Open loads the queue stored at path, creating parent directories as
+// needed. Uploads are parked after maxAttempts failures,
+// DefaultMaxAttempts if zero. The file is rewritten on every change.
+func Open(path string, maxAttempts int) (Queue, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	q := &fileQueue{path: path, maxAttempts: maxAttempts}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &q.items); err != nil {
+		return nil, fmt.Errorf("Retry queue %s: %v", path, err)
+	}
+	return q, nil
+}
+
+func (q *fileQueue) Add(activity gc.Activity, merged []gc.Activity, err error, permanent bool) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	idx := q.find(activity.ID)
+	if idx < 0 {
+		q.items = append(q.items, Item{FirstFailedAt: now})
+		idx = len(q.items) - 1
+	}
+	item := &q.items[idx]
+	item.Activity, item.Merged = activity, merged
+	item.Error = err.Error()
+	item.Attempts++
+	item.LastFailedAt = now
+	item.Parked = permanent || item.Attempts >= q.maxAttempts
+	return *item, q.save()
+}
+
+func (q *fileQueue) Remove(garminID int64) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.find(garminID)
+	if idx < 0 {
+		return false, nil
+	}
+	q.items = append(q.items[:idx], q.items[idx+1:]...)
+	return true, q.save()
+}
+
+func (q *fileQueue) Get(garminID int64) (Item, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := q.find(garminID)
+	if idx < 0 {
+		return Item{}, false
+	}
+	return q.items[idx], true
+}
+
+func (q *fileQueue) Items() []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Item(nil), q.items...)
+}
+
+func (q *fileQueue) find(garminID int64) int {
+	for i, item := range q.items {
+		if item.Activity.ID == garminID {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the queue to a temporary file and renames it into place, so a
+// crash never leaves it half written.
+func (q *fileQueue) save() error {
+	data, err := json.MarshalIndent(q.items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
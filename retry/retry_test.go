@@ -0,0 +1,55 @@
+package retry
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "retry.json")
+	q, err := Open(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Add(gc.Activity{ID: 1}, nil, errors.New("timeout"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Add(gc.Activity{ID: 2}, []gc.Activity{{ID: 3}}, errors.New("malformed file"), true); err != nil {
+		t.Fatal(err)
+	}
+	item, err := q.Add(gc.Activity{ID: 1}, nil, errors.New("connection reset"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Attempts != 2 || !item.Parked || item.Error != "connection reset" || item.LastFailedAt.Before(item.FirstFailedAt) {
+		t.Fatalf("item = %+v", item)
+	}
+
+	q, err = Open(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := q.Items()
+	if len(items) != 2 || items[0].Activity.ID != 1 || items[1].Merged[0].ID != 3 || !items[1].Parked {
+		t.Fatalf("items = %+v", items)
+	}
+	if removed, err := q.Remove(1); err != nil || !removed {
+		t.Fatalf("remove = %v, %v", removed, err)
+	}
+	if _, ok := q.Get(1); ok {
+		t.Fatal("removed item still queued")
+	}
+	if removed, _ := q.Remove(1); removed {
+		t.Fatal("removed twice")
+	}
+}
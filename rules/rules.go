@@ -0,0 +1,225 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+// Rule applies Then to activities matching every condition in When.
+type Rule struct {
+	Name string
+	When Conditions
+	Then Actions
+}
+
+// Conditions on an activity. Zero values are not checked. Distances are in
+// metres and times of day are "HH:MM" in the activity's own zone, or the local
+// zone if that is not known.
+type Conditions struct {
+	Types       []string
+	MinDistance float64
+	MaxDistance float64
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	// Name and Device are regular expressions.
+	Name   string
+	Device string
+	// Weekdays, After and Before, e.g. "07:00", are checked against the start
+	// time in the activity's own time zone.
+	Weekdays []string
+	After    string
+	Before   string
+}
+
+// Actions to take for a matching activity.
+type Actions struct {
+	Skip         bool
+	Private      bool
+	HideFromHome bool
+	Commute      bool
+	// Gear is a Strava gear ID.
+	Gear string
+	// Rename is a name template, see package naming.
+	Rename string
+	// Format is the file format to export and upload: tcx, gpx or fit.
+	Format string
+}
+
+// Formats are the supported values of Actions.Format.
+var Formats = []string{"tcx", "gpx", "fit"}
+
+// Evaluation explains the outcome of one rule for an activity.
+type Evaluation struct {
+	Rule    *Rule
+	Matched bool
+	// Reason names the first condition that failed.
+	Reason string
+}
+
+// Engine evaluates rules in order; the first matching rule wins.
+type Engine struct {
+	rules []compiledRule
+	// location is used for activities whose own time zone is not known.
+	location *time.Location
+}
+
+type compiledRule struct {
+	rule     *Rule
+	types    map[string]bool
+	name     *regexp.Regexp
+	device   *regexp.Regexp
+	weekdays map[time.Weekday]bool
+	after    int
+	before   int
+}
+
+// New validates and compiles rules.
+func New(rules []Rule) (*Engine, error) {
+	e := &Engine{location: time.Local}
+	for i := range rules {
+		compiled, err := compile(&rules[i])
+		if err != nil {
+			name := rules[i].Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("Rule %s: %v", name, err)
+		}
+		if compiled.rule.Name == "" {
+			compiled.rule.Name = fmt.Sprintf("#%d", i+1)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+func compile(rule *Rule) (compiledRule, error) {
+	c := compiledRule{rule: rule, after: -1, before: -1}
+	var err error
+	if len(rule.When.Types) > 0 {
+		c.types = map[string]bool{}
+		for _, t := range rule.When.Types {
+			c.types[strings.ToLower(t)] = true
+		}
+	}
+	if rule.When.Name != "" {
+		if c.name, err = regexp.Compile(rule.When.Name); err != nil {
+			return c, err
+		}
+	}
+	if rule.When.Device != "" {
+		if c.device, err = regexp.Compile(rule.When.Device); err != nil {
+			return c, err
+		}
+	}
+	if len(rule.When.Weekdays) > 0 {
+		c.weekdays = map[time.Weekday]bool{}
+		for _, day := range rule.When.Weekdays {
+			weekday, err := parseWeekday(day)
+			if err != nil {
+				return c, err
+			}
+			c.weekdays[weekday] = true
+		}
+	}
+	if rule.When.After != "" {
+		if c.after, err = parseTimeOfDay(rule.When.After); err != nil {
+			return c, err
+		}
+	}
+	if rule.When.Before != "" {
+		if c.before, err = parseTimeOfDay(rule.When.Before); err != nil {
+			return c, err
+		}
+	}
+	if format := strings.ToLower(rule.Then.Format); format != "" {
+		valid := false
+		for _, f := range Formats {
+			valid = valid || f == format
+		}
+		if !valid {
+			return c, fmt.Errorf("unknown format %q", rule.Then.Format)
+		}
+		rule.Then.Format = format
+	}
+	return c, nil
+}
+
+// Match returns the first rule matching activity.
+func (e *Engine) Match(activity gc.Activity) (*Rule, bool) {
+	for _, c := range e.rules {
+		if c.check(activity, e.location) == "" {
+			return c.rule, true
+		}
+	}
+	return nil, false
+}
+
+// Explain evaluates every rule against activity, stopping after the first
+// match.
+func (e *Engine) Explain(activity gc.Activity) []Evaluation {
+	var result []Evaluation
+	for _, c := range e.rules {
+		reason := c.check(activity, e.location)
+		result = append(result, Evaluation{Rule: c.rule, Matched: reason == "", Reason: reason})
+		if reason == "" {
+			break
+		}
+	}
+	return result
+}
+
+// check returns why activity fails the rule's conditions, or "" on a match.
+func (c compiledRule) check(activity gc.Activity, location *time.Location) string {
+	when := c.rule.When
+	start := activity.LocalStart(location)
+	switch {
+	case c.types != nil && !c.types[strings.ToLower(activity.Type)]:
+		return fmt.Sprintf("type %q not in %v", activity.Type, when.Types)
+	case when.MinDistance > 0 && activity.Distance < when.MinDistance:
+		return fmt.Sprintf("distance %.0fm < %.0fm", activity.Distance, when.MinDistance)
+	case when.MaxDistance > 0 && activity.Distance > when.MaxDistance:
+		return fmt.Sprintf("distance %.0fm > %.0fm", activity.Distance, when.MaxDistance)
+	case when.MinDuration > 0 && activity.Duration < when.MinDuration:
+		return fmt.Sprintf("duration %v < %v", activity.Duration, when.MinDuration)
+	case when.MaxDuration > 0 && activity.Duration > when.MaxDuration:
+		return fmt.Sprintf("duration %v > %v", activity.Duration, when.MaxDuration)
+	case c.name != nil && !c.name.MatchString(activity.Name):
+		return fmt.Sprintf("name %q does not match %q", activity.Name, when.Name)
+	case c.device != nil && !c.device.MatchString(activity.Device):
+		return fmt.Sprintf("device %q does not match %q", activity.Device, when.Device)
+	case c.weekdays != nil && !c.weekdays[start.Weekday()]:
+		return fmt.Sprintf("%s not in %v", start.Weekday(), when.Weekdays)
+	case c.after >= 0 && minuteOfDay(start) < c.after:
+		return fmt.Sprintf("start %s before %s", start.Format("15:04"), when.After)
+	case c.before >= 0 && minuteOfDay(start) >= c.before:
+		return fmt.Sprintf("start %s not before %s", start.Format("15:04"), when.Before)
+	}
+	return ""
+}
+
+func minuteOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q, expected HH:MM", s)
+	}
+	return minuteOfDay(t), nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := day.String()
+		if strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
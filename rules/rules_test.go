@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/gc"
+)
+
+var commute = gc.Activity{
+	Name:      "Leeds Cycling",
+	Type:      "cycling",
+	Device:    "Edge 520",
+	Distance:  8000,
+	Duration:  25 * time.Minute,
+	StartTime: time.Date(2017, 12, 29, 8, 5, 0, 0, time.UTC), // Friday
+}
+
+func newEngine(t *testing.T, rules []Rule) *Engine {
+	e, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.location = time.UTC
+	return e
+}
+
+func TestMatch(t *testing.T) {
+	e := newEngine(t, []Rule{
+		{Name: "treadmill", When: Conditions{Types: []string{"treadmill_running"}}, Then: Actions{Skip: true}},
+		{
+			Name: "commute",
+			When: Conditions{
+				Types:       []string{"Cycling"},
+				MaxDistance: 15000,
+				Device:      "^Edge",
+				Weekdays:    []string{"mon", "tue", "wed", "thu", "fri"},
+				After:       "07:00",
+				Before:      "09:30",
+			},
+			Then: Actions{Commute: true, Gear: "b1234", Format: "FIT"},
+		},
+	})
+	rule, ok := e.Match(commute)
+	if !ok || rule.Name != "commute" {
+		t.Fatalf("rule = %v", rule)
+	}
+	if rule.Then.Format != "fit" {
+		t.Fatalf("format = %q", rule.Then.Format)
+	}
+
+	weekend := commute
+	weekend.StartTime = weekend.StartTime.AddDate(0, 0, 1)
+	if rule, ok := e.Match(weekend); ok {
+		t.Fatalf("rule = %v", rule)
+	}
+	evaluations := e.Explain(weekend)
+	if len(evaluations) != 2 || !strings.Contains(evaluations[1].Reason, "Saturday") {
+		t.Fatalf("evaluations = %+v", evaluations)
+	}
+}
+
+func TestMatchActivityZone(t *testing.T) {
+	e := newEngine(t, []Rule{{Name: "morning", When: Conditions{Weekdays: []string{"fri"}, After: "07:00", Before: "09:30"}}})
+	abroad := commute
+	abroad.StartTime = time.Date(2017, 12, 29, 2, 35, 0, 0, time.UTC)
+	abroad.ZoneAbbr, abroad.UTCOffset = "IST", 19800
+	if _, ok := e.Match(abroad); !ok {
+		t.Fatalf("evaluations = %+v", e.Explain(abroad))
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{When: Conditions{Name: "("}},
+		{When: Conditions{Weekdays: []string{"someday"}}},
+		{When: Conditions{After: "25:00"}},
+		{Then: Actions{Format: "csv"}},
+	} {
+		if _, err := New([]Rule{rule}); err == nil {
+			t.Fatalf("expected error for %+v", rule)
+		}
+	}
+}
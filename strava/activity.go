@@ -14,3 +14,56 @@ type Activity struct {
 func (act Activity) String() string {
 	return fmt.Sprintf("%d %s %v", act.ID, act.Name, act.StartDate)
 }
+
+const activityPageURLStr = "https://www.strava.com/activities/%d"
+
+// ActivityURL returns the web page of a Strava activity.
+func ActivityURL(id int64) string {
+	return fmt.Sprintf(activityPageURLStr, id)
+}
+
+// Upload is the processing state of an uploaded activity file. ActivityID is
+// set once Strava has finished processing it.
+type Upload struct {
+	ID         int64  `json:"id"`
+	ActivityID int64  `json:"activity_id"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+}
+
+func (u Upload) done() bool {
+	return u.ActivityID != 0 || u.Error != ""
+}
+
+// UploadError is Strava rejecting an uploaded file, for example as malformed
+// or a duplicate. Uploading the same file again fails the same way.
+type UploadError struct {
+	// UploadID is zero if the file was rejected before processing started.
+	UploadID int64
+	Message  string
+}
+
+func (e *UploadError) Error() string {
+	if e.UploadID == 0 {
+		return fmt.Sprintf("Upload activity: %s", e.Message)
+	}
+	return fmt.Sprintf("Upload %d: %s", e.UploadID, e.Message)
+}
+
+// ActivityUpdate holds the editable fields of an activity. Empty strings and
+// nil flags are left unchanged.
+type ActivityUpdate struct {
+	Name         string `json:"name,omitempty"`
+	SportType    string `json:"sport_type,omitempty"`
+	Description  string `json:"description,omitempty"`
+	GearID       string `json:"gear_id,omitempty"`
+	Commute      *bool  `json:"commute,omitempty"`
+	Trainer      *bool  `json:"trainer,omitempty"`
+	HideFromHome *bool  `json:"hide_from_home,omitempty"`
+	Private      *bool  `json:"private,omitempty"`
+}
+
+// IsEmpty reports whether the update would change nothing.
+func (u ActivityUpdate) IsEmpty() bool {
+	return u == ActivityUpdate{}
+}
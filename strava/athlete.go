@@ -0,0 +1,16 @@
+package strava
+
+import "fmt"
+
+type Athlete struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	City      string `json:"city"`
+	Country   string `json:"country"`
+}
+
+func (a Athlete) String() string {
+	return fmt.Sprintf("%d %s %s (%s)", a.ID, a.FirstName, a.LastName, a.Username)
+}
@@ -0,0 +1,418 @@
+package strava
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const oauthAuthorizeURLStr = "https://www.strava.com/oauth/authorize"
+const oauthTokenExchangeURLStr = "https://www.strava.com/oauth/token"
+const defaultOAuthPort = 8001
+const defaultOAuthTimeout = 5 * time.Minute
+
+// DefaultScopes are requested when AuthOptions.Scopes is empty: uploading
+// needs activity:write and matching existing activities needs
+// activity:read_all.
+var DefaultScopes = []string{"read", "activity:write", "activity:read_all"}
+
+// AuthOptions configures the OAuth authorisation flow.
+type AuthOptions struct {
+	ClientID     string
+	ClientSecret string
+	// Port is where the local callback server listens. If zero it is taken
+	// from RedirectURL, or is 8001.
+	Port int
+	// RedirectURL must match the Strava application's callback domain and
+	// defaults to http://localhost:<Port>/callback. Its path defaults to
+	// /callback.
+	RedirectURL string
+	Scopes      []string
+	// Timeout bounds the wait for the user to approve access, 5 minutes if
+	// zero.
+	Timeout time.Duration
+	// Headless skips the callback server and browser; the user pastes the
+	// redirected URL instead. Useful over SSH.
+	Headless bool
+	// NoBrowser stops the authorisation URL being opened automatically.
+	NoBrowser bool
+	// In is read for the pasted URL when Headless is set, and must then be
+	// provided. Out receives the authorisation URL and instructions; nothing
+	// is written if it is nil.
+	In  io.Reader
+	Out io.Writer
+}
+
+// Token is the result of a successful authorisation.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+	Scopes       []string
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
+
+type callbackResult struct {
+	code   string
+	scopes []string
+	err    error
+}
+
+// setDefaults fills in unset options. An explicit redirect URL gets the path
+// /callback if it has none, and sets the callback server's port.
+func (o *AuthOptions) setDefaults() error {
+	if o.RedirectURL == "" {
+		if o.Port == 0 {
+			o.Port = defaultOAuthPort
+		}
+		o.RedirectURL = fmt.Sprintf("http://localhost:%d/callback", o.Port)
+	} else if err := o.checkRedirectURL(); err != nil {
+		return err
+	}
+	if len(o.Scopes) == 0 {
+		o.Scopes = DefaultScopes
+	}
+	if o.Timeout == 0 {
+		o.Timeout = defaultOAuthTimeout
+	}
+	if o.Out == nil {
+		o.Out = ioutil.Discard
+	}
+	return nil
+}
+
+// checkRedirectURL defaults the redirect URL's path and takes Port from it,
+// rejecting a Port the browser would not be redirected to.
+func (o *AuthOptions) checkRedirectURL() error {
+	redirectURL, err := url.Parse(o.RedirectURL)
+	if err != nil {
+		return fmt.Errorf("Authorise: bad redirect URL: %v", err)
+	}
+	if redirectURL.Path == "" {
+		redirectURL.Path = "/callback"
+		o.RedirectURL = redirectURL.String()
+	}
+	port := 80
+	if redirectURL.Scheme == "https" {
+		port = 443
+	}
+	if redirectURL.Port() != "" {
+		if port, err = strconv.Atoi(redirectURL.Port()); err != nil {
+			return fmt.Errorf("Authorise: bad port in redirect URL %s", o.RedirectURL)
+		}
+	}
+	if o.Port == 0 {
+		o.Port = port
+	} else if o.Port != port && !o.Headless {
+		return fmt.Errorf("Authorise: redirect URL %s does not use the callback port %d", o.RedirectURL, o.Port)
+	}
+	return nil
+}
+
+func (o AuthOptions) authorizeURL(state string) string {
+	params := url.Values{}
+	params.Set("client_id", o.ClientID)
+	params.Set("response_type", "code")
+	params.Set("redirect_uri", o.RedirectURL)
+	params.Set("approval_prompt", "auto")
+	params.Set("scope", strings.Join(o.Scopes, ","))
+	params.Set("state", state)
+	return oauthAuthorizeURLStr + "?" + params.Encode()
+}
+
+func (s *stravaImpl) Authorise(options AuthOptions) error {
+	if err := options.setDefaults(); err != nil {
+		return err
+	}
+	if options.Headless && options.In == nil {
+		return errors.New("Authorise: headless authorisation needs AuthOptions.In")
+	}
+	state, err := randomState()
+	if err != nil {
+		return err
+	}
+	authURL := options.authorizeURL(state)
+	ctx, cancel := context.WithTimeout(context.Background(), options.Timeout)
+	defer cancel()
+
+	var result callbackResult
+	if options.Headless {
+		result = pasteCallback(ctx, options, authURL, state)
+	} else {
+		result = serveCallback(ctx, options, authURL, state)
+	}
+	if result.err != nil {
+		return result.err
+	}
+	if err := checkScopes(result.scopes, options.Scopes); err != nil {
+		return err
+	}
+	token, err := s.tokenExchange(result.code, options.ClientID, options.ClientSecret)
+	if err != nil {
+		return err
+	}
+	token.Scopes = result.scopes
+	s.setToken(token)
+	return nil
+}
+
+// serveCallback runs a local server for the OAuth redirect until a callback
+// arrives or ctx expires, then shuts the server down.
+func serveCallback(ctx context.Context, options AuthOptions, authURL, state string) callbackResult {
+	redirectURL, err := url.Parse(options.RedirectURL)
+	if err != nil {
+		return callbackResult{err: fmt.Errorf("Authorise: bad redirect URL: %v", err)}
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", options.Port))
+	if err != nil {
+		return callbackResult{err: fmt.Errorf("Authorise: %v", err)}
+	}
+	results := make(chan callbackResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(redirectURL.Path, func(w http.ResponseWriter, r *http.Request) {
+		result := parseCallback(r.URL.Query(), state)
+		if result.err != nil {
+			http.Error(w, result.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintf(w, "Strava authorisation complete, you can close this window.")
+		}
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(options.Out, "Visit this URL in a browser: %s\n", authURL)
+	if !options.NoBrowser {
+		if err := openBrowser(authURL); err != nil {
+			fmt.Fprintf(options.Out, "Could not open a browser: %v\n", err)
+		}
+	}
+	select {
+	case result := <-results:
+		return result
+	case <-ctx.Done():
+		return callbackResult{err: errors.New("Authorise: timed out waiting for the Strava callback")}
+	}
+}
+
+// pasteCallback asks the user to paste the URL Strava redirected to, for when
+// the browser cannot reach a server on this machine.
+func pasteCallback(ctx context.Context, options AuthOptions, authURL, state string) callbackResult {
+	fmt.Fprintf(options.Out, "Visit this URL in a browser: %s\n", authURL)
+	fmt.Fprintln(options.Out, "After approving, the browser will fail to load the redirect page.")
+	fmt.Fprintln(options.Out, "Paste its full URL here:")
+	lines := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(options.In)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines <- line
+				return
+			}
+		}
+		close(lines)
+	}()
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			return callbackResult{err: errors.New("Authorise: no authorisation code entered")}
+		}
+		if !strings.Contains(line, "code=") {
+			return callbackResult{err: errors.New("Authorise: paste the full URL the browser was redirected to, not just the code")}
+		}
+		if idx := strings.Index(line, "?"); idx >= 0 {
+			line = line[idx+1:]
+		}
+		query, err := url.ParseQuery(line)
+		if err != nil {
+			return callbackResult{err: fmt.Errorf("Authorise: cannot parse pasted URL: %v", err)}
+		}
+		return parseCallback(query, state)
+	case <-ctx.Done():
+		return callbackResult{err: errors.New("Authorise: timed out waiting for the authorisation code")}
+	}
+}
+
+func parseCallback(query url.Values, state string) callbackResult {
+	if errorCode := query.Get("error"); errorCode != "" {
+		if errorCode == "access_denied" {
+			return callbackResult{err: errors.New("Authorise: access was denied in Strava")}
+		}
+		return callbackResult{err: fmt.Errorf("Authorise: Strava returned error %q", errorCode)}
+	}
+	if query.Get("state") != state {
+		return callbackResult{err: errors.New("Authorise: state mismatch in callback, please try again")}
+	}
+	code := query.Get("code")
+	if code == "" {
+		return callbackResult{err: errors.New("Authorise: callback did not include a code")}
+	}
+	scopes := []string{}
+	for _, scope := range strings.Split(query.Get("scope"), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return callbackResult{code: code, scopes: scopes}
+}
+
+// checkScopes reports the required scopes the user unticked on the Strava
+// approval page.
+func checkScopes(granted, required []string) error {
+	grantedSet := map[string]bool{}
+	for _, scope := range granted {
+		grantedSet[scope] = true
+	}
+	var missing []string
+	for _, scope := range required {
+		if !grantedSet[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Authorise: required scopes not granted: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+func (s *stravaImpl) tokenExchange(code, clientID, clientSecret string) (Token, error) {
+	form := url.Values{}
+	form.Set("client_id", clientID)
+	form.Set("client_secret", clientSecret)
+	form.Set("code", code)
+	form.Set("grant_type", "authorization_code")
+	token, err := s.requestToken(form)
+	if err != nil {
+		return Token{}, fmt.Errorf("Token exchange: %v", err)
+	}
+	return token, nil
+}
+
+// RefreshOptions let a client renew its access token shortly before it
+// expires, which Strava tokens do after about six hours.
+type RefreshOptions struct {
+	ClientID     string
+	ClientSecret string
+	// Refreshed, if set, is called with each new token, e.g. to save it.
+	Refreshed func(Token) error
+}
+
+// tokenRefreshMargin is how long before it expires a token is refreshed.
+const tokenRefreshMargin = 5 * time.Minute
+
+func (s *stravaImpl) SetRefresh(options RefreshOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh = &options
+}
+
+// currentAccessToken returns the access token, first refreshing it if it
+// is about to expire and the client can refresh it.
+func (s *stravaImpl) currentAccessToken() (string, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	s.mu.RLock()
+	token, refresh := s.token, s.refresh
+	s.mu.RUnlock()
+	if refresh == nil || token.RefreshToken == "" || token.ExpiresAt.IsZero() || time.Until(token.ExpiresAt) > tokenRefreshMargin {
+		return token.AccessToken, nil
+	}
+	form := url.Values{}
+	form.Set("client_id", refresh.ClientID)
+	form.Set("client_secret", refresh.ClientSecret)
+	form.Set("refresh_token", token.RefreshToken)
+	form.Set("grant_type", "refresh_token")
+	refreshed, err := s.requestToken(form)
+	if err != nil {
+		return "", fmt.Errorf("Token refresh: %v", err)
+	}
+	refreshed.Scopes = token.Scopes
+	s.setToken(refreshed)
+	slog.Debug("Refreshed Strava access token", "expiresAt", refreshed.ExpiresAt)
+	if refresh.Refreshed != nil {
+		if err := refresh.Refreshed(refreshed); err != nil {
+			return "", err
+		}
+	}
+	return refreshed.AccessToken, nil
+}
+
+// requestToken posts form to the token endpoint.
+func (s *stravaImpl) requestToken(form url.Values) (Token, error) {
+	request, err := http.NewRequest("POST", oauthTokenExchangeURLStr,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(request)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return Token{}, fmt.Errorf("unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var tokenResponse tokenResponse
+	err = decoder.Decode(&tokenResponse)
+	if err != nil {
+		return Token{}, err
+	}
+	token := Token{AccessToken: tokenResponse.AccessToken, RefreshToken: tokenResponse.RefreshToken}
+	if tokenResponse.ExpiresAt != 0 {
+		token.ExpiresAt = time.Unix(tokenResponse.ExpiresAt, 0)
+	}
+	return token, nil
+}
@@ -0,0 +1,107 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCallback(t *testing.T) {
+	query, _ := url.ParseQuery("state=abc&code=xyz&scope=read,activity:write,activity:read_all")
+	result := parseCallback(query, "abc")
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+	if result.code != "xyz" || len(result.scopes) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	if err := checkScopes(result.scopes, DefaultScopes); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := parseCallback(query, "other"); result.err == nil {
+		t.Fatal("expected state mismatch error")
+	}
+	query, _ = url.ParseQuery("state=abc&error=access_denied")
+	if result := parseCallback(query, "abc"); result.err == nil || !strings.Contains(result.err.Error(), "denied") {
+		t.Fatalf("err = %v", result.err)
+	}
+}
+
+func TestCheckScopes(t *testing.T) {
+	err := checkScopes([]string{"read"}, DefaultScopes)
+	if err == nil || !strings.Contains(err.Error(), "activity:write") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestAuthorizeURL(t *testing.T) {
+	options := AuthOptions{ClientID: "123", Port: 9000}
+	if err := options.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(options.authorizeURL("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	if query.Get("redirect_uri") != "http://localhost:9000/callback" {
+		t.Fatalf("redirect_uri = %s", query.Get("redirect_uri"))
+	}
+	if query.Get("scope") != "read,activity:write,activity:read_all" || query.Get("state") != "abc" {
+		t.Fatalf("query = %v", query)
+	}
+}
+
+func TestServeCallback(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	options := AuthOptions{RedirectURL: fmt.Sprintf("http://localhost:%d", port), NoBrowser: true}
+	if err := options.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if options.Port != port || options.RedirectURL != fmt.Sprintf("http://localhost:%d/callback", port) {
+		t.Fatalf("options = %+v", options)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	results := make(chan callbackResult, 1)
+	go func() { results <- serveCallback(ctx, options, "", "abc") }()
+	callback := options.RedirectURL + "?state=abc&code=xyz&scope=read"
+	for {
+		resp, err := http.Get(callback)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if result := <-results; result.err != nil || result.code != "xyz" {
+		t.Fatalf("result = %+v", result)
+	}
+
+	options = AuthOptions{RedirectURL: "http://localhost:9000/callback", Port: 9001}
+	if err := options.setDefaults(); err == nil {
+		t.Fatal("expected a port mismatch error")
+	}
+}
+
+func TestPasteCallbackNeedsURL(t *testing.T) {
+	options := AuthOptions{In: strings.NewReader("xyz\n")}
+	if err := options.setDefaults(); err != nil {
+		t.Fatal(err)
+	}
+	if result := pasteCallback(context.Background(), options, "", "abc"); result.err == nil {
+		t.Fatalf("bare code accepted: %+v", result)
+	}
+}
@@ -0,0 +1,145 @@
+package strava
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is Strava's API usage as reported in response headers: requests
+// allowed and used in the current 15 minute window and the current day.
+type RateLimit struct {
+	ShortLimit int
+	ShortUsage int
+	LongLimit  int
+	LongUsage  int
+	UpdatedAt  time.Time
+}
+
+const shortWindow = 15 * time.Minute
+
+// rateBudget holds requests back once the reported usage reaches Strava's
+// limits, until the window resets: every quarter hour for the short limit
+// and at midnight UTC for the daily one. Each request counts against the
+// budget as it is sent, so concurrent callers cannot overshoot between
+// responses.
+type rateBudget struct {
+	mu    sync.Mutex
+	limit RateLimit
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newRateBudget() *rateBudget {
+	return &rateBudget{now: time.Now, sleep: time.Sleep}
+}
+
+func (b *rateBudget) current() RateLimit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+// wait blocks until a request may be sent and counts it.
+func (b *rateBudget) wait() {
+	for {
+		b.mu.Lock()
+		delay := b.take(b.now())
+		b.mu.Unlock()
+		if delay <= 0 {
+			return
+		}
+		slog.Debug("Waiting for the Strava rate limit to reset", "delay", delay)
+		b.sleep(delay)
+	}
+}
+
+// take counts a request if the budget allows, otherwise returns how long to
+// wait for the exhausted window to reset.
+func (b *rateBudget) take(now time.Time) time.Duration {
+	if b.limit.UpdatedAt.IsZero() {
+		return 0
+	}
+	b.limit = b.limit.at(now)
+	if delay := b.limit.Wait(now, 1); delay > 0 {
+		return delay
+	}
+	b.limit.ShortUsage++
+	b.limit.LongUsage++
+	return 0
+}
+
+// at returns the usage as of now, with windows that have since reset
+// cleared.
+func (l RateLimit) at(now time.Time) RateLimit {
+	if l.UpdatedAt.IsZero() {
+		return l
+	}
+	if !now.Truncate(shortWindow).Equal(l.UpdatedAt.Truncate(shortWindow)) {
+		l.ShortUsage = 0
+	}
+	if !sameUTCDay(now, l.UpdatedAt) {
+		l.LongUsage = 0
+	}
+	l.UpdatedAt = now
+	return l
+}
+
+// Wait returns how long from now until the given number of requests can be
+// made without exceeding either limit, zero if they can be made now. Usage
+// not yet reported by Strava counts as zero.
+func (l RateLimit) Wait(now time.Time, requests int) time.Duration {
+	l = l.at(now)
+	if l.LongLimit > 0 && l.LongUsage+requests > l.LongLimit {
+		y, m, d := now.UTC().Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now)
+	}
+	if l.ShortLimit > 0 && l.ShortUsage+requests > l.ShortLimit {
+		return now.Truncate(shortWindow).Add(shortWindow).Sub(now)
+	}
+	return 0
+}
+
+// update records the usage reported with a response. A 429 response marks
+// the short window as used up even if the headers say otherwise.
+func (b *rateBudget) update(header http.Header, exhausted bool) {
+	limits := parseRateHeader(header.Get("X-RateLimit-Limit"))
+	usage := parseRateHeader(header.Get("X-RateLimit-Usage"))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if limits != nil && usage != nil {
+		b.limit.ShortLimit, b.limit.LongLimit = limits[0], limits[1]
+		b.limit.ShortUsage, b.limit.LongUsage = usage[0], usage[1]
+		b.limit.UpdatedAt = b.now()
+	}
+	if exhausted && b.limit.ShortLimit > 0 {
+		b.limit.ShortUsage = b.limit.ShortLimit
+		b.limit.UpdatedAt = b.now()
+	}
+}
+
+// parseRateHeader parses a "short,long" header value.
+func parseRateHeader(value string) []int {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return nil
+	}
+	result := make([]int, 2)
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil
+		}
+		result[i] = n
+	}
+	return result
+}
+
+func sameUTCDay(a, b time.Time) bool {
+	ay, am, ad := a.UTC().Date()
+	by, bm, bd := b.UTC().Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,67 @@
+package strava
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateBudget(t *testing.T) {
+	now := time.Date(2018, 1, 2, 10, 14, 0, 0, time.UTC)
+	var slept []time.Duration
+	b := &rateBudget{
+		now:   func() time.Time { return now },
+		sleep: func(d time.Duration) { slept = append(slept, d); now = now.Add(d) },
+	}
+	b.wait()
+	if len(slept) != 0 {
+		t.Fatal("expected no wait before any usage is known")
+	}
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "600,30000")
+	header.Set("X-RateLimit-Usage", "598,1000")
+	b.update(header, false)
+	b.wait()
+	b.wait()
+	if len(slept) != 0 {
+		t.Fatalf("slept %v with budget left", slept)
+	}
+	b.wait()
+	if len(slept) != 1 || slept[0] != time.Minute {
+		t.Fatalf("slept %v, expected a minute until the quarter hour", slept)
+	}
+	if l := b.current(); l.ShortUsage != 1 || l.LongUsage != 1003 {
+		t.Fatalf("after reset = %+v", l)
+	}
+
+	header.Set("X-RateLimit-Usage", "10,30000")
+	b.update(header, false)
+	b.wait()
+	if last := slept[len(slept)-1]; last != 13*time.Hour+45*time.Minute {
+		t.Fatalf("slept %v, expected until midnight", last)
+	}
+
+	b.update(http.Header{}, true)
+	if l := b.current(); l.ShortUsage != l.ShortLimit {
+		t.Fatalf("429 did not exhaust the window: %+v", l)
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Date(2018, 1, 2, 10, 14, 0, 0, time.UTC)
+	l := RateLimit{ShortLimit: 600, ShortUsage: 595, LongLimit: 30000, LongUsage: 1000, UpdatedAt: now}
+	if wait := l.Wait(now, 5); wait != 0 {
+		t.Fatalf("wait = %v with budget left", wait)
+	}
+	if wait := l.Wait(now, 6); wait != time.Minute {
+		t.Fatalf("wait = %v, expected a minute until the quarter hour", wait)
+	}
+	if wait := l.Wait(now.Add(time.Minute), 6); wait != 0 {
+		t.Fatalf("wait = %v after the quarter hour", wait)
+	}
+	l.LongUsage = 29999
+	if wait := l.Wait(now, 2); wait != 13*time.Hour+46*time.Minute {
+		t.Fatalf("wait = %v, expected until midnight", wait)
+	}
+}
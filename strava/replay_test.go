@@ -0,0 +1,86 @@
+package strava
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/httplog"
+)
+
+func replayClient(t *testing.T, cassette string) (*stravaImpl, *httplog.Replayer) {
+	replayer, err := httplog.NewReplayer(filepath.Join("testdata", cassette))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &stravaImpl{client: &http.Client{Transport: replayer}, budget: newRateBudget(), pollInterval: time.Millisecond}, replayer
+}
+
+func TestUploadReplay(t *testing.T) {
+	s, replayer := replayClient(t, "upload.json")
+	upload, err := s.Import("Morning Run", false, "tcx", strings.NewReader("<TrainingCenterDatabase/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.ID != 1325452811 || upload.ActivityID != 0 || upload.done() {
+		t.Fatalf("upload = %+v", upload)
+	}
+	if upload, err = s.WaitForUpload(upload, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if upload.ActivityID != 1346981207 || replayer.Pending() != 0 {
+		t.Fatalf("upload = %+v", upload)
+	}
+	if limit := s.RateLimit(); limit.ShortLimit != 600 || limit.LongUsage != 120 {
+		t.Fatalf("rate limit = %+v", limit)
+	}
+}
+
+func TestMalformedUploadReplay(t *testing.T) {
+	s, _ := replayClient(t, "upload-malformed.json")
+	upload, err := s.Import("Morning Run", false, "tcx", strings.NewReader("<TrainingCenterDatabase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.WaitForUpload(upload, time.Second)
+	var uploadErr *UploadError
+	if !errors.As(err, &uploadErr) || uploadErr.UploadID != 1325452812 || uploadErr.Message != "Improperly formatted data." {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+func TestTokenRefreshReplay(t *testing.T) {
+	s, replayer := replayClient(t, "token-refresh.json")
+	var authorization string
+	s.client.Transport = roundTripFunc(func(request *http.Request) (*http.Response, error) {
+		authorization = request.Header.Get("Authorization")
+		return replayer.RoundTrip(request)
+	})
+	s.SetToken(Token{AccessToken: "old-access", RefreshToken: "old-refresh", ExpiresAt: time.Now().Add(time.Minute), Scopes: DefaultScopes})
+	var saved Token
+	s.SetRefresh(RefreshOptions{ClientID: "123", ClientSecret: "secret", Refreshed: func(token Token) error {
+		saved = token
+		return nil
+	}})
+	if _, err := s.Athlete(); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer new-access" || replayer.Pending() != 0 {
+		t.Fatalf("authorization = %q", authorization)
+	}
+	if saved.RefreshToken != "new-refresh" || saved.ExpiresAt.Unix() != 1893456000 || len(saved.Scopes) != 3 {
+		t.Fatalf("saved = %+v", saved)
+	}
+	if token := s.Token(); token.AccessToken != "new-access" {
+		t.Fatalf("token = %+v", token)
+	}
+}
@@ -2,116 +2,131 @@ package strava
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"strings"
+	"sync"
 	"time"
 )
 
 type Strava interface {
 	SetAccessToken(accessToken string)
-	Authorise(clientID, clientSecret string) error
-	ImportTCX(activityName string, private bool, tcxBytes []byte) error
+	AccessToken() string
+	// SetToken sets the access token with its refresh token and expiry,
+	// which SetRefresh needs.
+	SetToken(token Token)
+	Token() Token
+	SetRefresh(options RefreshOptions)
+	Authorise(options AuthOptions) error
+	ImportTCX(activityName string, private bool, tcx io.Reader) (*Upload, error)
+	Import(activityName string, private bool, dataType string, data io.Reader) (*Upload, error)
+	WaitForUpload(upload *Upload, timeout time.Duration) (*Upload, error)
+	UpdateActivity(activityID int64, update ActivityUpdate) error
 	TopActivity() (*Activity, error)
+	Athlete() (*Athlete, error)
+	Deauthorize() error
+	RateLimit() RateLimit
 }
 
 const userAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:57.0) Gecko/20100101 Firefox/57.0"
-const oauthAuthorizeURLStr = "https://www.strava.com/oauth/authorize?client_id=%s&response_type=code&redirect_uri=http://localhost:8001/callback&scope=write"
-const oauthTokenExchangeURLStr = "https://www.strava.com/oauth/token"
+const athleteURLStr = "https://www.strava.com/api/v3/athlete"
+const deauthorizeURLStr = "https://www.strava.com/oauth/deauthorize"
 const activitiesURLStr = "https://www.strava.com/api/v3/athlete/activities"
 const uploadsURLStr = "https://www.strava.com/api/v3/uploads"
+const uploadURLStr = "https://www.strava.com/api/v3/uploads/%d"
+const activityURLStr = "https://www.strava.com/api/v3/activities/%d"
 
-type tokenResponse struct {
-	AccessToken string `json:"access_token"`
-}
-
-type uploadResponse struct {
-	ID         int    `json:"id"`
-	ActivityID int    `json:"activity_id"`
-	Status     string `json:"status"`
-	Error      string `json:"error"`
-}
+const uploadPollInterval = 2 * time.Second
 
+// stravaImpl is safe for concurrent use; mu guards the token fields and
+// refreshMu serialises token refreshes.
 type stravaImpl struct {
+	mu          sync.RWMutex
+	refreshMu   sync.Mutex
 	accessToken string
+	token       Token
+	refresh     *RefreshOptions
 	client      *http.Client
+	budget      *rateBudget
+	// pollInterval is how often WaitForUpload checks an upload.
+	pollInterval time.Duration
 }
 
 func NewStrava() Strava {
-	result := stravaImpl{}
+	result := stravaImpl{budget: newRateBudget(), pollInterval: uploadPollInterval}
 	result.client = &http.Client{Timeout: 10 * time.Second}
 	return &result
 }
 
 func (s *stravaImpl) SetAccessToken(accessToken string) {
-	s.accessToken = accessToken
+	s.setToken(Token{AccessToken: accessToken})
 }
 
-func (s *stravaImpl) Authorise(clientID, clientSecret string) error {
-	codeChannel := make(chan string)
-	s.startHTTPServer(codeChannel)
-	browserURL := fmt.Sprintf(oauthAuthorizeURLStr, clientID)
-	fmt.Printf("Visit this URL in a browser: %s\n", browserURL)
-	// http://localhost:8001/?state=&code=a600f604ea6c9c15e39a59128db927096b2c7c64
-	select {
-	case code := <-codeChannel:
-		return s.tokenExchange(code, clientID, clientSecret)
-	}
+func (s *stravaImpl) SetToken(token Token) {
+	s.setToken(token)
 }
 
-func (s stravaImpl) startHTTPServer(codeChannel chan<- string) {
-	http.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
-		code := r.URL.Query().Get("code")
-		fmt.Fprintf(w, "Strava OAuth callback code received, please follow instructions in the console!")
-		codeChannel <- code
-	})
+func (s *stravaImpl) setToken(token Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken = token.AccessToken
+	s.token = token
+}
 
-	go func() {
-		err := http.ListenAndServe(":8001", nil)
-		if err != nil {
-			panic("ListenAndServe: " + err.Error())
-		}
-	}()
+func (s *stravaImpl) AccessToken() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.accessToken
 }
 
-func (s *stravaImpl) tokenExchange(code, clientID, clientSecret string) error {
-	form := url.Values{}
-	form.Set("client_id", clientID)
-	form.Set("client_secret", clientSecret)
-	form.Set("code", code)
-	request, err := http.NewRequest("POST", oauthTokenExchangeURLStr,
-		strings.NewReader(form.Encode()))
-	if err != nil {
-		return err
-	}
-	request.Header.Set("User-Agent", userAgent)
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := s.client.Do(request)
+func (s *stravaImpl) Token() Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.token
+}
+
+// RateLimit returns the API usage reported with the latest response.
+func (s *stravaImpl) RateLimit() RateLimit {
+	return s.budget.current()
+}
+
+// do sends an authorised API request once the rate budget allows it,
+// refreshing the access token first if it is about to expire. A request
+// refused with 429 Too Many Requests is retried after the budget resets, if
+// its body can be replayed.
+func (s *stravaImpl) do(request *http.Request) (*http.Response, error) {
+	accessToken, err := s.currentAccessToken()
 	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		msg, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Token exchange: unexpected status code : %d: %s", resp.StatusCode, msg)
+		return nil, err
 	}
-	decoder := json.NewDecoder(resp.Body)
-	var tokenResponse tokenResponse
-	err = decoder.Decode(&tokenResponse)
-	if err != nil {
-		return err
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	for {
+		s.budget.wait()
+		resp, err := s.client.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		s.budget.update(resp.Header, resp.StatusCode == http.StatusTooManyRequests)
+		if resp.StatusCode != http.StatusTooManyRequests || (request.Body != nil && request.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		slog.Debug("Strava rate limit exceeded, retrying", "url", request.URL.Path)
+		if request.GetBody != nil {
+			if request.Body, err = request.GetBody(); err != nil {
+				return nil, err
+			}
+		}
 	}
-	s.accessToken = tokenResponse.AccessToken
-	fmt.Printf("Set strava.accessToken: %s in ~/.gravasync\n", tokenResponse.AccessToken)
-	return nil
 }
 
-func (s stravaImpl) TopActivity() (*Activity, error) {
+func (s *stravaImpl) TopActivity() (*Activity, error) {
 	params := url.Values{}
 	params.Set("per_page", "1")
 	activitiesURL, err := url.Parse(activitiesURLStr)
@@ -125,8 +140,7 @@ func (s stravaImpl) TopActivity() (*Activity, error) {
 		return nil, err
 	}
 	request.Header.Set("User-Agent", userAgent)
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
-	resp, err := s.client.Do(request)
+	resp, err := s.do(request)
 	if err != nil {
 		return nil, err
 	}
@@ -147,61 +161,199 @@ func (s stravaImpl) TopActivity() (*Activity, error) {
 	return nil, nil
 }
 
-func (s stravaImpl) ImportTCX(activityName string, private bool, tcxBytes []byte) error {
-	var b bytes.Buffer
-	form := multipart.NewWriter(&b)
-	// https://stackoverflow.com/questions/20205796/golang-post-data-using-the-content-type-multipart-form-data
-	field, err := form.CreateFormFile("file", "activity.tcx")
+func (s *stravaImpl) Athlete() (*Athlete, error) {
+	request, err := http.NewRequest("GET", athleteURLStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	resp, err := s.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("GET athlete: unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var athlete Athlete
+	if err = decoder.Decode(&athlete); err != nil {
+		return nil, err
+	}
+	return &athlete, nil
+}
+
+// Deauthorize revokes the access token and all other tokens Strava has issued
+// to this application for the athlete.
+func (s *stravaImpl) Deauthorize() error {
+	request, err := http.NewRequest("POST", deauthorizeURLStr, nil)
 	if err != nil {
 		return err
 	}
-	if _, err = io.Copy(field, bytes.NewReader(tcxBytes)); err != nil {
+	request.Header.Set("User-Agent", userAgent)
+	resp, err := s.do(request)
+	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Deauthorize: unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	s.setToken(Token{})
+	return nil
+}
+
+func (s *stravaImpl) ImportTCX(activityName string, private bool, tcx io.Reader) (*Upload, error) {
+	return s.Import(activityName, private, "tcx", tcx)
+}
+
+// Import uploads an activity file, where dataType is one of Strava's upload
+// data types: fit, tcx or gpx, optionally with a .gz suffix. The multipart
+// body is streamed from data as the request is sent.
+func (s *stravaImpl) Import(activityName string, private bool, dataType string, data io.Reader) (*Upload, error) {
+	body, bodyWriter := io.Pipe()
+	defer body.Close()
+	form := multipart.NewWriter(bodyWriter)
+	go func() {
+		bodyWriter.CloseWithError(s.writeUploadForm(form, activityName, private, dataType, data))
+	}()
+
+	request, err := http.NewRequest("POST", uploadsURLStr, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := s.do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Upload activity: unexpected status code : %d: %s", resp.StatusCode, msg)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	var upload Upload
+	err = decoder.Decode(&upload)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Error != "" {
+		return nil, &UploadError{Message: upload.Error}
+	}
+	return &upload, nil
+}
+
+// writeUploadForm writes the upload fields followed by the file, so that
+// Strava sees the metadata before the possibly large file part.
+func (s *stravaImpl) writeUploadForm(form *multipart.Writer, activityName string, private bool, dataType string, data io.Reader) error {
 	// http://strava.github.io/api/v3/uploads/
-	if err = s.addMultipartField(form, "data_type", "tcx"); err != nil {
+	if err := s.addMultipartField(form, "data_type", dataType); err != nil {
 		return err
 	}
-	if err = s.addMultipartField(form, "name", activityName); err != nil {
+	if err := s.addMultipartField(form, "name", activityName); err != nil {
 		return err
 	}
 	if private {
-		if err = s.addMultipartField(form, "private", "1"); err != nil {
+		if err := s.addMultipartField(form, "private", "1"); err != nil {
 			return err
 		}
 	}
-	form.Close()
-
-	request, err := http.NewRequest("POST", uploadsURLStr, &b)
+	// https://stackoverflow.com/questions/20205796/golang-post-data-using-the-content-type-multipart-form-data
+	field, err := form.CreateFormFile("file", "activity."+dataType)
 	if err != nil {
 		return err
 	}
+	if _, err = io.Copy(field, data); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// Gzip compresses r on the fly, for uploading with a .gz data type.
+func Gzip(r io.Reader) io.ReadCloser {
+	compressed, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		_, err := io.Copy(gz, r)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		w.CloseWithError(err)
+	}()
+	return compressed
+}
+
+// WaitForUpload polls the upload status until Strava has created the activity
+// or rejected the file.
+func (s *stravaImpl) WaitForUpload(upload *Upload, timeout time.Duration) (*Upload, error) {
+	deadline := time.Now().Add(timeout)
+	for !upload.done() {
+		if time.Now().After(deadline) {
+			return upload, fmt.Errorf("Upload %d: still %q after %v", upload.ID, upload.Status, timeout)
+		}
+		time.Sleep(s.pollInterval)
+		var err error
+		if upload, err = s.getUpload(upload.ID); err != nil {
+			return nil, err
+		}
+	}
+	if upload.Error != "" {
+		return upload, &UploadError{UploadID: upload.ID, Message: upload.Error}
+	}
+	return upload, nil
+}
+
+func (s *stravaImpl) getUpload(uploadID int64) (*Upload, error) {
+	request, err := http.NewRequest("GET", fmt.Sprintf(uploadURLStr, uploadID), nil)
+	if err != nil {
+		return nil, err
+	}
 	request.Header.Set("User-Agent", userAgent)
-	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
-	request.Header.Set("Content-Type", form.FormDataContentType())
-	resp, err := s.client.Do(request)
+	resp, err := s.do(request)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode != http.StatusOK {
 		msg, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("Upload activity: unexpected status code : %d: %s", resp.StatusCode, msg)
+		return nil, fmt.Errorf("GET upload: unexpected status code : %d: %s", resp.StatusCode, msg)
 	}
 	decoder := json.NewDecoder(resp.Body)
-	var uploadResponse uploadResponse
-	err = decoder.Decode(&uploadResponse)
+	var upload Upload
+	if err = decoder.Decode(&upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (s *stravaImpl) UpdateActivity(activityID int64, update ActivityUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("PUT", fmt.Sprintf(activityURLStr, activityID), bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	if uploadResponse.Error != "" {
-		return fmt.Errorf(uploadResponse.Error)
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Content-Type", "application/json")
+	resp, err := s.do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Update activity: unexpected status code : %d: %s", resp.StatusCode, msg)
 	}
-	fmt.Println(uploadResponse.Status)
 	return nil
 }
 
-func (s stravaImpl) addMultipartField(form *multipart.Writer, fieldName, value string) error {
+func (s *stravaImpl) addMultipartField(form *multipart.Writer, fieldName, value string) error {
 	field, err := form.CreateFormField(fieldName)
 	if err != nil {
 		return err
@@ -1,8 +1,14 @@
 package strava
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/icalder/gravasync/gc"
@@ -30,15 +36,56 @@ func TestUploadActivity(t *testing.T) {
 		t.Fatal(fmt.Errorf("activity == nil"))
 	}
 	fmt.Println(activity)
-	tcxBytes, err := garminClient.ExportTCX(activity.ID)
+	export, err := garminClient.ExportTCX(activity.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer export.Close()
 
 	strava := NewStrava()
 	strava.SetAccessToken(os.Getenv("STRAVATOKEN"))
-	err = strava.ImportTCX(activity.Name, true, tcxBytes)
+	_, err = strava.ImportTCX(activity.Name, true, export)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestWriteUploadForm(t *testing.T) {
+	var b bytes.Buffer
+	form := multipart.NewWriter(&b)
+	var s stravaImpl
+	if err := s.writeUploadForm(form, "Morning Run", true, "tcx.gz", Gzip(strings.NewReader("<TrainingCenterDatabase/>"))); err != nil {
+		t.Fatal(err)
+	}
+	reader := multipart.NewReader(&b, form.Boundary())
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.FormName() == "file" {
+			if part.FileName() != "activity.tcx.gz" {
+				t.Fatalf("file name = %q", part.FileName())
+			}
+			gz, err := gzip.NewReader(part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields["file"] = string(data)
+			continue
+		}
+		value, _ := ioutil.ReadAll(part)
+		fields[part.FormName()] = string(value)
+	}
+	if fields["data_type"] != "tcx.gz" || fields["name"] != "Morning Run" || fields["private"] != "1" || fields["file"] != "<TrainingCenterDatabase/>" {
+		t.Fatalf("fields = %v", fields)
+	}
+}
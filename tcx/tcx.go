@@ -0,0 +1,188 @@
+package tcx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+const (
+	// Namespace is the Training Center Database v2 schema.
+	Namespace = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+	// ExtensionNamespace is the schema of the TPX and LX extensions.
+	ExtensionNamespace = "http://www.garmin.com/xmlschemas/ActivityExtension/v2"
+	xsiNamespace       = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Database is the root TrainingCenterDatabase element.
+type Database struct {
+	XMLName    xml.Name   `xml:"TrainingCenterDatabase"`
+	Xmlns      string     `xml:"xmlns,attr,omitempty"`
+	XmlnsXsi   string     `xml:"xmlns:xsi,attr,omitempty"`
+	Activities []Activity `xml:"Activities>Activity"`
+}
+
+type Activity struct {
+	Sport   string   `xml:"Sport,attr"`
+	ID      string   `xml:"Id"`
+	Laps    []Lap    `xml:"Lap"`
+	Notes   string   `xml:"Notes,omitempty"`
+	Creator *Creator `xml:"Creator,omitempty"`
+}
+
+type Lap struct {
+	StartTime           time.Time      `xml:"StartTime,attr"`
+	TotalTimeSeconds    float64        `xml:"TotalTimeSeconds"`
+	DistanceMeters      float64        `xml:"DistanceMeters"`
+	MaximumSpeed        *float64       `xml:"MaximumSpeed,omitempty"`
+	Calories            int            `xml:"Calories"`
+	AverageHeartRateBpm *HeartRate     `xml:"AverageHeartRateBpm,omitempty"`
+	MaximumHeartRateBpm *HeartRate     `xml:"MaximumHeartRateBpm,omitempty"`
+	Intensity           string         `xml:"Intensity"`
+	Cadence             *int           `xml:"Cadence,omitempty"`
+	TriggerMethod       string         `xml:"TriggerMethod"`
+	Track               []Trackpoint   `xml:"Track>Trackpoint"`
+	Notes               string         `xml:"Notes,omitempty"`
+	Extensions          *LapExtensions `xml:"Extensions,omitempty"`
+}
+
+type Trackpoint struct {
+	Time           time.Time             `xml:"Time"`
+	Position       *Position             `xml:"Position,omitempty"`
+	AltitudeMeters *float64              `xml:"AltitudeMeters,omitempty"`
+	DistanceMeters *float64              `xml:"DistanceMeters,omitempty"`
+	HeartRateBpm   *HeartRate            `xml:"HeartRateBpm,omitempty"`
+	Cadence        *int                  `xml:"Cadence,omitempty"`
+	SensorState    string                `xml:"SensorState,omitempty"`
+	Extensions     *TrackpointExtensions `xml:"Extensions,omitempty"`
+}
+
+type Position struct {
+	LatitudeDegrees  float64 `xml:"LatitudeDegrees"`
+	LongitudeDegrees float64 `xml:"LongitudeDegrees"`
+}
+
+type HeartRate struct {
+	Value int `xml:"Value"`
+}
+
+type TrackpointExtensions struct {
+	TPX *TPX `xml:"TPX,omitempty"`
+}
+
+// TPX holds the trackpoint extension values: speed in m/s, power in watts
+// and running cadence.
+type TPX struct {
+	Xmlns      string   `xml:"xmlns,attr,omitempty"`
+	Speed      *float64 `xml:"Speed,omitempty"`
+	RunCadence *int     `xml:"RunCadence,omitempty"`
+	Watts      *int     `xml:"Watts,omitempty"`
+}
+
+type LapExtensions struct {
+	LX *LX `xml:"LX,omitempty"`
+}
+
+// LX holds the lap extension averages.
+type LX struct {
+	Xmlns         string   `xml:"xmlns,attr,omitempty"`
+	AvgSpeed      *float64 `xml:"AvgSpeed,omitempty"`
+	AvgRunCadence *int     `xml:"AvgRunCadence,omitempty"`
+	MaxRunCadence *int     `xml:"MaxRunCadence,omitempty"`
+	AvgWatts      *int     `xml:"AvgWatts,omitempty"`
+	MaxWatts      *int     `xml:"MaxWatts,omitempty"`
+}
+
+type Creator struct {
+	XsiType   string   `xml:"xsi:type,attr,omitempty"`
+	Name      string   `xml:"Name"`
+	UnitID    uint32   `xml:"UnitId"`
+	ProductID uint16   `xml:"ProductID"`
+	Version   *Version `xml:"Version,omitempty"`
+}
+
+type Version struct {
+	VersionMajor int `xml:"VersionMajor"`
+	VersionMinor int `xml:"VersionMinor"`
+	BuildMajor   int `xml:"BuildMajor"`
+	BuildMinor   int `xml:"BuildMinor"`
+}
+
+// Parse decodes a Training Center XML document.
+func Parse(r io.Reader) (*Database, error) {
+	var db Database
+	if err := xml.NewDecoder(r).Decode(&db); err != nil {
+		return nil, err
+	}
+	return &db, nil
+}
+
+// ParseBytes decodes a Training Center XML document held in memory.
+func ParseBytes(data []byte) (*Database, error) {
+	return Parse(bytes.NewReader(data))
+}
+
+// Write encodes the database as an indented XML document, setting the
+// schema namespaces.
+func (db *Database) Write(w io.Writer) error {
+	db.setNamespaces()
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(db); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Bytes returns the encoded document.
+func (db *Database) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	if err := db.Write(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (db *Database) setNamespaces() {
+	db.Xmlns = Namespace
+	db.XmlnsXsi = xsiNamespace
+	for i := range db.Activities {
+		activity := &db.Activities[i]
+		if activity.Creator != nil && activity.Creator.XsiType == "" {
+			activity.Creator.XsiType = "Device_t"
+		}
+		for j := range activity.Laps {
+			lap := &activity.Laps[j]
+			if lap.Extensions != nil && lap.Extensions.LX != nil {
+				lap.Extensions.LX.Xmlns = ExtensionNamespace
+			}
+			for k := range lap.Track {
+				if ext := lap.Track[k].Extensions; ext != nil && ext.TPX != nil {
+					ext.TPX.Xmlns = ExtensionNamespace
+				}
+			}
+		}
+	}
+}
+
+// Trackpoints returns all of an activity's trackpoints in lap order.
+func (a Activity) Trackpoints() []Trackpoint {
+	var result []Trackpoint
+	for _, lap := range a.Laps {
+		result = append(result, lap.Track...)
+	}
+	return result
+}
+
+// Power returns the trackpoint's power in watts, if recorded.
+func (tp Trackpoint) Power() (int, bool) {
+	if tp.Extensions == nil || tp.Extensions.TPX == nil || tp.Extensions.TPX.Watts == nil {
+		return 0, false
+	}
+	return *tp.Extensions.TPX.Watts, true
+}
@@ -0,0 +1,119 @@
+package tcx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sample reads the run in the top level testdata, which the convert,
+// privacy and trim tests share.
+func sample(t *testing.T) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "run.tcx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParse(t *testing.T) {
+	db, err := ParseBytes(sample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Activities) != 1 || db.Activities[0].Sport != "Running" {
+		t.Fatalf("activities = %+v", db.Activities)
+	}
+	activity := db.Activities[0]
+	points := activity.Trackpoints()
+	if len(points) != 71 {
+		t.Fatalf("len(points) = %d", len(points))
+	}
+	first := points[0]
+	if !first.Time.Equal(time.Date(2017, 12, 30, 9, 15, 0, 0, time.UTC)) {
+		t.Fatalf("time = %v", first.Time)
+	}
+	if first.Position == nil || first.Position.LatitudeDegrees != 53.8 || *first.AltitudeMeters != 60.2 {
+		t.Fatalf("first = %+v", first)
+	}
+	if watts, ok := first.Power(); !ok || watts != 250 || *first.Extensions.TPX.RunCadence != 84 {
+		t.Fatalf("extensions = %+v", first.Extensions.TPX)
+	}
+	if activity.Creator == nil || activity.Creator.Name != "Forerunner 235" || activity.Creator.Version.VersionMinor != 10 {
+		t.Fatalf("creator = %+v", activity.Creator)
+	}
+	if problems := db.Validate(); len(Errors(problems)) != 0 || len(problems) != 1 {
+		t.Fatalf("problems = %v", problems)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	db, err := ParseBytes(sample(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := db.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), `xmlns="`+ExtensionNamespace+`"`) {
+		t.Fatalf("extension namespace missing:\n%s", out)
+	}
+	again, err := ParseBytes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points := again.Activities[0].Trackpoints()
+	if watts, ok := points[0].Power(); len(points) != 71 || !ok || watts != 250 {
+		t.Fatalf("round trip lost data:\n%s", out)
+	}
+	if again.Activities[0].Creator.UnitID != 3912345678 {
+		t.Fatalf("creator = %+v", again.Activities[0].Creator)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	db, _ := ParseBytes(sample(t))
+	lap := &db.Activities[0].Laps[0]
+	lap.Track[0].Time, lap.Track[1].Time = lap.Track[1].Time, lap.Track[0].Time
+	lap.Track[1].Position = nil
+	problems := db.Validate()
+	if len(Errors(problems)) != 1 || len(problems) != 2 {
+		t.Fatalf("problems = %v", problems)
+	}
+	if !strings.Contains(problems[0].String(), "before previous") {
+		t.Fatalf("problem = %s", problems[0])
+	}
+
+	empty := &Database{Activities: []Activity{{Laps: []Lap{{}}}}}
+	if errs := Errors(empty.Validate()); len(errs) != 1 || errs[0].Message != "no trackpoints" {
+		t.Fatalf("errors = %v", errs)
+	}
+}
+
+func TestValidateStream(t *testing.T) {
+	db, _ := ParseBytes(sample(t))
+	lap := &db.Activities[0].Laps[0]
+	lap.Track[0].Time, lap.Track[1].Time = lap.Track[1].Time, lap.Track[0].Time
+	lap.Track[1].Position = nil
+	for _, db := range []*Database{db, {Activities: []Activity{{Laps: []Lap{{}}}}}, {}} {
+		data, err := db.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := ValidateStream(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := db.Validate(); !reflect.DeepEqual(problems, want) {
+			t.Fatalf("problems = %v, want %v", problems, want)
+		}
+	}
+	if _, err := ValidateStream(strings.NewReader("<TrainingCenterDatabase")); err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
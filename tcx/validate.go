@@ -0,0 +1,188 @@
+package tcx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Severity of a validation problem. Errors mean Strava is likely to reject
+// the file or create a broken activity.
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is a validation finding. Activity and Lap are zero based, -1 when
+// the problem is not specific to one.
+type Problem struct {
+	Severity Severity
+	Activity int
+	Lap      int
+	Message  string
+}
+
+func (p Problem) String() string {
+	location := ""
+	if p.Activity >= 0 {
+		location = fmt.Sprintf("activity %d: ", p.Activity+1)
+	}
+	if p.Lap >= 0 {
+		location += fmt.Sprintf("lap %d: ", p.Lap+1)
+	}
+	return fmt.Sprintf("%s: %s%s", p.Severity, location, p.Message)
+}
+
+// Validate reports problems with the database: missing activities or
+// trackpoints, timestamps that go backwards and trackpoints without
+// positions.
+func (db *Database) Validate() []Problem {
+	var v validator
+	for _, activity := range db.Activities {
+		v.startActivity()
+		for _, lap := range activity.Laps {
+			v.startLap()
+			for _, tp := range lap.Track {
+				v.trackpoint(tp)
+			}
+			v.endLap()
+		}
+		v.endActivity()
+	}
+	return v.finish()
+}
+
+// ValidateStream reports the same problems as Validate while decoding r one
+// trackpoint at a time, so that large files need not be held in memory.
+func ValidateStream(r io.Reader) ([]Problem, error) {
+	var v validator
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return v.finish(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "Activity":
+				v.startActivity()
+			case "Lap":
+				v.startLap()
+			case "Trackpoint":
+				var tp Trackpoint
+				if err := decoder.DecodeElement(&tp, &t); err != nil {
+					return nil, err
+				}
+				v.trackpoint(tp)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "Activity":
+				v.endActivity()
+			case "Lap":
+				v.endLap()
+			}
+		}
+	}
+}
+
+// validator collects problems as activities, laps and trackpoints are
+// visited in document order.
+type validator struct {
+	problems   []Problem
+	activity   int
+	lap        int
+	activities int
+	// Counts for the current activity and lap.
+	total, withPosition, backwards, lapTotal int
+	previous                                 Trackpoint
+}
+
+func (v *validator) add(severity Severity, activity, lap int, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{severity, activity, lap, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) startActivity() {
+	v.activity = v.activities
+	v.activities++
+	v.lap = -1
+	v.total, v.withPosition, v.backwards = 0, 0, 0
+}
+
+func (v *validator) startLap() {
+	v.lap++
+	v.lapTotal = 0
+}
+
+func (v *validator) trackpoint(tp Trackpoint) {
+	if tp.Time.IsZero() {
+		v.add(Error, v.activity, v.lap, "trackpoint without a time")
+	} else if v.total > 0 && tp.Time.Before(v.previous.Time) {
+		v.backwards++
+		if v.backwards == 1 {
+			v.add(Error, v.activity, v.lap, "timestamp %s is before previous %s", tp.Time.Format("15:04:05"), v.previous.Time.Format("15:04:05"))
+		}
+	}
+	if tp.Position != nil {
+		v.withPosition++
+	}
+	v.previous = tp
+	v.total++
+	v.lapTotal++
+}
+
+func (v *validator) endLap() {
+	if v.lapTotal == 0 {
+		v.add(Warning, v.activity, v.lap, "no trackpoints")
+	}
+}
+
+func (v *validator) endActivity() {
+	a := v.activity
+	if v.lap < 0 {
+		v.add(Error, a, -1, "no laps")
+		return
+	}
+	switch {
+	case v.total == 0:
+		v.add(Error, a, -1, "no trackpoints")
+	case v.backwards > 1:
+		v.add(Error, a, -1, "%d timestamps go backwards", v.backwards)
+	}
+	if v.total > 0 && v.withPosition > 0 && v.withPosition < v.total {
+		v.add(Warning, a, -1, "%d of %d trackpoints have no position", v.total-v.withPosition, v.total)
+	} else if v.total > 0 && v.withPosition == 0 {
+		v.add(Warning, a, -1, "no trackpoints have a position")
+	}
+}
+
+func (v *validator) finish() []Problem {
+	if v.activities == 0 {
+		v.add(Error, -1, -1, "no activities")
+	}
+	return v.problems
+}
+
+// Errors returns just the error level problems.
+func Errors(problems []Problem) []Problem {
+	var result []Problem
+	for _, p := range problems {
+		if p.Severity == Error {
+			result = append(result, p)
+		}
+	}
+	return result
+}
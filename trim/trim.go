@@ -0,0 +1,276 @@
+package trim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/icalder/gravasync/convert"
+	"github.com/icalder/gravasync/tcx"
+)
+
+// Options control idle detection. Speeds are in m/s. A point is moving when
+// the average speed over the following Window lies between MinSpeed and
+// MaxSpeed; below is standing still, above is travelling in a vehicle.
+// Idle stretches shorter than MinIdle are left alone.
+type Options struct {
+	MinSpeed float64
+	// MaxSpeed defaults by sport: 7 m/s for running and other sports, 22 m/s
+	// for cycling.
+	MaxSpeed float64
+	Window   time.Duration
+	MinIdle  time.Duration
+}
+
+// DefaultOptions are used for any zero valued option.
+var DefaultOptions = Options{
+	MinSpeed: 0.5,
+	Window:   time.Minute,
+	MinIdle:  2 * time.Minute,
+}
+
+var defaultMaxSpeeds = map[string]float64{
+	"Running": 7,
+	"Biking":  22,
+	"Other":   7,
+}
+
+// Trimmer finds idle time at the start and end of activities.
+type Trimmer struct {
+	options Options
+}
+
+// New validates options, filling in defaults.
+func New(options Options) (*Trimmer, error) {
+	if options.MinSpeed == 0 {
+		options.MinSpeed = DefaultOptions.MinSpeed
+	}
+	if options.Window == 0 {
+		options.Window = DefaultOptions.Window
+	}
+	if options.MinIdle == 0 {
+		options.MinIdle = DefaultOptions.MinIdle
+	}
+	if options.MinSpeed < 0 || options.MaxSpeed < 0 || options.Window < 0 || options.MinIdle < 0 {
+		return nil, errors.New("Trim: options must not be negative")
+	}
+	if options.MaxSpeed > 0 && options.MaxSpeed <= options.MinSpeed {
+		return nil, fmt.Errorf("Trim: maxSpeed %v must be above minSpeed %v", options.MaxSpeed, options.MinSpeed)
+	}
+	return &Trimmer{options: options}, nil
+}
+
+// Suggestion describes how much to trim. Start and End bound the time to
+// keep; the zero Suggestion trims nothing.
+type Suggestion struct {
+	Start         time.Time
+	End           time.Time
+	StartIdle     time.Duration
+	EndIdle       time.Duration
+	StartDistance float64
+	EndDistance   float64
+}
+
+// IsEmpty reports whether there is nothing to trim.
+func (s Suggestion) IsEmpty() bool {
+	return s.StartIdle == 0 && s.EndIdle == 0
+}
+
+func (s Suggestion) String() string {
+	if s.IsEmpty() {
+		return "nothing to trim"
+	}
+	var parts []string
+	if s.StartIdle > 0 {
+		parts = append(parts, fmt.Sprintf("%v (%.2f km) from the start", s.StartIdle, s.StartDistance/1000))
+	}
+	if s.EndIdle > 0 {
+		parts = append(parts, fmt.Sprintf("%v (%.2f km) from the end", s.EndIdle, s.EndDistance/1000))
+	}
+	if len(parts) == 2 {
+		return "trim " + parts[0] + " and " + parts[1]
+	}
+	return "trim " + parts[0]
+}
+
+// Suggest looks for idle stretches in the first activity. Activities with no
+// moving points at all, such as indoor sessions without distance, are never
+// trimmed.
+func (t *Trimmer) Suggest(db *tcx.Database) Suggestion {
+	if len(db.Activities) == 0 {
+		return Suggestion{}
+	}
+	activity := db.Activities[0]
+	points := activity.Trackpoints()
+	if len(points) < 2 {
+		return Suggestion{}
+	}
+	maxSpeed := t.options.MaxSpeed
+	if maxSpeed == 0 {
+		if maxSpeed = defaultMaxSpeeds[activity.Sport]; maxSpeed == 0 {
+			maxSpeed = defaultMaxSpeeds["Other"]
+		}
+	}
+	cum := cumulative(points)
+
+	first, last, lastEnd := -1, -1, -1
+	j := 0
+	for i := range points {
+		if j <= i {
+			j = i + 1
+		}
+		for j < len(points) && points[j].Time.Sub(points[i].Time) < t.options.Window {
+			j++
+		}
+		if j == len(points) {
+			break
+		}
+		dt := points[j].Time.Sub(points[i].Time).Seconds()
+		speed := (cum[j] - cum[i]) / dt
+		if speed < t.options.MinSpeed || speed > maxSpeed {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last, lastEnd = i, j
+	}
+	if last < 0 {
+		return Suggestion{}
+	}
+
+	n := len(points) - 1
+	s := Suggestion{Start: points[0].Time, End: points[n].Time}
+	if idle := points[first].Time.Sub(points[0].Time); idle >= t.options.MinIdle {
+		s.Start, s.StartIdle, s.StartDistance = points[first].Time, idle, cum[first]-cum[0]
+	}
+	if idle := points[n].Time.Sub(points[lastEnd].Time); idle >= t.options.MinIdle {
+		s.End, s.EndIdle, s.EndDistance = points[lastEnd].Time, idle, cum[n]-cum[lastEnd]
+	}
+	return s
+}
+
+// Apply removes trackpoints outside the suggested span, dropping laps left
+// empty and reducing lap times and distances by what was removed. Trackpoint
+// distances are shifted to start from zero.
+func (s Suggestion) Apply(db *tcx.Database) {
+	if s.IsEmpty() {
+		return
+	}
+	for i := range db.Activities {
+		activity := &db.Activities[i]
+		points := activity.Trackpoints()
+		cum := cumulative(points)
+		var laps []tcx.Lap
+		offset := 0
+		for _, lap := range activity.Laps {
+			lapFirst, lapLast := offset, offset+len(lap.Track)-1
+			offset += len(lap.Track)
+			var kept []tcx.Trackpoint
+			first, last := -1, -1
+			for k, tp := range lap.Track {
+				if tp.Time.Before(s.Start) || tp.Time.After(s.End) {
+					continue
+				}
+				if first < 0 {
+					first = lapFirst + k
+				}
+				last = lapFirst + k
+				kept = append(kept, tp)
+			}
+			if len(kept) == len(lap.Track) {
+				laps = append(laps, lap)
+				continue
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			removedTime := points[first].Time.Sub(points[lapFirst].Time) + points[lapLast].Time.Sub(points[last].Time)
+			removedDistance := cum[first] - cum[lapFirst] + cum[lapLast] - cum[last]
+			lap.Track = kept
+			lap.StartTime = kept[0].Time
+			lap.TotalTimeSeconds = math.Max(0, lap.TotalTimeSeconds-removedTime.Seconds())
+			lap.DistanceMeters = math.Max(0, lap.DistanceMeters-removedDistance)
+			laps = append(laps, lap)
+		}
+		activity.Laps = laps
+		if len(laps) > 0 {
+			activity.ID = laps[0].StartTime.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		rebaseDistances(activity)
+	}
+}
+
+// SuggestFile decodes an activity file and suggests how to trim it.
+func (t *Trimmer) SuggestFile(data []byte, format string) (Suggestion, error) {
+	db, err := convert.Read(data, format)
+	if err != nil {
+		return Suggestion{}, err
+	}
+	return t.Suggest(db), nil
+}
+
+// ApplyFile trims an activity file, re-encoding it in the same format.
+func (s Suggestion) ApplyFile(data []byte, format string) ([]byte, error) {
+	if s.IsEmpty() {
+		return data, nil
+	}
+	db, err := convert.Read(data, format)
+	if err != nil {
+		return nil, err
+	}
+	s.Apply(db)
+	return convert.Write(db, format)
+}
+
+// cumulative returns the distance travelled at each point, from the recorded
+// distances if there are any and from positions otherwise.
+func cumulative(points []tcx.Trackpoint) []float64 {
+	cum := make([]float64, len(points))
+	recorded := false
+	for _, tp := range points {
+		if tp.DistanceMeters != nil {
+			recorded = true
+			break
+		}
+	}
+	var previous *tcx.Position
+	for i, tp := range points {
+		if i > 0 {
+			cum[i] = cum[i-1]
+		}
+		if recorded {
+			if tp.DistanceMeters != nil {
+				cum[i] = *tp.DistanceMeters
+			}
+			continue
+		}
+		if tp.Position == nil {
+			continue
+		}
+		if previous != nil {
+			cum[i] += convert.Distance(previous.LatitudeDegrees, previous.LongitudeDegrees, tp.Position.LatitudeDegrees, tp.Position.LongitudeDegrees)
+		}
+		previous = tp.Position
+	}
+	return cum
+}
+
+func rebaseDistances(activity *tcx.Activity) {
+	var base *float64
+	for _, lap := range activity.Laps {
+		for k := range lap.Track {
+			tp := &lap.Track[k]
+			if tp.DistanceMeters == nil {
+				continue
+			}
+			if base == nil {
+				start := *tp.DistanceMeters
+				base = &start
+			}
+			d := math.Max(0, *tp.DistanceMeters-*base)
+			tp.DistanceMeters = &d
+		}
+	}
+}
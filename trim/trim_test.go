@@ -0,0 +1,82 @@
+package trim
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/icalder/gravasync/tcx"
+)
+
+// sample reads the shared run: 5 minutes standing still, 20 minutes running
+// at 3 m/s then 10 minutes driving at 15 m/s.
+func sample(t *testing.T) *tcx.Database {
+	data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "run.tcx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := tcx.ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSuggestAndApply(t *testing.T) {
+	trimmer, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sample(t)
+	s := trimmer.Suggest(db)
+	if s.StartIdle < 4*time.Minute || s.StartIdle > 5*time.Minute {
+		t.Fatalf("start idle = %v", s.StartIdle)
+	}
+	if s.EndIdle < 9*time.Minute || s.EndIdle > 11*time.Minute || s.EndDistance < 8000 {
+		t.Fatalf("end idle = %v over %vm", s.EndIdle, s.EndDistance)
+	}
+
+	s.Apply(db)
+	activity := db.Activities[0]
+	if len(activity.Laps) != 2 {
+		t.Fatalf("laps = %d", len(activity.Laps))
+	}
+	points := activity.Trackpoints()
+	if !points[0].Time.Equal(s.Start) || !points[len(points)-1].Time.Equal(s.End) {
+		t.Fatalf("kept %v to %v, expected %v to %v", points[0].Time, points[len(points)-1].Time, s.Start, s.End)
+	}
+	if *points[0].DistanceMeters != 0 {
+		t.Fatalf("distances not rebased: %v", *points[0].DistanceMeters)
+	}
+	total := activity.Laps[0].DistanceMeters + activity.Laps[1].DistanceMeters
+	if total < 3500 || total > 4500 {
+		t.Fatalf("trimmed distance = %v", total)
+	}
+	if activity.Laps[0].StartTime != s.Start || activity.ID != s.Start.Format("2006-01-02T15:04:05.000Z") {
+		t.Fatalf("lap start %v, ID %s", activity.Laps[0].StartTime, activity.ID)
+	}
+}
+
+func TestNothingToTrim(t *testing.T) {
+	trimmer, err := New(Options{MaxSpeed: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sample(t)
+	if s := trimmer.Suggest(db); s.EndIdle != 0 || s.StartIdle == 0 {
+		t.Fatalf("suggestion = %+v", s)
+	}
+
+	trimmer, _ = New(Options{MinIdle: 10 * time.Minute, MaxSpeed: 20})
+	if s := trimmer.Suggest(db); !s.IsEmpty() {
+		t.Fatalf("expected no suggestion, got %v", s)
+	}
+
+	for _, tp := range db.Activities[0].Trackpoints() {
+		*tp.DistanceMeters = 0
+	}
+	if s := trimmer.Suggest(db); !s.IsEmpty() {
+		t.Fatalf("expected a stationary activity to be left alone, got %v", s)
+	}
+}